@@ -2,9 +2,15 @@ package auth
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/husio/feedstream/cache"
@@ -17,24 +23,100 @@ type AuthService interface {
 	// account for given user does not yet exist, it's being created.
 	LoginAsUser(context.Context, http.ResponseWriter, *User) error
 
-	// CurrentUser returns user connected to current request. It returns
-	// ErrNotAuthenticated if client is not authenticated with any account.
+	// CurrentUser returns user connected to current request. Only session
+	// cookie is accepted. It returns ErrNotAuthenticated if client is not
+	// authenticated with any account.
 	CurrentUser(ctx context.Context, r *http.Request) (*User, error)
 
+	// ClientUser returns user of third party client that authenticated
+	// the request with token returned by LoginWithAppPassword. Token must
+	// be provided with Authorization header, session cookie is not
+	// accepted. It returns ErrNotAuthenticated if client is not
+	// authenticated with any account.
+	ClientUser(ctx context.Context, r *http.Request) (*User, error)
+
 	// Providers returns list of all authentication providers registered
 	// within service.
 	Providers() []*Provider
+
+	// LinkIdentity connects given provider's user with existing account,
+	// so that it can be used to login into that account. It returns
+	// ErrIdentityInUse if identity is already connected with another
+	// account.
+	LinkIdentity(ctx context.Context, accountID int64, u *User) error
+
+	// Identities returns all provider identities connected with given
+	// account.
+	Identities(ctx context.Context, accountID int64) ([]*Identity, error)
+
+	// DeleteAccount removes account together with all its identities and
+	// sessions. Given cleanup function is called within the same
+	// transaction and must remove all data that belongs to the account.
+	DeleteAccount(ctx context.Context, accountID int64, cleanup func(pg.Connection) error) error
+
+	// CreateAppPassword creates password that can be used by third party
+	// clients to login into given account. Password is returned only
+	// once, because only its hash is stored. Fever API clients must use
+	// account name together with the password.
+	CreateAppPassword(ctx context.Context, accountID int64, name string) (*AppPassword, string, error)
+
+	// AppPasswords returns all application passwords of given account.
+	AppPasswords(ctx context.Context, accountID int64) ([]*AppPassword, error)
+
+	// DeleteAppPassword revokes application password, together with all
+	// sessions created using it.
+	DeleteAppPassword(ctx context.Context, accountID, appPasswordID int64) error
+
+	// LoginWithAppPassword creates user session for account that given
+	// application password belongs to and returns its token. Token must
+	// be provided with Authorization header, see ClientUser. It returns
+	// ErrNotAuthenticated if password is not valid.
+	LoginWithAppPassword(ctx context.Context, password string) (string, error)
+
+	// FeverUser returns user that given Fever API key belongs to. Key is
+	// md5 of account name and application password, separated with
+	// colon. It returns ErrNotAuthenticated if key is not valid.
+	FeverUser(ctx context.Context, apiKey string) (*User, error)
 }
 
 // User represents single user credentials.
 type User struct {
 	AccountID  int64     `db:"account_id"`
 	Provider   string    `db:"provider"`
+	Subject    string    `db:"subject"`
 	Name       string    `db:"name"`
 	ProfileURL string    `db:"profile_url"`
 	Created    time.Time `db:"created"`
 }
 
+// Identity represents single provider's user connected with an account.
+type Identity struct {
+	IdentityID int64     `db:"identity_id"`
+	AccountID  int64     `db:"account_id"`
+	Provider   string    `db:"provider"`
+	Subject    string    `db:"subject"`
+	Name       string    `db:"name"`
+	ProfileURL string    `db:"profile_url"`
+	Created    time.Time `db:"created"`
+}
+
+// Session represents single login of the browser or of the third party
+// client.
+type Session struct {
+	SessionID     string        `db:"session_id"`
+	AccountID     int64         `db:"account_id"`
+	AppPasswordID sql.NullInt64 `db:"app_password_id"`
+}
+
+// AppPassword represents password used by third party clients to access an
+// account.
+type AppPassword struct {
+	AppPasswordID int64     `db:"app_password_id"`
+	AccountID     int64     `db:"account_id"`
+	Name          string    `db:"name"`
+	Created       time.Time `db:"created"`
+}
+
 type Auth struct {
 	db        accountsDatabase
 	cache     cache.CacheService
@@ -57,50 +139,214 @@ func (a *Auth) LoginAsUser(ctx context.Context, w http.ResponseWriter, u *User)
 		return err
 	}
 
-	key := randstr.New(22)
-	const exp = 7 * 24 * time.Hour
-	if err := a.cache.Set(ctx, "auth:session:"+key, u, exp); err != nil {
-		return fmt.Errorf("cache failed: %s", err)
+	key, err := a.createSession(ctx, u, 0, 7*24*time.Hour)
+	if err != nil {
+		return err
 	}
-
 	http.SetCookie(w, &http.Cookie{
-		Name:  SessionCookie,
-		Value: key,
-		Path:  "/",
+		Name:     SessionCookie,
+		Value:    key,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
 	return nil
 }
 
+// createSession creates session of given user. Sessions of third party
+// clients are created with application password ID, while sessions of the
+// browser with zero.
+func (a *Auth) createSession(ctx context.Context, u *User, appPasswordID int64, exp time.Duration) (string, error) {
+	key := randstr.New(22)
+	if err := a.db.CreateSession(ctx, key, u.AccountID, appPasswordID, exp); err != nil {
+		return "", fmt.Errorf("cannot create session: %s", err)
+	}
+	// session is stored in the database, so cache failure is not critical
+	if err := a.cache.Set(ctx, sessionCacheKey(key, appPasswordID != 0), u, exp); err != nil {
+		log.Printf("cannot cache session: %s", err)
+	}
+	return key, nil
+}
+
+// sessionCacheKey returns cache key of given session. Sessions of third
+// party clients are kept separately, so that they cannot be used as a
+// browser session and the other way round.
+func sessionCacheKey(key string, client bool) string {
+	if client {
+		return "auth:session:client:" + key
+	}
+	return "auth:session:" + key
+}
+
 const SessionCookie = "s"
 
-// CurrentUser return user attached to given request if exists.
+// CurrentUser return user attached to given request if exists. Session key
+// is read from the cookie.
 func (a *Auth) CurrentUser(ctx context.Context, r *http.Request) (*User, error) {
 	c, err := r.Cookie(SessionCookie)
 	if err != nil {
-		// TODO check other places for authentication token
 		return nil, ErrNotAuthenticated
 	}
+	return a.sessionUser(ctx, c.Value, false)
+}
+
+// ClientUser return user attached to given request if exists. Session key
+// is read from Authorization header, as Google Reader clients send it.
+func (a *Auth) ClientUser(ctx context.Context, r *http.Request) (*User, error) {
+	const prefix = "GoogleLogin auth="
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return nil, ErrNotAuthenticated
+	}
+	return a.sessionUser(ctx, h[len(prefix):], true)
+}
 
-	cacheKey := "auth:session:" + c.Value
+// sessionUser returns user of the session with given key. Client flag
+// tells if session of third party client or of the browser is expected.
+func (a *Auth) sessionUser(ctx context.Context, key string, client bool) (*User, error) {
+	cacheKey := sessionCacheKey(key, client)
 	var u User
-	switch err := a.cache.Get(ctx, cacheKey, &u); err {
-	case nil:
+	err := a.cache.Get(ctx, cacheKey, &u)
+	if err == nil {
 		return &u, nil
-	case cache.ErrMiss:
+	}
+
+	// cache might have lost the session or be not available, in which
+	// case database is used
+	user, expires, dberr := a.db.SessionUser(ctx, key, client)
+	switch dberr {
+	case nil:
+		// all good
+	case pg.ErrNotFound:
 		return nil, ErrNotAuthenticated
 	default:
-		return nil, fmt.Errorf("storage backend failed: %s", err)
+		return nil, fmt.Errorf("storage backend failed: %s", dberr)
 	}
+
+	if err == cache.ErrMiss {
+		if err := a.cache.Set(ctx, cacheKey, user, time.Until(expires)); err != nil {
+			log.Printf("cannot cache session: %s", err)
+		}
+	} else {
+		log.Printf("cache failed, session loaded from database: %s", err)
+	}
+	return user, nil
 }
 
 func (a *Auth) Providers() []*Provider {
 	return append([]*Provider{}, a.providers...) // copy
 }
 
-var ErrNotAuthenticated = errors.New("not authenticated")
+func (a *Auth) LinkIdentity(ctx context.Context, accountID int64, u *User) error {
+	return a.db.LinkIdentity(ctx, accountID, *u)
+}
+
+func (a *Auth) Identities(ctx context.Context, accountID int64) ([]*Identity, error) {
+	return a.db.Identities(ctx, accountID)
+}
+
+func (a *Auth) DeleteAccount(ctx context.Context, accountID int64, cleanup func(pg.Connection) error) error {
+	sessions, err := a.db.DeleteAccount(ctx, accountID, cleanup)
+	if err != nil {
+		return err
+	}
+	a.forgetSessions(ctx, sessions)
+	return nil
+}
+
+// forgetSessions removes cached sessions, so that they cannot be used once
+// deleted from the database.
+func (a *Auth) forgetSessions(ctx context.Context, sessions []*Session) {
+	for _, s := range sessions {
+		if err := a.cache.Del(ctx, sessionCacheKey(s.SessionID, s.AppPasswordID.Valid)); err != nil && err != cache.ErrMiss {
+			log.Printf("cannot delete %d account session: %s", s.AccountID, err)
+		}
+	}
+}
+
+func (a *Auth) CreateAppPassword(ctx context.Context, accountID int64, name string) (*AppPassword, string, error) {
+	u, err := a.db.EnsureExists(ctx, User{AccountID: accountID})
+	if err != nil {
+		return nil, "", err
+	}
+	password := randstr.New(16)
+	feverKey := md5.Sum([]byte(u.Name + ":" + password))
+	// Fever key is a credential as well, so only its hash is stored
+	p, err := a.db.CreateAppPassword(ctx, accountID, name, hashAppPassword(password), hashAppPassword(hex.EncodeToString(feverKey[:])))
+	if err != nil {
+		return nil, "", err
+	}
+	return p, password, nil
+}
+
+func (a *Auth) AppPasswords(ctx context.Context, accountID int64) ([]*AppPassword, error) {
+	return a.db.AppPasswords(ctx, accountID)
+}
+
+func (a *Auth) DeleteAppPassword(ctx context.Context, accountID, appPasswordID int64) error {
+	sessions, err := a.db.DeleteAppPassword(ctx, accountID, appPasswordID)
+	if err != nil {
+		return err
+	}
+	a.forgetSessions(ctx, sessions)
+	return nil
+}
+
+func (a *Auth) LoginWithAppPassword(ctx context.Context, password string) (string, error) {
+	p, err := a.db.AppPasswordByHash(ctx, hashAppPassword(password))
+	switch err {
+	case nil:
+		// all good
+	case pg.ErrNotFound:
+		return "", ErrNotAuthenticated
+	default:
+		return "", fmt.Errorf("storage backend failed: %s", err)
+	}
+	u, err := a.db.EnsureExists(ctx, User{AccountID: p.AccountID})
+	if err != nil {
+		return "", err
+	}
+	// third party clients login rarely, so session is long living
+	return a.createSession(ctx, u, p.AppPasswordID, 90*24*time.Hour)
+}
+
+func (a *Auth) FeverUser(ctx context.Context, apiKey string) (*User, error) {
+	accountID, err := a.db.FeverKeyAccount(ctx, hashAppPassword(strings.ToLower(apiKey)))
+	switch err {
+	case nil:
+		return a.db.EnsureExists(ctx, User{AccountID: accountID})
+	case pg.ErrNotFound:
+		return nil, ErrNotAuthenticated
+	default:
+		return nil, fmt.Errorf("storage backend failed: %s", err)
+	}
+}
+
+// hashAppPassword returns hash of application password or Fever API key, as
+// stored in the database. Both are random and long, so slow hashing is not
+// needed.
+func hashAppPassword(password string) string {
+	h := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(h[:])
+}
+
+var (
+	ErrNotAuthenticated = errors.New("not authenticated")
+	ErrIdentityInUse    = errors.New("identity in use")
+)
 
 type accountsDatabase interface {
 	EnsureExists(context.Context, User) (*User, error)
+	LinkIdentity(ctx context.Context, accountID int64, u User) error
+	Identities(ctx context.Context, accountID int64) ([]*Identity, error)
+	CreateSession(ctx context.Context, key string, accountID, appPasswordID int64, exp time.Duration) error
+	SessionUser(ctx context.Context, key string, client bool) (*User, time.Time, error)
+	DeleteAccount(ctx context.Context, accountID int64, cleanup func(pg.Connection) error) ([]*Session, error)
+	CreateAppPassword(ctx context.Context, accountID int64, name, hash, feverKeyHash string) (*AppPassword, error)
+	AppPasswords(ctx context.Context, accountID int64) ([]*AppPassword, error)
+	DeleteAppPassword(ctx context.Context, accountID, appPasswordID int64) ([]*Session, error)
+	AppPasswordByHash(ctx context.Context, hash string) (*AppPassword, error)
+	FeverKeyAccount(ctx context.Context, hash string) (int64, error)
 }
 
 type accountsdb struct {
@@ -109,29 +355,281 @@ type accountsdb struct {
 
 var _ accountsDatabase = (*accountsdb)(nil)
 
+// EnsureExists returns user for given account ID or, if not provided, for
+// given provider's subject. If no account is connected with provider's
+// subject, new account is created.
 func (a *accountsdb) EnsureExists(ctx context.Context, u User) (*User, error) {
-	if u.AccountID == 0 {
-		_, err := a.db.Exec(`
-			INSERT INTO accounts (provider, name, profile_url, created)
-			SELECT $1, $2, $3, $4
-			WHERE NOT EXISTS (
-				SELECT * FROM accounts
-				WHERE provider = $1 AND profile_url = $3
-				LIMIT 1
-			)
-		`, u.Provider, u.Name, u.ProfileURL, time.Now())
+	if u.AccountID != 0 {
+		return a.byAccountID(ctx, u.AccountID)
+	}
+
+	switch user, err := a.bySubject(ctx, u.Provider, u.Subject); err {
+	case nil:
+		return user, nil
+	case pg.ErrNotFound:
+		// create below
+	default:
+		return nil, err
+	}
+
+	err := pg.WithTx(ctx, a.db, nil, func(tx pg.Connection) error {
+		now := time.Now()
+
+		// identities migrated from single provider accounts are using
+		// profile url as the subject
+		res, err := tx.ExecContext(ctx, `
+			UPDATE identities SET subject = $1
+			WHERE provider = $2 AND subject = $3 AND profile_url = $3
+		`, u.Subject, u.Provider, u.ProfileURL)
+		if err != nil {
+			return fmt.Errorf("cannot update legacy identity: %s", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			return nil
+		}
+
+		var accountID int64
+		err = tx.GetContext(ctx, &accountID, `
+			INSERT INTO accounts (name, created)
+			VALUES ($1, $2)
+			RETURNING account_id
+		`, u.Name, now)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("cannot create account: %s", err)
 		}
+		return insertIdentity(ctx, tx, accountID, u, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.bySubject(ctx, u.Provider, u.Subject)
+}
+
+func (a *accountsdb) byAccountID(ctx context.Context, accountID int64) (*User, error) {
+	var u User
+	err := a.db.GetContext(ctx, &u, `
+		SELECT
+			a.account_id,
+			a.name,
+			a.created,
+			i.provider,
+			i.subject,
+			i.profile_url
+		FROM
+			accounts a
+			INNER JOIN identities i ON i.account_id = a.account_id
+		WHERE
+			a.account_id = $1
+		ORDER BY
+			i.created ASC
+		LIMIT 1
+	`, accountID)
+	return &u, err
+}
+
+func (a *accountsdb) bySubject(ctx context.Context, provider, subject string) (*User, error) {
+	var u User
+	err := a.db.GetContext(ctx, &u, `
+		SELECT
+			a.account_id,
+			a.name,
+			a.created,
+			i.provider,
+			i.subject,
+			i.profile_url
+		FROM
+			accounts a
+			INNER JOIN identities i ON i.account_id = a.account_id
+		WHERE
+			i.provider = $1 AND i.subject = $2
+		LIMIT 1
+	`, provider, subject)
+	return &u, err
+}
+
+func (a *accountsdb) LinkIdentity(ctx context.Context, accountID int64, u User) error {
+	switch linked, err := a.bySubject(ctx, u.Provider, u.Subject); err {
+	case nil:
+		if linked.AccountID != accountID {
+			return ErrIdentityInUse
+		}
+		return nil
+	case pg.ErrNotFound:
+		// not yet used
+	default:
+		return err
+	}
+
+	err := insertIdentity(ctx, a.db, accountID, u, time.Now())
+	if err == pg.ErrConflict {
+		return ErrIdentityInUse
+	}
+	return err
+}
+
+func insertIdentity(ctx context.Context, e pg.Execer, accountID int64, u User, now time.Time) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO identities (account_id, provider, subject, name, profile_url, created)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, accountID, u.Provider, u.Subject, u.Name, u.ProfileURL, now)
+	if err == pg.ErrConflict {
+		return err
 	}
-	err := a.db.Get(&u, `
-			SELECT *
-			FROM accounts
+	if err != nil {
+		return fmt.Errorf("cannot create identity: %s", err)
+	}
+	return nil
+}
+
+func (a *accountsdb) Identities(ctx context.Context, accountID int64) ([]*Identity, error) {
+	var ids []*Identity
+	err := a.db.SelectContext(ctx, &ids, `
+		SELECT * FROM identities
+		WHERE account_id = $1
+		ORDER BY created ASC
+	`, accountID)
+	return ids, err
+}
+
+// CreateSession stores new session. Application password ID is zero if
+// session was not created by third party client.
+func (a *accountsdb) CreateSession(ctx context.Context, key string, accountID, appPasswordID int64, exp time.Duration) error {
+	now := time.Now()
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO sessions (session_id, account_id, app_password_id, created, expires)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+	`, key, accountID, appPasswordID, now, now.Add(exp))
+	return err
+}
+
+// SessionUser returns user of not expired session and session expiration
+// time. Client flag tells if session created by third party client or by
+// the browser is expected. It returns pg.ErrNotFound if session does not
+// exist or expired.
+func (a *accountsdb) SessionUser(ctx context.Context, key string, client bool) (*User, time.Time, error) {
+	var session struct {
+		AccountID int64 `db:"account_id"`
+		Expires   time.Time
+	}
+	err := a.db.GetContext(ctx, &session, `
+		SELECT account_id, expires FROM sessions
+		WHERE session_id = $1 AND expires > $2 AND (app_password_id IS NOT NULL) = $3
+		LIMIT 1
+	`, key, time.Now(), client)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	u, err := a.byAccountID(ctx, session.AccountID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return u, session.Expires, nil
+}
+
+// DeleteAccount removes account and returns all sessions that were
+// connected with it.
+func (a *accountsdb) DeleteAccount(ctx context.Context, accountID int64, cleanup func(pg.Connection) error) ([]*Session, error) {
+	var sessions []*Session
+	err := pg.WithTx(ctx, a.db, nil, func(tx pg.Connection) error {
+		if cleanup != nil {
+			if err := cleanup(tx); err != nil {
+				return fmt.Errorf("cannot cleanup account data: %s", err)
+			}
+		}
+
+		sessions = nil
+		err := tx.SelectContext(ctx, &sessions, `
+			DELETE FROM sessions
 			WHERE account_id = $1
-				OR (provider = $2 OR profile_url = $3)
-			LIMIT 1
-	`, u.AccountID, u.Provider, u.ProfileURL)
+			RETURNING session_id, account_id, app_password_id
+		`, accountID)
+		if err != nil {
+			return fmt.Errorf("cannot delete sessions: %s", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM accounts WHERE account_id = $1
+		`, accountID)
+		if err != nil {
+			return fmt.Errorf("cannot delete account: %s", err)
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+func (a *accountsdb) CreateAppPassword(ctx context.Context, accountID int64, name, hash, feverKeyHash string) (*AppPassword, error) {
+	var p AppPassword
+	err := a.db.GetContext(ctx, &p, `
+		INSERT INTO app_passwords (account_id, name, hash, fever_key, created)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING app_password_id, account_id, name, created
+	`, accountID, name, hash, feverKeyHash, time.Now())
 	if err != nil {
+		return nil, err
 	}
-	return &u, err
+	return &p, nil
+}
+
+func (a *accountsdb) AppPasswords(ctx context.Context, accountID int64) ([]*AppPassword, error) {
+	var passwords []*AppPassword
+	err := a.db.SelectContext(ctx, &passwords, `
+		SELECT app_password_id, account_id, name, created
+		FROM app_passwords
+		WHERE account_id = $1
+		ORDER BY created ASC
+	`, accountID)
+	return passwords, err
+}
+
+// DeleteAppPassword removes application password and returns all sessions
+// that were created using it.
+func (a *accountsdb) DeleteAppPassword(ctx context.Context, accountID, appPasswordID int64) ([]*Session, error) {
+	var sessions []*Session
+	err := pg.WithTx(ctx, a.db, nil, func(tx pg.Connection) error {
+		sessions = nil
+		err := tx.SelectContext(ctx, &sessions, `
+			DELETE FROM sessions
+			WHERE account_id = $1 AND app_password_id = $2
+			RETURNING session_id, account_id, app_password_id
+		`, accountID, appPasswordID)
+		if err != nil {
+			return fmt.Errorf("cannot delete sessions: %s", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM app_passwords
+			WHERE account_id = $1 AND app_password_id = $2
+		`, accountID, appPasswordID)
+		if err != nil {
+			return fmt.Errorf("cannot delete app password: %s", err)
+		}
+		return nil
+	})
+	return sessions, err
+}
+
+// AppPasswordByHash returns password with given hash. It returns
+// pg.ErrNotFound if there is no such password.
+func (a *accountsdb) AppPasswordByHash(ctx context.Context, hash string) (*AppPassword, error) {
+	var p AppPassword
+	err := a.db.GetContext(ctx, &p, `
+		SELECT app_password_id, account_id, name, created
+		FROM app_passwords
+		WHERE hash = $1
+		LIMIT 1
+	`, hash)
+	return &p, err
+}
+
+// FeverKeyAccount returns ID of the account that Fever API key with given
+// hash belongs to. It returns pg.ErrNotFound if there is no such key.
+func (a *accountsdb) FeverKeyAccount(ctx context.Context, hash string) (int64, error) {
+	var accountID int64
+	err := a.db.GetContext(ctx, &accountID, `
+		SELECT account_id FROM app_passwords
+		WHERE fever_key = $1
+		LIMIT 1
+	`, hash)
+	return accountID, err
 }
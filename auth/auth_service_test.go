@@ -2,26 +2,36 @@ package auth
 
 import (
 	"context"
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/husio/feedstream/cache"
 	"github.com/husio/feedstream/pg"
 	"github.com/husio/feedstream/pg/pgtest"
 )
 
+func TestMain(m *testing.M) {
+	os.Exit(pgtest.Main(m))
+}
+
 func TestAccountsDatabaseEnsureExists(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	db := pg.Use(pgtest.CreateDB(t, nil))
-	defer db.Close()
-
-	pgtest.LoadSQLString(t, db, Schema)
+	db := pgtest.TxDB(t, nil)
 
 	a := accountsdb{db: db}
 
 	u, err := a.EnsureExists(ctx, User{
 		Provider:   "x",
+		Subject:    "1",
 		Name:       "JohnSmith",
 		ProfileURL: "https://example.com/johnsmith",
 	})
@@ -32,13 +42,13 @@ func TestAccountsDatabaseEnsureExists(t *testing.T) {
 		t.Fatalf("no account id assigned: %+v", u)
 	}
 
-	// user must match by profider/profile
+	// user must match by provider/subject
 	u2, err := a.EnsureExists(ctx, User{
-		Provider:   "x",
-		ProfileURL: "https://example.com/johnsmith",
+		Provider: "x",
+		Subject:  "1",
 	})
 	if err != nil {
-		t.Fatalf("cannot ensure user matching by profile/provider: %s", err)
+		t.Fatalf("cannot ensure user matching by provider/subject: %s", err)
 	}
 	if !reflect.DeepEqual(u, u2) {
 		t.Fatalf("user difference: \n%#v\n%#v", u, u2)
@@ -55,14 +65,19 @@ func TestAccountsDatabaseEnsureExists(t *testing.T) {
 		t.Fatalf("user difference: \n%#v\n%#v", u, u3)
 	}
 
-	// radom user
-	_, err = a.EnsureExists(ctx, User{
+	// another user of the same provider must get separate account
+	u4, err := a.EnsureExists(ctx, User{
 		Provider:   "x",
+		Subject:    "2",
+		Name:       "RandomUser",
 		ProfileURL: "https://example.com/randomuser",
 	})
 	if err != nil {
 		t.Fatalf("cannot create random user: %s", err)
 	}
+	if u4.AccountID == u.AccountID {
+		t.Fatalf("random user logged in as %d account", u.AccountID)
+	}
 
 	var cnt int
 	if err := db.Get(&cnt, `SELECT COUNT(*) FROM accounts`); err != nil {
@@ -72,3 +87,260 @@ func TestAccountsDatabaseEnsureExists(t *testing.T) {
 		t.Fatalf("want two accounts, got %d", cnt)
 	}
 }
+
+func TestAccountsDatabaseLinkIdentity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+
+	a := accountsdb{db: db}
+
+	u, err := a.EnsureExists(ctx, User{
+		Provider:   "x",
+		Subject:    "1",
+		Name:       "JohnSmith",
+		ProfileURL: "https://example.com/johnsmith",
+	})
+	if err != nil {
+		t.Fatalf("cannot create user: %s", err)
+	}
+
+	other := User{
+		Provider:   "y",
+		Subject:    "john",
+		Name:       "John",
+		ProfileURL: "https://example.com/john",
+	}
+	if err := a.LinkIdentity(ctx, u.AccountID, other); err != nil {
+		t.Fatalf("cannot link identity: %s", err)
+	}
+	// linking the same identity again is allowed
+	if err := a.LinkIdentity(ctx, u.AccountID, other); err != nil {
+		t.Fatalf("cannot link identity again: %s", err)
+	}
+
+	linked, err := a.EnsureExists(ctx, other)
+	if err != nil {
+		t.Fatalf("cannot login with linked identity: %s", err)
+	}
+	if linked.AccountID != u.AccountID {
+		t.Fatalf("want account %d, got %d", u.AccountID, linked.AccountID)
+	}
+
+	ids, err := a.Identities(ctx, u.AccountID)
+	if err != nil {
+		t.Fatalf("cannot list identities: %s", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("want two identities, got %d", len(ids))
+	}
+
+	u2, err := a.EnsureExists(ctx, User{
+		Provider:   "x",
+		Subject:    "2",
+		Name:       "RandomUser",
+		ProfileURL: "https://example.com/randomuser",
+	})
+	if err != nil {
+		t.Fatalf("cannot create random user: %s", err)
+	}
+	if err := a.LinkIdentity(ctx, u2.AccountID, other); err != ErrIdentityInUse {
+		t.Fatalf("want ErrIdentityInUse, got %v", err)
+	}
+}
+
+type fakeSessionsDB struct {
+	accountsDatabase
+
+	sessions map[string]*User
+	clients  map[string]*User
+}
+
+func (db *fakeSessionsDB) SessionUser(ctx context.Context, key string, client bool) (*User, time.Time, error) {
+	sessions := db.sessions
+	if client {
+		sessions = db.clients
+	}
+	u, ok := sessions[key]
+	if !ok {
+		return nil, time.Time{}, pg.ErrNotFound
+	}
+	return u, time.Now().Add(time.Hour), nil
+}
+
+type failingCache struct {
+	cache.CacheService
+}
+
+func (failingCache) Get(ctx context.Context, key string, dest interface{}) error {
+	return cache.ErrUnavailable
+}
+
+func (failingCache) Set(ctx context.Context, key string, value interface{}, exp time.Duration) error {
+	return cache.ErrUnavailable
+}
+
+func TestCurrentUserSessionFallback(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeSessionsDB{
+		sessions: map[string]*User{
+			"s3cr3t": {AccountID: 42, Name: "bob"},
+		},
+	}
+
+	request := func(session string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: SessionCookie, Value: session})
+		return r
+	}
+
+	memcache := cache.NewCacheService(cache.NewLocalMemCache())
+	caches := map[string]cache.CacheService{
+		"miss":    memcache,
+		"failing": failingCache{},
+	}
+	for name, c := range caches {
+		a := &Auth{db: db, cache: c}
+
+		u, err := a.CurrentUser(ctx, request("s3cr3t"))
+		if err != nil {
+			t.Fatalf("%s: cannot get current user: %s", name, err)
+		}
+		if u.AccountID != 42 {
+			t.Fatalf("%s: unexpected user: %+v", name, u)
+		}
+
+		if _, err := a.CurrentUser(ctx, request("invalid")); err != ErrNotAuthenticated {
+			t.Fatalf("%s: want ErrNotAuthenticated, got %v", name, err)
+		}
+	}
+
+	// session loaded from database is cached
+	var u User
+	if err := memcache.Get(ctx, "auth:session:s3cr3t", &u); err != nil || u.AccountID != 42 {
+		t.Fatalf("session not cached: %+v, %v", u, err)
+	}
+}
+
+func TestClientUser(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeSessionsDB{
+		sessions: map[string]*User{
+			"br0ws3r": {AccountID: 42, Name: "bob"},
+		},
+		clients: map[string]*User{
+			"s3cr3t": {AccountID: 42, Name: "bob"},
+		},
+	}
+	a := &Auth{db: db, cache: cache.NewCacheService(cache.NewLocalMemCache())}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "GoogleLogin auth=s3cr3t")
+	u, err := a.ClientUser(ctx, r)
+	if err != nil {
+		t.Fatalf("cannot get client user: %s", err)
+	}
+	if u.AccountID != 42 {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+	// client token is not a browser session, even if cached
+	if _, err := a.CurrentUser(ctx, r); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: "s3cr3t"})
+	if _, err := a.CurrentUser(ctx, r); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+
+	r.Header.Set("Authorization", "Bearer s3cr3t")
+	if _, err := a.ClientUser(ctx, r); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+
+	// browser session cannot be used by the client
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: "br0ws3r"})
+	if u, err := a.CurrentUser(ctx, r); err != nil || u.AccountID != 42 {
+		t.Fatalf("unexpected current user: %+v, %v", u, err)
+	}
+	if _, err := a.ClientUser(ctx, r); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+	r.Header.Set("Authorization", "GoogleLogin auth=br0ws3r")
+	if _, err := a.ClientUser(ctx, r); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+}
+
+func TestAppPasswords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+	a := NewAuthService(db, cache.NewCacheService(cache.NewLocalMemCache()), nil)
+
+	u, err := a.db.EnsureExists(ctx, User{
+		Provider:   "x",
+		Subject:    "1",
+		Name:       "JohnSmith",
+		ProfileURL: "https://example.com/johnsmith",
+	})
+	if err != nil {
+		t.Fatalf("cannot create user: %s", err)
+	}
+
+	p, password, err := a.CreateAppPassword(ctx, u.AccountID, "phone")
+	if err != nil {
+		t.Fatalf("cannot create app password: %s", err)
+	}
+	if p.Name != "phone" || p.AccountID != u.AccountID {
+		t.Fatalf("unexpected app password: %+v", p)
+	}
+
+	if _, err := a.LoginWithAppPassword(ctx, "invalid"); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+	token, err := a.LoginWithAppPassword(ctx, password)
+	if err != nil {
+		t.Fatalf("cannot login: %s", err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "GoogleLogin auth="+token)
+	if cu, err := a.ClientUser(ctx, r); err != nil || cu.AccountID != u.AccountID {
+		t.Fatalf("unexpected client user: %+v, %v", cu, err)
+	}
+
+	feverKey := fmt.Sprintf("%x", md5.Sum([]byte("JohnSmith:"+password)))
+	if fu, err := a.FeverUser(ctx, strings.ToUpper(feverKey)); err != nil || fu.AccountID != u.AccountID {
+		t.Fatalf("unexpected fever user: %+v, %v", fu, err)
+	}
+	var stored string
+	if err := db.Get(&stored, `SELECT fever_key FROM app_passwords WHERE app_password_id = $1`, p.AppPasswordID); err != nil {
+		t.Fatalf("cannot get fever key: %s", err)
+	}
+	if stored == feverKey {
+		t.Fatal("fever key stored in plain text")
+	}
+
+	if err := a.DeleteAppPassword(ctx, u.AccountID, p.AppPasswordID); err != nil {
+		t.Fatalf("cannot delete app password: %s", err)
+	}
+	// sessions created with revoked password are revoked as well
+	if _, err := a.ClientUser(ctx, r); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+	if _, _, err := a.db.SessionUser(ctx, token, true); err != pg.ErrNotFound {
+		t.Fatalf("want session deleted, got %v", err)
+	}
+	if ps, err := a.AppPasswords(ctx, u.AccountID); err != nil || len(ps) != 0 {
+		t.Fatalf("want no app passwords, got %d (%v)", len(ps), err)
+	}
+	if _, err := a.LoginWithAppPassword(ctx, password); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+	// revoked password cannot be used with Fever API either
+	if _, err := a.FeverUser(ctx, feverKey); err != ErrNotAuthenticated {
+		t.Fatalf("want ErrNotAuthenticated, got %v", err)
+	}
+}
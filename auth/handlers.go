@@ -5,6 +5,7 @@ import (
 	"encoding/base32"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -67,6 +68,7 @@ func OAuthLoginHandler(
 			ProviderCodename: provider.Codename,
 			State:            state,
 			Next:             r.FormValue("next"),
+			Link:             r.FormValue("link") != "",
 		}
 		if err := cacheSrv.Set(r.Context(), "authlogin:"+state, &info, 10*time.Minute); err != nil {
 			log.Printf("data not found in cache: %s", err)
@@ -81,6 +83,10 @@ type authInfo struct {
 	ProviderCodename string
 	State            string
 	Next             string
+
+	// Link is true when authenticated user should be connected with
+	// current account instead of being logged in.
+	Link bool
 }
 
 const stateCookie = "oauthState"
@@ -154,6 +160,28 @@ func OAuthLoginCallbackHandler(
 			return
 		}
 
+		if info.Link {
+			current, err := authSrv.CurrentUser(r.Context(), r)
+			if err != nil {
+				log.Printf("cannot link identity: %s", err)
+				renderAuthErr(w, tmpl, "You must be logged in to link an account.")
+				return
+			}
+			switch err := authSrv.LinkIdentity(r.Context(), current.AccountID, user); err {
+			case nil:
+				// all good
+			case ErrIdentityInUse:
+				renderAuthErr(w, tmpl, "Selected profile is already connected with another account.")
+				return
+			default:
+				log.Printf("cannot link identity: %s", err)
+				renderAuthErr(w, tmpl, "Cannot link authenticated user.")
+				return
+			}
+			http.Redirect(w, r, "/settings", http.StatusTemporaryRedirect)
+			return
+		}
+
 		if err := authSrv.LoginAsUser(r.Context(), w, user); err != nil {
 			log.Printf("cannot set current user: %s", err)
 			renderAuthErr(w, tmpl, "Cannot login authenticated user.")
@@ -168,6 +196,129 @@ func OAuthLoginCallbackHandler(
 	}
 }
 
+func SettingsHandler(
+	authSrv AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case ErrNotAuthenticated:
+			http.Redirect(w, r, "/login?next=/settings", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		identities, err := authSrv.Identities(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot list identities: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		passwords, err := authSrv.AppPasswords(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot list app passwords: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		context := struct {
+			CurrentUser  *User
+			Identities   []*Identity
+			Providers    []*Provider
+			AppPasswords []*AppPassword
+		}{
+			CurrentUser:  user,
+			Identities:   identities,
+			Providers:    authSrv.Providers(),
+			AppPasswords: passwords,
+		}
+		tmpl.Render(w, "settings.tmpl", context, http.StatusOK)
+	}
+}
+
+// CreateAppPasswordHandler creates new application password and displays
+// it. Password is displayed only once.
+func CreateAppPasswordHandler(
+	authSrv AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case ErrNotAuthenticated:
+			http.Redirect(w, r, "/login?next=/settings", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			name = "unnamed"
+		}
+		p, password, err := authSrv.CreateAppPassword(r.Context(), user.AccountID, name)
+		if err != nil {
+			log.Printf("cannot create app password: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		context := struct {
+			CurrentUser *User
+			AppPassword *AppPassword
+			Password    string
+		}{
+			CurrentUser: user,
+			AppPassword: p,
+			Password:    password,
+		}
+		tmpl.Render(w, "app_password.tmpl", context, http.StatusCreated)
+	}
+}
+
+func DeleteAppPasswordHandler(
+	authSrv AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case ErrNotAuthenticated:
+			http.Redirect(w, r, "/login?next=/settings", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		passwordID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			tmpl.RenderStd(w, http.StatusBadRequest)
+			return
+		}
+		if err := authSrv.DeleteAppPassword(r.Context(), user.AccountID, passwordID); err != nil {
+			log.Printf("cannot delete app password %d: %s", passwordID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+	}
+}
+
 func renderAuthErr(w http.ResponseWriter, tmpl ui.Renderer, message string) {
 	context := struct {
 		Message string
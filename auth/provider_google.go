@@ -36,6 +36,7 @@ func fetchGoogleUser(c *http.Client) (*User, error) {
 	defer resp.Body.Close()
 
 	var user struct {
+		ID          string `json:"id"`
 		DisplayName string `json:"displayName"`
 		URL         string `json:"url"`
 	}
@@ -43,12 +44,13 @@ func fetchGoogleUser(c *http.Client) (*User, error) {
 		return nil, err
 	}
 
-	if user.DisplayName == "" || user.URL == "" {
+	if user.ID == "" || user.DisplayName == "" || user.URL == "" {
 		return nil, ErrInvalidProfile
 	}
 
 	u := &User{
 		Provider:   "google",
+		Subject:    user.ID,
 		Name:       user.DisplayName,
 		ProfileURL: user.URL,
 	}
@@ -1,15 +1,153 @@
 package auth
 
-const Schema = `
+import "github.com/husio/feedstream/pg"
 
+func init() {
+	pg.RegisterMigrations("auth", migrations...)
+}
 
-CREATE TABLE IF NOT EXISTS
-accounts (
-	account_id SERIAL PRIMARY KEY,
-	provider TEXT NOT NULL,
-	name TEXT NOT NULL,
-	profile_url TEXT NOT NULL UNIQUE,
-	created TIMESTAMPTZ NOT NULL
-);
+var migrations = []pg.Migration{
+	{
+		Version: 1,
+		Name:    "create accounts",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			accounts (
+				account_id SERIAL PRIMARY KEY,
+				name TEXT NOT NULL,
+				created TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE accounts;
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "create identities",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			identities (
+				identity_id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(account_id) ON DELETE CASCADE,
+				provider TEXT NOT NULL,
+				subject TEXT NOT NULL, -- provider specific, stable user identifier
+				name TEXT NOT NULL,
+				profile_url TEXT NOT NULL,
+				created TIMESTAMPTZ NOT NULL,
 
-`
+				UNIQUE (provider, subject)
+			);
+
+			-- accounts used to keep single provider identity. Move it to
+			-- identities table, using profile url as the subject, until
+			-- the user logs in again.
+			DO $$
+			BEGIN
+				IF EXISTS (
+					SELECT * FROM information_schema.columns
+					WHERE table_name = 'accounts' AND column_name = 'provider'
+				) THEN
+					INSERT INTO identities (account_id, provider, subject, name, profile_url, created)
+						SELECT account_id, provider, profile_url, name, profile_url, created
+						FROM accounts
+						ON CONFLICT DO NOTHING;
+					ALTER TABLE accounts DROP COLUMN provider, DROP COLUMN profile_url;
+				END IF;
+			END
+			$$;
+		`,
+		Down: `
+			ALTER TABLE accounts
+				ADD COLUMN provider TEXT NOT NULL DEFAULT '',
+				ADD COLUMN profile_url TEXT NOT NULL DEFAULT '';
+			UPDATE accounts a SET provider = i.provider, profile_url = i.profile_url
+				FROM (
+					SELECT DISTINCT ON (account_id) account_id, provider, profile_url
+					FROM identities
+					ORDER BY account_id, created ASC
+				) i
+				WHERE a.account_id = i.account_id;
+			DROP TABLE identities;
+		`,
+	},
+	{
+		Version: 3,
+		Name:    "create sessions",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			sessions (
+				session_id TEXT PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(account_id) ON DELETE CASCADE,
+				created TIMESTAMPTZ NOT NULL,
+				expires TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE sessions;
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "create app passwords",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			app_passwords (
+				app_password_id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(account_id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				hash TEXT NOT NULL UNIQUE, -- sha256 of the password
+				created TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE app_passwords;
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "add app passwords fever key",
+		Up: `
+			-- md5 of "<account name>:<password>", as computed by Fever
+			-- API clients. Cannot be computed for existing passwords.
+			ALTER TABLE app_passwords ADD COLUMN IF NOT EXISTS fever_key TEXT UNIQUE;
+		`,
+		Down: `
+			ALTER TABLE app_passwords DROP COLUMN fever_key;
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "add sessions app password",
+		Up: `
+			-- sessions created by third party clients. Such sessions
+			-- are authenticated with Authorization header only and
+			-- revoked together with the password.
+			ALTER TABLE sessions ADD COLUMN IF NOT EXISTS
+				app_password_id INTEGER REFERENCES app_passwords(app_password_id) ON DELETE CASCADE;
+
+			-- it is not known which password was used to create
+			-- existing sessions, so all long living ones are removed
+			DELETE FROM sessions WHERE expires > created + interval '30 days';
+		`,
+		Down: `
+			ALTER TABLE sessions DROP COLUMN app_password_id;
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "hash app passwords fever key",
+		Up: `
+			-- Fever API key is sent by clients as it is, so storing
+			-- it would leak working credentials. Keep sha256 of the
+			-- key instead, the same way password is kept.
+			UPDATE app_passwords
+				SET fever_key = encode(sha256(convert_to(fever_key, 'UTF8')), 'hex')
+				WHERE fever_key IS NOT NULL;
+		`,
+		Down: `
+			-- hashed keys cannot be restored
+			UPDATE app_passwords SET fever_key = NULL;
+		`,
+	},
+}
@@ -0,0 +1,58 @@
+// Package blob provides storage for binary content of arbitrary size,
+// identified by a key.
+//
+// Keys are slash separated paths, for example "archive/42.html". Only
+// letters, digits and "-", "_", "." characters are allowed within a path
+// segment.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Store persists blobs.
+type Store interface {
+	// Put stores content read from given reader under given key. Content
+	// previously stored under the same key is replaced.
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	// Get returns reader of the content stored under given key. It returns
+	// ErrNotFound if key is not used. Returned reader must be closed.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes content stored under given key. Deleting key that is
+	// not used is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	// ErrNotFound is returned when blob does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidKey is returned when given key is not a valid blob key.
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// validKey returns true if given key can be used to store a blob.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+		for _, c := range segment {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			case c == '-', c == '_', c == '.':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
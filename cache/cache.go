@@ -26,17 +26,67 @@ type CacheService interface {
 	// Del deletes value under given key. It returns ErrCacheMiss if given
 	// key is not used.
 	Del(ctx context.Context, key string) error
+
+	// GetMulti loads values of many keys at once. Dests maps keys to
+	// destinations the values are loaded into. Keys that are not used
+	// are returned.
+	GetMulti(ctx context.Context, dests map[string]interface{}) (missing []string, err error)
+
+	// SetMulti set values of many keys at once, using the same expiration
+	// time for all of them.
+	SetMulti(ctx context.Context, items map[string]interface{}, exp time.Duration) error
+
+	// GetOrLoad get value stored under given key. If key is not used,
+	// loader is called to get the value, which is then stored with given
+	// expiration time.
+	//
+	// Concurrent calls for the same key share single loader call. To
+	// prevent many processes from loading the value at the same time when
+	// it expires, value might be loaded before it expires, with
+	// probability growing as the expiration time is approaching.
+	GetOrLoad(ctx context.Context, key string, dest interface{}, exp time.Duration, loader Loader) error
 }
 
+// Loader returns value that should be stored in the cache.
+type Loader func(ctx context.Context) (interface{}, error)
+
 // BareCacheService represents cache client. It is important is that it
 // represents cache. Provided implementation might flush it's memory at any
 // time and user must be prepared to loose all stored data.
+//
+// Bare service stores raw bytes. Use NewCacheService to store any
+// serializable value.
 type BareCacheService interface {
+	// Get value stored under given key. Returns ErrMiss if key is not
+	// used.
 	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set value under given key. If key is already in use, overwrite it's
+	// value with given one and set new expiration time.
 	Set(ctx context.Context, key string, value []byte, exp time.Duration) error
+
+	// Del deletes value under given key. It returns ErrMiss if given key
+	// is not used.
 	Del(ctx context.Context, key string) error
+
+	// IncrExisting atomically increments by delta value stored under
+	// given key and returns the result. Stored value must be decimal
+	// representation of unsigned integer. Result never goes below zero.
+	// Expiration time is not changed. It returns ErrMiss if given key is
+	// not used.
 	IncrExisting(ctx context.Context, key string, delta int64) (uint64, error)
+
+	// Add set value under given key only if key is not used. It returns
+	// ErrConflict if trying to set value for key that is already in use.
 	Add(ctx context.Context, key string, value []byte, exp time.Duration) error
+
+	// GetMulti returns values of many keys at once. Keys that are not
+	// used are not present in returned map.
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+
+	// SetMulti set values of many keys at once, using the same expiration
+	// time for all of them.
+	SetMulti(ctx context.Context, items map[string][]byte, exp time.Duration) error
 }
 
 var (
@@ -46,4 +96,8 @@ var (
 	// ErrConflict is returned when performing operation on existing key,
 	// which cause conflict.
 	ErrConflict = errors.New("conflict")
+
+	// ErrUnavailable is returned when cache backend is known to be not
+	// available and operation was not attempted.
+	ErrUnavailable = errors.New("cache unavailable")
 )
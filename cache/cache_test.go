@@ -72,6 +72,30 @@ func testCacheService(t *testing.T, ctx context.Context, c CacheService) {
 		t.Fatalf("%s bob compare: want %+v, got %+v", cname, bob, bob3)
 	}
 
+	// multi operations must work with both existing and missing keys
+	people := map[string]interface{}{
+		"alice": &person{Name: "alice", Age: 31},
+		"eve":   &person{Name: "eve", Age: 44},
+	}
+	if err := c.SetMulti(ctx, people, time.Minute); err != nil {
+		t.Fatalf("%s set multi: %s", cname, err)
+	}
+	var alice, eve, ghost person
+	missing, err := c.GetMulti(ctx, map[string]interface{}{
+		"alice": &alice,
+		"eve":   &eve,
+		"ghost": &ghost,
+	})
+	if err != nil {
+		t.Fatalf("%s get multi: %s", cname, err)
+	}
+	if len(missing) != 1 || missing[0] != "ghost" {
+		t.Fatalf("%s get multi: want ghost missing, got %v", cname, missing)
+	}
+	if !reflect.DeepEqual(people["alice"], &alice) || !reflect.DeepEqual(people["eve"], &eve) {
+		t.Fatalf("%s get multi: got %+v and %+v", cname, alice, eve)
+	}
+
 	// make sure the cache expires
 	// memcache does not tolerate duration more granular than 1s
 	time.Sleep(time.Second)
@@ -81,3 +105,92 @@ func testCacheService(t *testing.T, ctx context.Context, c CacheService) {
 		t.Fatalf("%s bob was supposed to expire, instead got %+v, %+v", cname, err, bob3)
 	}
 }
+
+// testBareCacheService run standard test set on given bare cache service
+// implementation. All implementations must pass this in order to be
+// compatible.
+func testBareCacheService(t *testing.T, ctx context.Context, c BareCacheService) {
+	cname := fmt.Sprintf("%T", c)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if _, err := c.Get(ctx, "ghost"); err != ErrMiss {
+		t.Fatalf("%s get: want ErrMiss, got %q", cname, err)
+	}
+	if err := c.Del(ctx, "ghost"); err != ErrMiss {
+		t.Fatalf("%s delete: want ErrMiss, got %q", cname, err)
+	}
+	if _, err := c.IncrExisting(ctx, "ghost", 1); err != ErrMiss {
+		t.Fatalf("%s incr: want ErrMiss, got %q", cname, err)
+	}
+
+	if err := c.Set(ctx, "bob", []byte("bob"), time.Minute); err != nil {
+		t.Fatalf("%s set: cannot set bob: %s", cname, err)
+	}
+	if raw, err := c.Get(ctx, "bob"); err != nil || string(raw) != "bob" {
+		t.Fatalf("%s get: want bob, got %q, %v", cname, raw, err)
+	}
+	if err := c.Add(ctx, "bob", []byte("bob"), time.Minute); err != ErrConflict {
+		t.Fatalf("%s add: want ErrConflict, got %+v", cname, err)
+	}
+	if _, err := c.IncrExisting(ctx, "bob", 1); err == nil {
+		t.Fatalf("%s incr: non numeric value incremented", cname)
+	}
+	if err := c.Del(ctx, "bob"); err != nil {
+		t.Fatalf("%s delete: cannot remove: %q", cname, err)
+	}
+
+	err := c.SetMulti(ctx, map[string][]byte{
+		"a": []byte("1"),
+		"b": []byte("2"),
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("%s set multi: %s", cname, err)
+	}
+	values, err := c.GetMulti(ctx, []string{"a", "ghost", "b"})
+	if err != nil {
+		t.Fatalf("%s get multi: %s", cname, err)
+	}
+	if len(values) != 2 || string(values["a"]) != "1" || string(values["b"]) != "2" {
+		t.Fatalf("%s get multi: unexpected result: %q", cname, values)
+	}
+	if values, err := c.GetMulti(ctx, nil); err != nil || len(values) != 0 {
+		t.Fatalf("%s get multi: want no values, got %q, %v", cname, values, err)
+	}
+
+	if err := c.Add(ctx, "counter", []byte("10"), time.Second); err != nil {
+		t.Fatalf("%s add: cannot add counter: %s", cname, err)
+	}
+	for _, step := range []struct {
+		delta int64
+		want  uint64
+	}{
+		{5, 15},
+		{0, 15},
+		{-3, 12},
+		{-100, 0},
+		{7, 7},
+	} {
+		n, err := c.IncrExisting(ctx, "counter", step.delta)
+		if err != nil {
+			t.Fatalf("%s incr %d: %s", cname, step.delta, err)
+		}
+		if n != step.want {
+			t.Fatalf("%s incr %d: want %d, got %d", cname, step.delta, step.want, n)
+		}
+	}
+	if raw, err := c.Get(ctx, "counter"); err != nil || string(raw) != "7" {
+		t.Fatalf("%s get: want counter 7, got %q, %v", cname, raw, err)
+	}
+
+	// increment must not change the expiration time
+	time.Sleep(time.Second)
+
+	if _, err := c.Get(ctx, "counter"); err != ErrMiss {
+		t.Fatalf("%s counter was supposed to expire, instead got %+v", cname, err)
+	}
+	if _, err := c.IncrExisting(ctx, "counter", 1); err != ErrMiss {
+		t.Fatalf("%s incr: want ErrMiss, got %q", cname, err)
+	}
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Codec serializes values stored in the cache.
+type Codec interface {
+	// ID is unique codec identifier, stored together with serialized
+	// value, so that it can be decoded after configured codec changes.
+	ID() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec serializes values using encoding/json package.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec serializes values using encoding/gob package.
+	GobCodec Codec = gobCodec{}
+
+	// ProtoCodec serializes protocol buffer messages. Only values
+	// implementing proto.Message can be serialized.
+	ProtoCodec Codec = protoCodec{}
+)
+
+var codecs = map[byte]Codec{
+	JSONCodec.ID():  JSONCodec,
+	GobCodec.ID():   GobCodec,
+	ProtoCodec.ID(): ProtoCodec,
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte                                   { return 1 }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte { return 2 }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) ID() byte { return 3 }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protocol buffer message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a protocol buffer message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// Serialized value is stored within envelope, that starts with a header:
+//
+//	magic byte | envelope version | codec ID | flags
+//
+// If meta flag is set, header is followed by load metadata: expiration time
+// in unix nanoseconds and load duration in nanoseconds, both 8 bytes, big
+// endian.
+//
+// Values stored before envelope was introduced are JSON serialized, and
+// JSON document cannot start with magic byte, which is not valid UTF-8.
+const (
+	envelopeMagic   = 0xFE
+	envelopeVersion = 1
+	envelopeHeader  = 4
+	envelopeMeta    = 16
+
+	flagGzip = 1 << 0
+	flagMeta = 1 << 1
+)
+
+var errInvalidEnvelope = errors.New("invalid envelope")
+
+// loadMeta describes value stored by GetOrLoad.
+type loadMeta struct {
+	Expires time.Time
+	// Delta is the time it took to load the value.
+	Delta time.Duration
+}
+
+// encode serializes value using given codec and wraps it with envelope.
+// Payload is compressed if it's bigger than threshold, unless threshold is
+// not greater than zero. Metadata is optional.
+func encode(c Codec, compressAbove int, v interface{}, meta *loadMeta) ([]byte, error) {
+	payload, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags byte
+	if compressAbove > 0 && len(payload) > compressAbove {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(payload); err != nil {
+			return nil, fmt.Errorf("cannot compress: %s", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("cannot compress: %s", err)
+		}
+		payload = b.Bytes()
+		flags |= flagGzip
+	}
+
+	size := envelopeHeader
+	if meta != nil {
+		flags |= flagMeta
+		size += envelopeMeta
+	}
+	raw := make([]byte, size, size+len(payload))
+	raw[0] = envelopeMagic
+	raw[1] = envelopeVersion
+	raw[2] = c.ID()
+	raw[3] = flags
+	if meta != nil {
+		binary.BigEndian.PutUint64(raw[envelopeHeader:], uint64(meta.Expires.UnixNano()))
+		binary.BigEndian.PutUint64(raw[envelopeHeader+8:], uint64(meta.Delta))
+	}
+	return append(raw, payload...), nil
+}
+
+// decodeMeta returns metadata stored in the envelope, if any.
+func decodeMeta(raw []byte) (*loadMeta, bool) {
+	if len(raw) < envelopeHeader+envelopeMeta || raw[0] != envelopeMagic || raw[3]&flagMeta == 0 {
+		return nil, false
+	}
+	return &loadMeta{
+		Expires: time.Unix(0, int64(binary.BigEndian.Uint64(raw[envelopeHeader:]))),
+		Delta:   time.Duration(binary.BigEndian.Uint64(raw[envelopeHeader+8:])),
+	}, true
+}
+
+// decode deserializes value stored in envelope, using codec it was
+// serialized with. Raw JSON is accepted as well. Given codec is used in
+// addition to built-in codecs.
+func decode(c Codec, raw []byte, v interface{}) error {
+	if len(raw) == 0 || raw[0] != envelopeMagic {
+		return JSONCodec.Unmarshal(raw, v)
+	}
+	if len(raw) < envelopeHeader || raw[1] != envelopeVersion {
+		return errInvalidEnvelope
+	}
+	if c.ID() != raw[2] {
+		var ok bool
+		if c, ok = codecs[raw[2]]; !ok {
+			return fmt.Errorf("unknown codec %d", raw[2])
+		}
+	}
+	flags := raw[3]
+	payload := raw[envelopeHeader:]
+	if flags&flagMeta != 0 {
+		if len(payload) < envelopeMeta {
+			return errInvalidEnvelope
+		}
+		payload = payload[envelopeMeta:]
+	}
+	if flags&flagGzip != 0 {
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("cannot decompress: %s", err)
+		}
+		payload, err = ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("cannot decompress: %s", err)
+		}
+	}
+	return c.Unmarshal(payload, v)
+}
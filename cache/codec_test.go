@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCacheServiceCodecs(t *testing.T) {
+	codecs := map[string][]Option{
+		"json":            {WithCodec(JSONCodec)},
+		"gob":             {WithCodec(GobCodec)},
+		"json-compressed": {WithCodec(JSONCodec), WithCompression(1)},
+		"gob-compressed":  {WithCodec(GobCodec), WithCompression(1)},
+	}
+	for name, opts := range codecs {
+		t.Run(name, func(t *testing.T) {
+			testCacheService(t, context.Background(), NewCacheService(NewLocalMemCache(), opts...))
+		})
+	}
+}
+
+type protoPerson struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Age  int32  `protobuf:"varint,2,opt,name=age,proto3"`
+}
+
+func (m *protoPerson) Reset()         { *m = protoPerson{} }
+func (m *protoPerson) String() string { return proto.CompactTextString(m) }
+func (*protoPerson) ProtoMessage()    {}
+
+func TestCacheServiceProtoCodec(t *testing.T) {
+	ctx := context.Background()
+	c := NewCacheService(NewLocalMemCache(), WithCodec(ProtoCodec))
+
+	bob := &protoPerson{Name: "bob", Age: 52}
+	if err := c.Set(ctx, "bob", bob, time.Minute); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+	var got protoPerson
+	if err := c.Get(ctx, "bob", &got); err != nil {
+		t.Fatalf("cannot get: %s", err)
+	}
+	if !reflect.DeepEqual(bob, &got) {
+		t.Fatalf("want %+v, got %+v", bob, &got)
+	}
+
+	if err := c.Set(ctx, "x", struct{}{}, time.Minute); err == nil {
+		t.Fatal("non message value serialized")
+	}
+}
+
+func TestCacheServiceReadsOtherCodecs(t *testing.T) {
+	ctx := context.Background()
+	bare := NewLocalMemCache()
+
+	type person struct {
+		Name string
+		Age  int
+	}
+	bob := person{Name: "bob", Age: 52}
+
+	// values stored before envelope was introduced are raw JSON
+	if err := bare.Set(ctx, "legacy", []byte(`{"Name": "bob", "Age": 52}`), time.Minute); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+	if err := NewCacheService(bare, WithCodec(GobCodec)).Set(ctx, "gob", bob, time.Minute); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+
+	c := NewCacheService(bare, WithCodec(JSONCodec))
+	for _, key := range []string{"legacy", "gob"} {
+		var got person
+		if err := c.Get(ctx, key, &got); err != nil {
+			t.Fatalf("cannot get %s: %s", key, err)
+		}
+		if !reflect.DeepEqual(bob, got) {
+			t.Fatalf("%s: want %+v, got %+v", key, bob, got)
+		}
+	}
+}
+
+func TestCacheServiceCompression(t *testing.T) {
+	ctx := context.Background()
+	bare := NewLocalMemCache()
+	c := NewCacheService(bare, WithCompression(100))
+
+	long := strings.Repeat("feedstream ", 100)
+	if err := c.Set(ctx, "long", long, time.Minute); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+	if err := c.Set(ctx, "short", "feedstream", time.Minute); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+
+	raw, err := bare.Get(ctx, "long")
+	if err != nil {
+		t.Fatalf("cannot get raw: %s", err)
+	}
+	if raw[3]&flagGzip == 0 || len(raw) >= len(long) {
+		t.Fatalf("value not compressed: %d bytes", len(raw))
+	}
+	raw, err = bare.Get(ctx, "short")
+	if err != nil {
+		t.Fatalf("cannot get raw: %s", err)
+	}
+	if raw[3]&flagGzip != 0 {
+		t.Fatal("short value compressed")
+	}
+
+	var got string
+	if err := c.Get(ctx, "long", &got); err != nil {
+		t.Fatalf("cannot get: %s", err)
+	}
+	if got != long {
+		t.Fatalf("invalid value: %q", got)
+	}
+}
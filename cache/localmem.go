@@ -1,15 +1,27 @@
 package cache
 
 import (
+	"container/list"
 	"context"
-	"encoding/json"
+	"fmt"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// LocalMemCache is bare cache service keeping all items in the process
+// memory. When size limit is reached, least recently used items are evicted.
 type LocalMemCache struct {
-	mu  sync.Mutex
-	mem map[string]*cacheitem
+	opts options
+
+	mu    sync.Mutex
+	mem   map[string]*list.Element
+	lru   *list.List // most recently used item is at the front
+	bytes int64
+	stats Stats
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 type cacheitem struct {
@@ -18,79 +30,281 @@ type cacheitem struct {
 	ValidTill time.Time
 }
 
-var _ CacheService = (*LocalMemCache)(nil)
+func (it *cacheitem) size() int64 {
+	return int64(len(it.Key) + len(it.Value))
+}
+
+var _ BareCacheService = (*LocalMemCache)(nil)
+
+// Option configures cache service.
+type Option func(*options)
+
+type options struct {
+	maxEntries int
+	maxBytes   int64
+	janitor    time.Duration
+
+	codec         Codec
+	compressAbove int
+	beta          float64
+
+	timeout          time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+}
+
+// WithMaxEntries limits the number of items stored by local memory cache.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// WithMaxBytes limits the total size of keys and values stored by local
+// memory cache.
+func WithMaxBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
 
-// NewLocalMemCache returns local memory cache intance. This is strictly for
-// testing and must not be used for end application.
-func NewLocalMemCache() *LocalMemCache {
-	return &LocalMemCache{
-		mem: make(map[string]*cacheitem),
+// WithJanitor starts background process that removes expired items from
+// local memory cache every interval. Without janitor, expired items are
+// removed only when accessed or evicted. Janitor is stopped by Close.
+func WithJanitor(interval time.Duration) Option {
+	return func(o *options) {
+		o.janitor = interval
+	}
+}
+
+// Stats represents cache usage counters.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Expired   uint64
+	Entries   int
+	Bytes     int64
+}
+
+// NewLocalMemCache returns local memory cache intance. By default, the
+// number of stored items is not limited.
+func NewLocalMemCache(opts ...Option) *LocalMemCache {
+	c := &LocalMemCache{
+		mem:  make(map[string]*list.Element),
+		lru:  list.New(),
+		stop: make(chan struct{}),
+	}
+	for _, fn := range opts {
+		fn(&c.opts)
+	}
+	if c.opts.janitor > 0 {
+		go c.runJanitor(c.opts.janitor)
 	}
+	return c
 }
 
-func (c *LocalMemCache) Get(ctx context.Context, key string, dest interface{}) error {
+func (c *LocalMemCache) runJanitor(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-t.C:
+			c.removeExpired(now)
+		}
+	}
+}
+
+func (c *LocalMemCache) removeExpired(now time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	it, ok := c.mem[key]
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if it := el.Value.(*cacheitem); it.ValidTill.Before(now) {
+			c.remove(el)
+			c.stats.Expired++
+		}
+		el = next
+	}
+}
+
+// Close stops the janitor.
+func (c *LocalMemCache) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+// Stats returns current usage counters.
+func (c *LocalMemCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.Entries = c.lru.Len()
+	s.Bytes = c.bytes
+	return s
+}
+
+// lookup returns not expired item stored under given key. Must be called
+// with lock held.
+func (c *LocalMemCache) lookup(key string) (*cacheitem, bool) {
+	el, ok := c.mem[key]
 	if !ok {
-		return ErrMiss
+		return nil, false
 	}
+	it := el.Value.(*cacheitem)
 	if it.ValidTill.Before(time.Now()) {
-		delete(c.mem, key)
-		return ErrMiss
+		c.remove(el)
+		c.stats.Expired++
+		return nil, false
 	}
-	return json.Unmarshal(it.Value, dest)
+	c.lru.MoveToFront(el)
+	return it, true
 }
 
-func (c *LocalMemCache) Set(ctx context.Context, key string, value interface{}, exp time.Duration) error {
-	b, err := json.MarshalIndent(value, "", "  ")
-	if err != nil {
-		return err
+// store writes item, evicting least recently used items if size limits are
+// exceeded. Must be called with lock held.
+func (c *LocalMemCache) store(it *cacheitem) {
+	if el, ok := c.mem[it.Key]; ok {
+		c.remove(el)
 	}
+	c.mem[it.Key] = c.lru.PushFront(it)
+	c.bytes += it.size()
 
-	it := cacheitem{
-		Key:       key,
-		Value:     b,
-		ValidTill: time.Now().Add(exp),
+	for c.lru.Len() > 1 && c.overLimit() {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LocalMemCache) overLimit() bool {
+	if c.opts.maxEntries > 0 && c.lru.Len() > c.opts.maxEntries {
+		return true
+	}
+	if c.opts.maxBytes > 0 && c.bytes > c.opts.maxBytes {
+		return true
 	}
+	return false
+}
+
+func (c *LocalMemCache) remove(el *list.Element) {
+	it := c.lru.Remove(el).(*cacheitem)
+	delete(c.mem, it.Key)
+	c.bytes -= it.size()
+}
 
+func (c *LocalMemCache) Get(ctx context.Context, key string) ([]byte, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.mem[key] = &it
-	return nil
+	it, ok := c.lookup(key)
+	if !ok {
+		c.stats.Misses++
+		return nil, ErrMiss
+	}
+	c.stats.Hits++
+	return copyBytes(it.Value), nil
 }
 
-func (c *LocalMemCache) Add(ctx context.Context, key string, value interface{}, exp time.Duration) error {
+func (c *LocalMemCache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, ok := c.mem[key]; ok {
-		return ErrConflict
+	res := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		it, ok := c.lookup(key)
+		if !ok {
+			c.stats.Misses++
+			continue
+		}
+		c.stats.Hits++
+		res[key] = copyBytes(it.Value)
 	}
+	return res, nil
+}
 
-	b, err := json.MarshalIndent(value, "", "  ")
-	if err != nil {
-		return err
+func (c *LocalMemCache) SetMulti(ctx context.Context, items map[string][]byte, exp time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	validTill := time.Now().Add(exp)
+	for key, value := range items {
+		c.store(&cacheitem{
+			Key:       key,
+			Value:     copyBytes(value),
+			ValidTill: validTill,
+		})
 	}
-	it := cacheitem{
+	return nil
+}
+
+func (c *LocalMemCache) Set(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(&cacheitem{
 		Key:       key,
-		Value:     b,
+		Value:     copyBytes(value),
 		ValidTill: time.Now().Add(exp),
+	})
+	return nil
+}
+
+func (c *LocalMemCache) Add(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.lookup(key); ok {
+		return ErrConflict
 	}
-	c.mem[key] = &it
+	c.store(&cacheitem{
+		Key:       key,
+		Value:     copyBytes(value),
+		ValidTill: time.Now().Add(exp),
+	})
 	return nil
 }
 
+func (c *LocalMemCache) IncrExisting(ctx context.Context, key string, delta int64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	it, ok := c.lookup(key)
+	if !ok {
+		return 0, ErrMiss
+	}
+	n, err := strconv.ParseUint(string(it.Value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value is not a number: %s", err)
+	}
+	switch {
+	case delta >= 0:
+		n += uint64(delta)
+	case uint64(-delta) > n:
+		n = 0
+	default:
+		n -= uint64(-delta)
+	}
+	c.store(&cacheitem{
+		Key:       key,
+		Value:     []byte(strconv.FormatUint(n, 10)),
+		ValidTill: it.ValidTill,
+	})
+	return n, nil
+}
+
 func (c *LocalMemCache) Del(ctx context.Context, key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, ok := c.mem[key]; !ok {
+	if _, ok := c.lookup(key); !ok {
 		return ErrMiss
 	}
-	delete(c.mem, key)
+	c.remove(c.mem[key])
 	return nil
 }
 
@@ -98,5 +312,13 @@ func (c *LocalMemCache) Flush() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.mem = make(map[string]*cacheitem)
+	c.mem = make(map[string]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// copyBytes returns copy of given slice, so that stored values cannot be
+// modified by the caller.
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
 }
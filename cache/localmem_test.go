@@ -2,9 +2,96 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestLocalMemCache(t *testing.T) {
-	testCacheService(t, context.Background(), NewLocalMemCache())
+	testCacheService(t, context.Background(), NewCacheService(NewLocalMemCache()))
+}
+
+func TestLocalMemBareCache(t *testing.T) {
+	testBareCacheService(t, context.Background(), NewLocalMemCache())
+}
+
+func TestLocalMemCacheLimited(t *testing.T) {
+	c := NewLocalMemCache(WithMaxEntries(100), WithMaxBytes(1<<20), WithJanitor(time.Second))
+	defer c.Close()
+	testCacheService(t, context.Background(), NewCacheService(c))
+}
+
+func TestLocalMemCacheMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalMemCache(WithMaxEntries(3))
+
+	for i := 0; i < 3; i++ {
+		if err := c.Set(ctx, fmt.Sprint(i), []byte("x"), time.Minute); err != nil {
+			t.Fatalf("cannot set %d: %s", i, err)
+		}
+	}
+	// use the oldest item, so that the second one is least recently used
+	if _, err := c.Get(ctx, "0"); err != nil {
+		t.Fatalf("cannot get: %s", err)
+	}
+	if err := c.Set(ctx, "3", []byte("x"), time.Minute); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+
+	if _, err := c.Get(ctx, "1"); err != ErrMiss {
+		t.Fatalf("want least recently used item evicted, got %v", err)
+	}
+	for _, key := range []string{"0", "2", "3"} {
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Fatalf("cannot get %s: %s", key, err)
+		}
+	}
+
+	s := c.Stats()
+	if s.Entries != 3 || s.Evictions != 1 || s.Hits != 4 || s.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestLocalMemCacheMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalMemCache(WithMaxBytes(20))
+
+	// every item takes 1 byte key and 8 bytes value
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Set(ctx, key, []byte("xxxxxxxx"), time.Minute); err != nil {
+			t.Fatalf("cannot set %s: %s", key, err)
+		}
+	}
+	s := c.Stats()
+	if s.Entries != 2 || s.Bytes != 18 || s.Evictions != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if _, err := c.Get(ctx, "a"); err != ErrMiss {
+		t.Fatalf("want oldest item evicted, got %v", err)
+	}
+}
+
+func TestLocalMemCacheJanitor(t *testing.T) {
+	ctx := context.Background()
+	c := NewLocalMemCache(WithJanitor(10 * time.Millisecond))
+	defer c.Close()
+
+	if err := c.Set(ctx, "a", []byte("1"), time.Millisecond); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+	if err := c.Set(ctx, "b", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("cannot set: %s", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Entries != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired item not removed: %+v", c.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s := c.Stats(); s.Expired != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
 }
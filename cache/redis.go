@@ -2,7 +2,6 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,59 +9,217 @@ import (
 )
 
 type redisCacheService struct {
-	pool *redis.Pool
+	pool    *redis.Pool
+	timeout time.Duration
+	breaker *breaker
 }
 
-var _ CacheService = (*redisCacheService)(nil)
+var _ BareCacheService = (*redisCacheService)(nil)
 
-func NewRedisCacheService(pool *redis.Pool) CacheService {
-	return &redisCacheService{
-		pool: pool,
+// NewRedisCacheService returns cache service that stores serialized values
+// in redis. See NewCacheService and NewRedisBareCacheService for available
+// options.
+func NewRedisCacheService(pool *redis.Pool, opts ...Option) CacheService {
+	return NewCacheService(NewRedisBareCacheService(pool, opts...), opts...)
+}
+
+// NewRedisBareCacheService returns bare cache service using redis storage.
+//
+// Every call is cancelled when context is done, but redis connection
+// timeouts should be configured as well, because cancelled call is still
+// holding the connection until it's finished.
+func NewRedisBareCacheService(pool *redis.Pool, opts ...Option) BareCacheService {
+	var o options
+	for _, fn := range opts {
+		fn(&o)
+	}
+	rcs := &redisCacheService{
+		pool:    pool,
+		timeout: o.timeout,
+	}
+	if o.breakerThreshold > 0 {
+		rcs.breaker = &breaker{
+			threshold: o.breakerThreshold,
+			cooldown:  o.breakerCooldown,
+		}
 	}
+	return rcs
 }
 
-func (rcs *redisCacheService) Get(ctx context.Context, key string, dest interface{}) error {
-	rc := rcs.pool.Get()
-	defer rc.Close()
+// WithTimeout limits the time single redis call can take.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
 
-	switch raw, err := redis.Bytes(rc.Do("GET", key)); err {
-	case nil:
-		if err := json.Unmarshal(raw, dest); err != nil {
-			return fmt.Errorf("cannot deserialize: %s", err)
+// WithCircuitBreaker configures redis cache service to return
+// ErrUnavailable without calling redis, after given number of consecutive
+// calls failed because of connection errors or timeouts. After cooldown,
+// single call is made to check if redis is available again.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.breakerThreshold = failures
+		o.breakerCooldown = cooldown
+	}
+}
+
+// do runs given function with redis connection, returning as soon as
+// context is done or the call timeout passes.
+//
+// Function that did not finish in time is not stopped and is holding the
+// pool connection until it returns, which is bounded only by the
+// connection read and write timeouts. Pool should limit active connections
+// as well, so that unresponsive redis cannot exhaust the client.
+func (rcs *redisCacheService) do(parent context.Context, fn func(redis.Conn) error) error {
+	if !rcs.breaker.Allow() {
+		return ErrUnavailable
+	}
+
+	ctx := parent
+	if rcs.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, rcs.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		rc := rcs.pool.Get()
+		defer rc.Close()
+		done <- fn(rc)
+	}()
+
+	select {
+	case err := <-done:
+		if isConnectionErr(err) {
+			rcs.breaker.Failure()
+		} else {
+			rcs.breaker.Success()
 		}
-		return nil
+		return err
+	case <-ctx.Done():
+		// cancellation or deadline of the caller does not tell if redis
+		// is available, only the call timeout does
+		if parent.Err() != nil {
+			rcs.breaker.Abandon()
+		} else {
+			rcs.breaker.Failure()
+		}
+		return fmt.Errorf("redis failed: %s", ctx.Err())
+	}
+}
+
+// isConnectionErr returns true if given error means that redis might not be
+// available.
+func isConnectionErr(err error) bool {
+	switch err.(type) {
+	case nil, redis.Error:
+		// redis.Error is the error reply sent by redis
+		return false
+	}
+	return err != redis.ErrNil
+}
+
+func (rcs *redisCacheService) Get(ctx context.Context, key string) ([]byte, error) {
+	var raw []byte
+	err := rcs.do(ctx, func(rc redis.Conn) (err error) {
+		raw, err = redis.Bytes(rc.Do("GET", key))
+		return err
+	})
+	switch err {
+	case nil:
+		return raw, nil
 	case redis.ErrNil:
-		return ErrMiss
+		return nil, ErrMiss
+	case ErrUnavailable:
+		return nil, err
 	default:
-		return fmt.Errorf("redis failed: %s", err)
+		return nil, fmt.Errorf("redis failed: %s", err)
 	}
 }
 
-func (rcs *redisCacheService) Set(ctx context.Context, key string, value interface{}, exp time.Duration) error {
-	raw, err := json.Marshal(value)
-	if err != nil {
-		return fmt.Errorf("cannot serialize: %s", err)
+func (rcs *redisCacheService) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	res := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return res, nil
 	}
 
-	rc := rcs.pool.Get()
-	defer rc.Close()
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	var values []interface{}
+	err := rcs.do(ctx, func(rc redis.Conn) (err error) {
+		values, err = redis.Values(rc.Do("MGET", args...))
+		return err
+	})
+	switch err {
+	case nil:
+	case ErrUnavailable:
+		return nil, err
+	default:
+		return nil, fmt.Errorf("redis failed: %s", err)
+	}
+	for i, v := range values {
+		if raw, ok := v.([]byte); ok {
+			res[keys[i]] = raw
+		}
+	}
+	return res, nil
+}
+
+func (rcs *redisCacheService) SetMulti(ctx context.Context, items map[string][]byte, exp time.Duration) error {
+	if len(items) == 0 {
+		return nil
+	}
 
-	if _, err := rc.Do("SET", key, raw, "PX", int(exp/time.Millisecond)); err != nil {
+	err := rcs.do(ctx, func(rc redis.Conn) error {
+		// MSET does not support expiration time, so all commands are
+		// pipelined instead
+		for key, value := range items {
+			if err := rc.Send("SET", key, value, "PX", int(exp/time.Millisecond)); err != nil {
+				return err
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+		for range items {
+			if _, err := rc.Receive(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch err {
+	case nil, ErrUnavailable:
+		return err
+	default:
 		return fmt.Errorf("redis failed: %s", err)
 	}
-	return nil
 }
 
-func (rcs *redisCacheService) Add(ctx context.Context, key string, value interface{}, exp time.Duration) error {
-	raw, err := json.Marshal(value)
-	if err != nil {
-		return fmt.Errorf("cannot serialize: %s", err)
+func (rcs *redisCacheService) Set(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	err := rcs.do(ctx, func(rc redis.Conn) error {
+		_, err := rc.Do("SET", key, value, "PX", int(exp/time.Millisecond))
+		return err
+	})
+	switch err {
+	case nil, ErrUnavailable:
+		return err
+	default:
+		return fmt.Errorf("redis failed: %s", err)
 	}
+}
 
-	rc := rcs.pool.Get()
-	defer rc.Close()
-
-	switch resp, err := redis.Bytes(rc.Do("SET", key, raw, "PX", int(exp/time.Millisecond), "NX")); err {
+func (rcs *redisCacheService) Add(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	var resp []byte
+	err := rcs.do(ctx, func(rc redis.Conn) (err error) {
+		resp, err = redis.Bytes(rc.Do("SET", key, value, "PX", int(exp/time.Millisecond), "NX"))
+		return err
+	})
+	switch err {
 	case nil, redis.ErrNil:
 		// if set was successful, resp will be OK and not nil. From
 		// redis documentation http://redis.io/commands/set
@@ -75,17 +232,56 @@ func (rcs *redisCacheService) Add(ctx context.Context, key string, value interfa
 			return ErrConflict
 		}
 		return nil
+	case ErrUnavailable:
+		return err
 	default:
 		return fmt.Errorf("redis failed: %s", err)
 	}
 }
 
-func (rcs *redisCacheService) Del(ctx context.Context, key string) error {
-	rc := rcs.pool.Get()
-	defer rc.Close()
+// incrExistingScript increments value only if key exists. INCRBY is used,
+// because it does not change expiration time.
+var incrExistingScript = redis.NewScript(1, `
+	if redis.call("EXISTS", KEYS[1]) == 0 then
+		return false
+	end
+	local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+	if n < 0 then
+		redis.call("INCRBY", KEYS[1], -n)
+		n = 0
+	end
+	return n
+`)
+
+func (rcs *redisCacheService) IncrExisting(ctx context.Context, key string, delta int64) (uint64, error) {
+	var n uint64
+	err := rcs.do(ctx, func(rc redis.Conn) (err error) {
+		n, err = redis.Uint64(incrExistingScript.Do(rc, key, delta))
+		return err
+	})
+	switch err {
+	case nil:
+		return n, nil
+	case redis.ErrNil:
+		return 0, ErrMiss
+	case ErrUnavailable:
+		return 0, err
+	default:
+		return 0, fmt.Errorf("redis failed: %s", err)
+	}
+}
 
-	n, err := redis.Int(rc.Do("DEL", key))
-	if err != nil {
+func (rcs *redisCacheService) Del(ctx context.Context, key string) error {
+	var n int
+	err := rcs.do(ctx, func(rc redis.Conn) (err error) {
+		n, err = redis.Int(rc.Do("DEL", key))
+		return err
+	})
+	switch err {
+	case nil:
+	case ErrUnavailable:
+		return err
+	default:
 		return fmt.Errorf("redis failed: %s", err)
 	}
 	if n == 0 {
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func redisPool(t *testing.T) *redis.Pool {
+	pool := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL("redis://localhost:6379/15")
+		},
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	rc := pool.Get()
+	defer rc.Close()
+	if _, err := rc.Do("FLUSHDB"); err != nil {
+		t.Skipf("redis not available: %s", err)
+	}
+	return pool
+}
+
+func TestRedisCacheService(t *testing.T) {
+	testCacheService(t, context.Background(), NewRedisCacheService(redisPool(t)))
+}
+
+func TestRedisBareCacheService(t *testing.T) {
+	testBareCacheService(t, context.Background(), NewRedisBareCacheService(redisPool(t)))
+}
+
+// hangingServer accepts connections, but never responds.
+func hangingServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		mu.Unlock()
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedisCacheServiceTimeout(t *testing.T) {
+	addr := hangingServer(t)
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr, redis.DialReadTimeout(time.Second))
+		},
+	}
+	defer pool.Close()
+
+	c := NewRedisBareCacheService(pool,
+		WithTimeout(20*time.Millisecond),
+		WithCircuitBreaker(2, time.Minute))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if _, err := c.Get(ctx, "key"); err == nil || err == ErrUnavailable {
+			t.Fatalf("want timeout error, got %v", err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatalf("call took %s", d)
+		}
+	}
+
+	// after two failures, breaker is open
+	if _, err := c.Get(ctx, "key"); err != ErrUnavailable {
+		t.Fatalf("want ErrUnavailable, got %v", err)
+	}
+	if err := c.Set(ctx, "key", []byte("x"), time.Minute); err != ErrUnavailable {
+		t.Fatalf("want ErrUnavailable, got %v", err)
+	}
+}
+
+func TestRedisCacheServiceCancel(t *testing.T) {
+	addr := hangingServer(t)
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr, redis.DialReadTimeout(time.Second))
+		},
+	}
+	defer pool.Close()
+
+	c := NewRedisBareCacheService(pool, WithCircuitBreaker(1, time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := c.Get(ctx, "key"); err == nil {
+		t.Fatal("want error")
+	}
+
+	// cancelled call must not open the breaker
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, "key"); err == nil || err == ErrUnavailable {
+		t.Fatalf("breaker opened by cancelled call: %v", err)
+	}
+
+	// neither is the deadline of the caller
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, "key"); err == nil || err == ErrUnavailable {
+		t.Fatalf("breaker opened by caller deadline: %v", err)
+	}
+}
+
+func TestBreakerAbandon(t *testing.T) {
+	defer func(fn func() time.Time) { currentTime = fn }(currentTime)
+	now := time.Now()
+	currentTime = func() time.Time { return now }
+
+	b := &breaker{threshold: 1, cooldown: time.Minute}
+	b.Allow()
+	b.Failure()
+	if b.Allow() {
+		t.Fatal("open breaker allowed call")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.Allow() {
+		t.Fatal("probe not allowed after cooldown")
+	}
+	// abandoned probe does not close the breaker, but allows next probe
+	b.Abandon()
+	if b.failures != 1 {
+		t.Fatalf("abandoned probe changed failures to %d", b.failures)
+	}
+	if !b.Allow() {
+		t.Fatal("next probe not allowed")
+	}
+	if b.Allow() {
+		t.Fatal("concurrent probe allowed")
+	}
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type serialCacheService struct {
+	bare   BareCacheService
+	opts   options
+	flight flightGroup
+}
+
+var _ CacheService = (*serialCacheService)(nil)
+
+// NewCacheService returns cache service that stores serialized values using
+// given bare cache service. Unless configured otherwise, values are JSON
+// serialized.
+func NewCacheService(bare BareCacheService, opts ...Option) CacheService {
+	s := &serialCacheService{
+		bare: bare,
+		opts: options{codec: JSONCodec, beta: 1},
+	}
+	for _, fn := range opts {
+		fn(&s.opts)
+	}
+	return s
+}
+
+// WithCodec sets codec used by cache service to serialize values. Values
+// serialized with any other built-in codec can still be read.
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithCompression enables compression of serialized values bigger than
+// given number of bytes.
+func WithCompression(threshold int) Option {
+	return func(o *options) {
+		o.compressAbove = threshold
+	}
+}
+
+// WithEarlyRefresh configures how eagerly GetOrLoad refreshes values before
+// they expire. Value is refreshed early with probability depending on how
+// long it took to load it and how close it is to expire. The bigger beta
+// is, the earlier values are refreshed. Default is 1, zero disables early
+// refresh.
+func WithEarlyRefresh(beta float64) Option {
+	return func(o *options) {
+		o.beta = beta
+	}
+}
+
+func (s *serialCacheService) Get(ctx context.Context, key string, dest interface{}) error {
+	raw, err := s.bare.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := decode(s.opts.codec, raw, dest); err != nil {
+		return fmt.Errorf("cannot deserialize: %s", err)
+	}
+	return nil
+}
+
+func (s *serialCacheService) Set(ctx context.Context, key string, value interface{}, exp time.Duration) error {
+	raw, err := encode(s.opts.codec, s.opts.compressAbove, value, nil)
+	if err != nil {
+		return fmt.Errorf("cannot serialize: %s", err)
+	}
+	return s.bare.Set(ctx, key, raw, exp)
+}
+
+func (s *serialCacheService) Add(ctx context.Context, key string, value interface{}, exp time.Duration) error {
+	raw, err := encode(s.opts.codec, s.opts.compressAbove, value, nil)
+	if err != nil {
+		return fmt.Errorf("cannot serialize: %s", err)
+	}
+	return s.bare.Add(ctx, key, raw, exp)
+}
+
+func (s *serialCacheService) Del(ctx context.Context, key string) error {
+	return s.bare.Del(ctx, key)
+}
+
+func (s *serialCacheService) GetMulti(ctx context.Context, dests map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(dests))
+	for key := range dests {
+		keys = append(keys, key)
+	}
+	values, err := s.bare.GetMulti(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, key := range keys {
+		raw, ok := values[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		if err := decode(s.opts.codec, raw, dests[key]); err != nil {
+			return nil, fmt.Errorf("cannot deserialize %q: %s", key, err)
+		}
+	}
+	return missing, nil
+}
+
+func (s *serialCacheService) SetMulti(ctx context.Context, items map[string]interface{}, exp time.Duration) error {
+	raws := make(map[string][]byte, len(items))
+	for key, value := range items {
+		raw, err := encode(s.opts.codec, s.opts.compressAbove, value, nil)
+		if err != nil {
+			return fmt.Errorf("cannot serialize %q: %s", key, err)
+		}
+		raws[key] = raw
+	}
+	return s.bare.SetMulti(ctx, raws, exp)
+}
+
+func (s *serialCacheService) GetOrLoad(ctx context.Context, key string, dest interface{}, exp time.Duration, loader Loader) error {
+	raw, err := s.bare.Get(ctx, key)
+	switch err {
+	case nil:
+		if !s.refreshEarly(raw) {
+			return decode(s.opts.codec, raw, dest)
+		}
+	case ErrMiss:
+		// load
+	default:
+		// cache failure must not prevent from loading the value
+		log.Printf("cannot get %q: %s", key, err)
+	}
+	stale := raw
+
+	raw, err = s.flight.Do(key, func() ([]byte, error) {
+		start := time.Now()
+		value, err := loader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		meta := &loadMeta{
+			Expires: time.Now().Add(exp),
+			Delta:   time.Since(start),
+		}
+		raw, err := encode(s.opts.codec, s.opts.compressAbove, value, meta)
+		if err != nil {
+			return nil, fmt.Errorf("cannot serialize: %s", err)
+		}
+		if err := s.bare.Set(ctx, key, raw, exp); err != nil {
+			log.Printf("cannot set %q: %s", key, err)
+		}
+		return raw, nil
+	})
+	if err != nil {
+		// early refreshed value is still valid
+		if stale != nil {
+			return decode(s.opts.codec, stale, dest)
+		}
+		return err
+	}
+	return decode(s.opts.codec, raw, dest)
+}
+
+// refreshEarly returns true if value should be loaded before it expires.
+// It implements probabilistic early expiration, as described in "Optimal
+// Probabilistic Cache Stampede Prevention" by Vattani, Chierichetti and
+// Lowenstein.
+func (s *serialCacheService) refreshEarly(raw []byte) bool {
+	if s.opts.beta <= 0 {
+		return false
+	}
+	meta, ok := decodeMeta(raw)
+	if !ok {
+		return false
+	}
+	gap := time.Duration(float64(meta.Delta) * s.opts.beta * -math.Log(randFloat()))
+	return !currentTime().Add(gap).Before(meta.Expires)
+}
+
+var (
+	// randFloat returns a number from (0, 1] range
+	randFloat   = func() float64 { return 1 - rand.Float64() }
+	currentTime = time.Now
+)
+
+// flightGroup deduplicates concurrent calls of the same key.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val []byte
+	err error
+}
+
+// Do executes given function and returns its result. If function for the
+// same key is already being executed, Do waits for it to finish and returns
+// its result instead.
+func (g *flightGroup) Do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err
+}
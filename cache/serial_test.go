@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	c := NewCacheService(NewLocalMemCache(), WithEarlyRefresh(0))
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "loaded", nil
+	}
+
+	// concurrent loads of the same key must share single loader call
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := c.GetOrLoad(ctx, "key", &results[i], time.Minute, loader); err != nil {
+				t.Errorf("cannot load: %s", err)
+			}
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("want single loader call, got %d", n)
+	}
+	for _, r := range results {
+		if r != "loaded" {
+			t.Fatalf("unexpected result: %q", r)
+		}
+	}
+
+	// stored value is used without calling the loader
+	var s string
+	if err := c.GetOrLoad(ctx, "key", &s, time.Minute, loader); err != nil {
+		t.Fatalf("cannot load: %s", err)
+	}
+	if s != "loaded" || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("unexpected result %q after %d calls", s, calls)
+	}
+
+	// and it is readable by Get
+	if err := c.Get(ctx, "key", &s); err != nil || s != "loaded" {
+		t.Fatalf("unexpected result %q, %v", s, err)
+	}
+
+	failure := errors.New("failure")
+	err := c.GetOrLoad(ctx, "other", &s, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return nil, failure
+	})
+	if err != failure {
+		t.Fatalf("want failure, got %v", err)
+	}
+	if err := c.Get(ctx, "other", &s); err != ErrMiss {
+		t.Fatalf("failed load stored: %v", err)
+	}
+}
+
+func TestGetOrLoadEarlyRefresh(t *testing.T) {
+	defer func(fn func() float64) { randFloat = fn }(randFloat)
+	defer func(fn func() time.Time) { currentTime = fn }(currentTime)
+
+	ctx := context.Background()
+	c := NewCacheService(NewLocalMemCache())
+
+	var calls int
+	loader := func(ctx context.Context) (interface{}, error) {
+		calls++
+		time.Sleep(10 * time.Millisecond)
+		return calls, nil
+	}
+
+	var n int
+	if err := c.GetOrLoad(ctx, "key", &n, time.Minute, loader); err != nil || n != 1 {
+		t.Fatalf("unexpected result %d, %v", n, err)
+	}
+
+	// far from expiration, value is not refreshed
+	randFloat = func() float64 { return 0.5 }
+	if err := c.GetOrLoad(ctx, "key", &n, time.Minute, loader); err != nil || n != 1 {
+		t.Fatalf("unexpected result %d, %v", n, err)
+	}
+
+	// close to expiration, value is refreshed
+	currentTime = func() time.Time { return time.Now().Add(time.Minute - time.Millisecond) }
+	if err := c.GetOrLoad(ctx, "key", &n, time.Minute, loader); err != nil || n != 2 {
+		t.Fatalf("unexpected result %d, %v", n, err)
+	}
+
+	// if refresh fails, still valid value is returned
+	err := c.GetOrLoad(ctx, "key", &n, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("failure")
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("unexpected result %d, %v", n, err)
+	}
+}
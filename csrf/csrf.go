@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -39,3 +40,22 @@ func (c *csrf) Validate(ctx context.Context, r *http.Request, value string) erro
 }
 
 const csrfCookie = "csrf"
+
+// SameOrigin returns true if given request was sent by a page of the site
+// with given URL. Origin header is checked and if the browser did not send
+// it, Referer is used instead. Request without any of them is rejected.
+func SameOrigin(r *http.Request, site string) bool {
+	siteURL, err := url.Parse(site)
+	if err != nil {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		origin = r.Header.Get("Referer")
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == siteURL.Scheme && u.Host == siteURL.Host
+}
@@ -0,0 +1,36 @@
+package csrf
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	cases := map[string]struct {
+		origin  string
+		referer string
+		want    bool
+	}{
+		"same origin":          {origin: "https://feedstream.example.com", want: true},
+		"other origin":         {origin: "https://evil.example.com"},
+		"other scheme":         {origin: "http://feedstream.example.com"},
+		"same referer":         {referer: "https://feedstream.example.com/settings", want: true},
+		"other referer":        {referer: "https://evil.example.com/feedstream.example.com"},
+		"null origin":          {origin: "null", referer: "https://evil.example.com/"},
+		"no origin or referer": {},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/account/delete", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+			if got := SameOrigin(r, "https://feedstream.example.com"); got != tc.want {
+				t.Fatalf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -1,8 +0,0 @@
-BEGIN;
-
-DROP TABLE IF EXISTS accounts CASCADE;
-DROP TABLE IF EXISTS entries CASCADE;
-DROP TABLE IF EXISTS subscriptions CASCADE;
-DROP TABLE IF EXISTS feeds CASCADE;
-
-COMMIT;
@@ -0,0 +1,414 @@
+// Package fever implements Fever API, as used by feed reader applications.
+//
+// All requests are made to a single endpoint and authenticated with API key
+// send as "api_key" parameter. Subscriptions are not organized into groups,
+// so all feeds belong to a single group. Links and sparks are not
+// supported.
+package fever
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/cache"
+	"github.com/husio/feedstream/sanitize"
+	"github.com/husio/feedstream/stream"
+	"github.com/husio/web"
+)
+
+const (
+	apiVersion = 3
+
+	// allGroupID is the ID of the only group, containing all feeds.
+	allGroupID = 1
+
+	// itemsPerPage is the number of items returned with a single response,
+	// as defined by Fever API.
+	itemsPerPage = 50
+)
+
+// Handler serves all Fever API requests.
+func Handler(
+	manager stream.Manager,
+	authSrv auth.AuthService,
+	cacheSrv cache.CacheService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := map[string]interface{}{
+			"api_version": apiVersion,
+			"auth":        0,
+		}
+
+		user, err := authSrv.FeverUser(r.Context(), r.FormValue("api_key"))
+		switch err {
+		case nil:
+			resp["auth"] = 1
+		case auth.ErrNotAuthenticated:
+			web.JSONResp(w, resp, http.StatusOK)
+			return
+		default:
+			log.Printf("cannot get fever user: %s", err)
+			web.JSONResp(w, resp, http.StatusInternalServerError)
+			return
+		}
+
+		q := r.URL.Query()
+		if err := handle(r.Context(), manager, cacheSrv, user.AccountID, q, r.Form, resp); err != nil {
+			if err, ok := err.(badRequestErr); ok {
+				resp["error"] = err.Error()
+				web.JSONResp(w, resp, http.StatusBadRequest)
+				return
+			}
+			log.Printf("cannot handle fever request: %s", err)
+			web.JSONResp(w, resp, http.StatusInternalServerError)
+			return
+		}
+		web.JSONResp(w, resp, http.StatusOK)
+	}
+}
+
+type badRequestErr string
+
+func (e badRequestErr) Error() string { return string(e) }
+
+// handle writes into resp the result of all actions requested with query
+// parameters. Modifications are always applied first.
+func handle(
+	ctx context.Context,
+	manager stream.Manager,
+	cacheSrv cache.CacheService,
+	accountID int64,
+	q url.Values,
+	form url.Values,
+	resp map[string]interface{},
+) error {
+	if mark := form.Get("mark"); mark != "" {
+		if err := handleMark(ctx, manager, accountID, mark, form); err != nil {
+			return err
+		}
+		// clients expect current state after marking
+		switch mark {
+		case "item":
+			q.Set("unread_item_ids", "")
+			q.Set("saved_item_ids", "")
+		default:
+			q.Set("unread_item_ids", "")
+		}
+	}
+
+	subs, err := manager.Subscriptions(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("cannot list subscriptions: %s", err)
+	}
+	var lastRefreshed time.Time
+	for _, s := range subs {
+		if s.Updated.After(lastRefreshed) {
+			lastRefreshed = s.Updated
+		}
+	}
+	resp["last_refreshed_on_time"] = lastRefreshed.Unix()
+
+	if _, ok := q["groups"]; ok {
+		resp["groups"] = []interface{}{
+			map[string]interface{}{"id": allGroupID, "title": "All"},
+		}
+		resp["feeds_groups"] = feedsGroups(subs)
+	}
+
+	if _, ok := q["feeds"]; ok {
+		type feed struct {
+			ID                int64  `json:"id"`
+			FaviconID         int64  `json:"favicon_id"`
+			Title             string `json:"title"`
+			URL               string `json:"url"`
+			SiteURL           string `json:"site_url"`
+			IsSpark           int    `json:"is_spark"`
+			LastUpdatedOnTime int64  `json:"last_updated_on_time"`
+		}
+		feeds := make([]*feed, 0, len(subs))
+		for _, s := range subs {
+			feeds = append(feeds, &feed{
+				ID:                s.FeedID,
+				FaviconID:         s.FeedID,
+				Title:             s.Title,
+				URL:               s.URL,
+				SiteURL:           s.URL,
+				LastUpdatedOnTime: s.Updated.Unix(),
+			})
+		}
+		resp["feeds"] = feeds
+		resp["feeds_groups"] = feedsGroups(subs)
+	}
+
+	if _, ok := q["favicons"]; ok {
+		type favicon struct {
+			ID   int64  `json:"id"`
+			Data string `json:"data"`
+		}
+		// favicons that are not cached yet are fetched concurrently,
+		// so that many subscriptions do not stall the response
+		data := make([]string, len(subs))
+		sem := make(chan struct{}, maxFaviconFetches)
+		var wg sync.WaitGroup
+		for i, s := range subs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, faviconURL string) {
+				defer func() { <-sem; wg.Done() }()
+				data[i] = faviconData(ctx, cacheSrv, faviconURL)
+			}(i, s.FeedFaviconURL)
+		}
+		wg.Wait()
+
+		favicons := make([]*favicon, 0, len(subs))
+		for i, s := range subs {
+			if data[i] == "" {
+				continue
+			}
+			favicons = append(favicons, &favicon{ID: s.FeedID, Data: data[i]})
+		}
+		resp["favicons"] = favicons
+	}
+
+	if _, ok := q["items"]; ok {
+		items, err := listItems(ctx, manager, accountID, q)
+		if err != nil {
+			return err
+		}
+		resp["items"] = items
+		total, err := manager.EntryCount(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("cannot count entries: %s", err)
+		}
+		resp["total_items"] = total
+	}
+
+	if _, ok := q["unread_item_ids"]; ok {
+		ids, err := manager.UnreadEntryIDs(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("cannot list unread entries: %s", err)
+		}
+		resp["unread_item_ids"] = joinIDs(ids)
+	}
+
+	if _, ok := q["saved_item_ids"]; ok {
+		ids, err := manager.StarredEntryIDs(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("cannot list starred entries: %s", err)
+		}
+		resp["saved_item_ids"] = joinIDs(ids)
+	}
+
+	return nil
+}
+
+func handleMark(ctx context.Context, manager stream.Manager, accountID int64, mark string, form url.Values) error {
+	id, err := strconv.ParseInt(form.Get("id"), 10, 64)
+	if err != nil {
+		return badRequestErr(`invalid "id"`)
+	}
+	as := form.Get("as")
+
+	switch mark {
+	case "item":
+		ids := []int64{id}
+		switch as {
+		case "read":
+			err = manager.MarkRead(ctx, accountID, ids, true)
+		case "unread":
+			err = manager.MarkRead(ctx, accountID, ids, false)
+		case "saved":
+			err = manager.MarkStarred(ctx, accountID, ids, true)
+		case "unsaved":
+			err = manager.MarkStarred(ctx, accountID, ids, false)
+		default:
+			return badRequestErr(fmt.Sprintf("invalid item mark %q", as))
+		}
+	case "feed", "group":
+		if as != "read" {
+			return badRequestErr(fmt.Sprintf("invalid %s mark %q", mark, as))
+		}
+		before := time.Now()
+		if raw := form.Get("before"); raw != "" {
+			sec, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return badRequestErr(`invalid "before"`)
+			}
+			before = time.Unix(sec, 0)
+		}
+		feedID := id
+		if mark == "group" {
+			// there is only one group, containing all feeds. Group
+			// 0 is used by clients to mark all feeds.
+			if id != 0 && id != allGroupID {
+				return nil
+			}
+			feedID = 0
+		}
+		err = manager.MarkAllRead(ctx, accountID, feedID, before)
+	default:
+		return badRequestErr(fmt.Sprintf("invalid mark %q", mark))
+	}
+	if err != nil {
+		return fmt.Errorf("cannot mark %s %d as %s: %s", mark, id, as, err)
+	}
+	return nil
+}
+
+// listItems returns single page of items, as selected by query parameters.
+func listItems(ctx context.Context, manager stream.Manager, accountID int64, q url.Values) (interface{}, error) {
+	eq := stream.EntryIDQuery{Limit: itemsPerPage, WithContent: true}
+	if raw := q.Get("with_ids"); raw != "" {
+		for _, chunk := range strings.Split(raw, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(chunk), 10, 64)
+			if err != nil {
+				return nil, badRequestErr(`invalid "with_ids"`)
+			}
+			eq.IDs = append(eq.IDs, id)
+		}
+	}
+	if raw := q.Get("since_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, badRequestErr(`invalid "since_id"`)
+		}
+		eq.SinceID = id
+		eq.Ascending = true
+	}
+	if raw := q.Get("max_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, badRequestErr(`invalid "max_id"`)
+		}
+		eq.MaxID = id
+	}
+
+	entries, err := manager.EntriesByID(ctx, accountID, eq)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list entries: %s", err)
+	}
+
+	type item struct {
+		ID            int64  `json:"id"`
+		FeedID        int64  `json:"feed_id"`
+		Title         string `json:"title"`
+		Author        string `json:"author"`
+		HTML          string `json:"html"`
+		URL           string `json:"url"`
+		IsSaved       int    `json:"is_saved"`
+		IsRead        int    `json:"is_read"`
+		CreatedOnTime int64  `json:"created_on_time"`
+	}
+	items := make([]*item, 0, len(entries))
+	for _, e := range entries {
+		it := &item{
+			ID:            e.EntryID,
+			FeedID:        e.FeedID,
+			Title:         e.Title,
+			HTML:          sanitize.HTML(e.Content, e.URL),
+			URL:           e.URL,
+			CreatedOnTime: e.Published.Unix(),
+		}
+		if it.HTML == "" {
+			// without content, link to the article is better than
+			// nothing
+			it.HTML = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(e.URL), html.EscapeString(e.Title))
+		}
+		if e.Read {
+			it.IsRead = 1
+		}
+		if e.Starred {
+			it.IsSaved = 1
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+func feedsGroups(subs []*stream.Subscription) interface{} {
+	ids := make([]int64, 0, len(subs))
+	for _, s := range subs {
+		ids = append(ids, s.FeedID)
+	}
+	return []interface{}{
+		map[string]interface{}{"group_id": allGroupID, "feed_ids": joinIDs(ids)},
+	}
+}
+
+// joinIDs returns comma separated list of IDs.
+func joinIDs(ids []int64) string {
+	chunks := make([]string, len(ids))
+	for i, id := range ids {
+		chunks[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(chunks, ",")
+}
+
+// maxFaviconFetches is the maximum number of favicons fetched at once.
+const maxFaviconFetches = 8
+
+// faviconClient fetches favicons. Favicon URL is provided by the feed's
+// page, so only public addresses can be reached.
+var faviconClient = stream.NewPublicClient(5 * time.Second)
+
+var fetchFavicon = func(ctx context.Context, faviconURL string) (string, error) {
+	req, err := http.NewRequest("GET", faviconURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := faviconClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response: %d", resp.StatusCode)
+	}
+	mime := resp.Header.Get("Content-Type")
+	if i := strings.Index(mime, ";"); i != -1 {
+		mime = mime[:i]
+	}
+	mime = strings.TrimSpace(mime)
+	if !strings.HasPrefix(mime, "image/") {
+		return "", fmt.Errorf("not an image: %q", mime)
+	}
+	b, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: 64 << 10})
+	if err != nil {
+		return "", err
+	}
+	return mime + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// faviconData returns favicon image, encoded as expected by Fever API.
+// Result is cached, including failures, for which empty string is
+// returned.
+func faviconData(ctx context.Context, cacheSrv cache.CacheService, faviconURL string) string {
+	if !strings.HasPrefix(faviconURL, "http://") && !strings.HasPrefix(faviconURL, "https://") {
+		return ""
+	}
+	var data string
+	err := cacheSrv.GetOrLoad(ctx, "fever:favicon:"+faviconURL, &data, 7*24*time.Hour, func(ctx context.Context) (interface{}, error) {
+		data, err := fetchFavicon(ctx, faviconURL)
+		if err != nil {
+			log.Printf("cannot fetch favicon %q: %s", faviconURL, err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		log.Printf("cannot load favicon %q: %s", faviconURL, err)
+		return ""
+	}
+	return data
+}
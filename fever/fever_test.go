@@ -0,0 +1,265 @@
+package fever
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/cache"
+	"github.com/husio/feedstream/stream"
+)
+
+type stubAuth struct {
+	auth.AuthService
+}
+
+func (stubAuth) FeverUser(ctx context.Context, apiKey string) (*auth.User, error) {
+	if apiKey != "key" {
+		return nil, auth.ErrNotAuthenticated
+	}
+	return &auth.User{AccountID: 1}, nil
+}
+
+type stubManager struct {
+	stream.Manager
+	subs    []*stream.Subscription
+	entries []*stream.Entry
+	queries []stream.EntryIDQuery
+	read    map[int64]bool
+	allRead []int64
+	unread  []int64
+	starred []int64
+}
+
+func (m *stubManager) Subscriptions(ctx context.Context, accountID int64) ([]*stream.Subscription, error) {
+	return m.subs, nil
+}
+
+func (m *stubManager) EntriesByID(ctx context.Context, accountID int64, q stream.EntryIDQuery) ([]*stream.Entry, error) {
+	m.queries = append(m.queries, q)
+	return m.entries, nil
+}
+
+func (m *stubManager) EntryCount(ctx context.Context, accountID int64) (int64, error) {
+	return int64(len(m.entries)), nil
+}
+
+func (m *stubManager) UnreadEntryIDs(ctx context.Context, accountID int64) ([]int64, error) {
+	return m.unread, nil
+}
+
+func (m *stubManager) StarredEntryIDs(ctx context.Context, accountID int64) ([]int64, error) {
+	return m.starred, nil
+}
+
+func (m *stubManager) MarkRead(ctx context.Context, accountID int64, ids []int64, read bool) error {
+	for _, id := range ids {
+		m.read[id] = read
+	}
+	return nil
+}
+
+func (m *stubManager) MarkAllRead(ctx context.Context, accountID, feedID int64, publishedLte time.Time) error {
+	m.allRead = append(m.allRead, feedID)
+	return nil
+}
+
+func call(t *testing.T, h http.HandlerFunc, query string, form url.Values) map[string]interface{} {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/fever/?"+query, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("cannot decode response: %s", err)
+	}
+	return resp
+}
+
+func TestAuthentication(t *testing.T) {
+	h := Handler(&stubManager{}, stubAuth{}, nil)
+
+	resp := call(t, h, "api", url.Values{"api_key": {"invalid"}})
+	if resp["auth"] != 0.0 || resp["api_version"] != 3.0 {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	resp = call(t, h, "api", url.Values{"api_key": {"key"}})
+	if resp["auth"] != 1.0 {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+}
+
+func TestFeedsAndGroups(t *testing.T) {
+	m := &stubManager{
+		subs: []*stream.Subscription{
+			{FeedID: 3, Title: "first", URL: "http://example.com/1"},
+			{FeedID: 5, Title: "second", URL: "http://example.com/2"},
+		},
+	}
+	h := Handler(m, stubAuth{}, nil)
+
+	resp := call(t, h, "api&feeds&groups", url.Values{"api_key": {"key"}})
+	if feeds := resp["feeds"].([]interface{}); len(feeds) != 2 {
+		t.Fatalf("want two feeds, got %v", feeds)
+	}
+	want := []interface{}{
+		map[string]interface{}{"group_id": 1.0, "feed_ids": "3,5"},
+	}
+	if !reflect.DeepEqual(resp["feeds_groups"], want) {
+		t.Fatalf("unexpected feeds groups: %v", resp["feeds_groups"])
+	}
+}
+
+func TestItems(t *testing.T) {
+	m := &stubManager{
+		entries: []*stream.Entry{
+			{EntryID: 11, FeedID: 3, Title: "first", Read: true, URL: "http://example.com/1",
+				Content: `<p onclick="x()">Body<script>x()</script></p>`},
+			{EntryID: 12, FeedID: 3, Title: "second", Starred: true, URL: "http://example.com/2"},
+		},
+	}
+	h := Handler(m, stubAuth{}, nil)
+
+	resp := call(t, h, "api&items&since_id=10", url.Values{"api_key": {"key"}})
+	want := stream.EntryIDQuery{SinceID: 10, Ascending: true, Limit: 50, WithContent: true}
+	if !reflect.DeepEqual(m.queries[0], want) {
+		t.Fatalf("want %+v query, got %+v", want, m.queries[0])
+	}
+	items := resp["items"].([]interface{})
+	if len(items) != 2 || resp["total_items"] != 2.0 {
+		t.Fatalf("unexpected items: %v", resp)
+	}
+	first := items[0].(map[string]interface{})
+	if first["id"] != 11.0 || first["is_read"] != 1.0 || first["is_saved"] != 0.0 {
+		t.Fatalf("unexpected item: %v", first)
+	}
+	if first["html"] != "<p>Body</p>" {
+		t.Fatalf("want sanitized content, got %q", first["html"])
+	}
+	if second := items[1].(map[string]interface{}); second["html"] != `<a href="http://example.com/2">second</a>` {
+		t.Fatalf("want link to the article, got %q", second["html"])
+	}
+
+	call(t, h, "api&items&with_ids=1,2,3", url.Values{"api_key": {"key"}})
+	if ids := m.queries[1].IDs; !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+}
+
+func TestMark(t *testing.T) {
+	m := &stubManager{
+		read:   make(map[int64]bool),
+		unread: []int64{4, 8},
+	}
+	h := Handler(m, stubAuth{}, nil)
+
+	resp := call(t, h, "api", url.Values{
+		"api_key": {"key"},
+		"mark":    {"item"},
+		"as":      {"read"},
+		"id":      {"7"},
+	})
+	if !m.read[7] {
+		t.Fatalf("item not marked as read: %v", m.read)
+	}
+	if resp["unread_item_ids"] != "4,8" {
+		t.Fatalf("unexpected unread items: %v", resp["unread_item_ids"])
+	}
+
+	call(t, h, "api", url.Values{
+		"api_key": {"key"},
+		"mark":    {"group"},
+		"as":      {"read"},
+		"id":      {"0"},
+		"before":  {"1500000000"},
+	})
+	call(t, h, "api", url.Values{
+		"api_key": {"key"},
+		"mark":    {"feed"},
+		"as":      {"read"},
+		"id":      {"3"},
+	})
+	if want := []int64{0, 3}; !reflect.DeepEqual(m.allRead, want) {
+		t.Fatalf("want %v marked, got %v", want, m.allRead)
+	}
+}
+
+func TestFavicons(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fetched []string
+	)
+	defer func(fn func(context.Context, string) (string, error)) {
+		fetchFavicon = fn
+	}(fetchFavicon)
+	fetchFavicon = func(ctx context.Context, u string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched = append(fetched, u)
+		return "image/png;base64,AAAA", nil
+	}
+
+	m := &stubManager{
+		subs: []*stream.Subscription{
+			{FeedID: 3, FeedFaviconURL: "http://example.com/favicon.png"},
+			{FeedID: 5, FeedFaviconURL: "/static/bookmark.png"},
+		},
+	}
+	h := Handler(m, stubAuth{}, cache.NewCacheService(cache.NewLocalMemCache()))
+
+	for i := 0; i < 2; i++ {
+		resp := call(t, h, "api&favicons", url.Values{"api_key": {"key"}})
+		want := []interface{}{
+			map[string]interface{}{"id": 3.0, "data": "image/png;base64,AAAA"},
+		}
+		if !reflect.DeepEqual(resp["favicons"], want) {
+			t.Fatalf("unexpected favicons: %v", resp["favicons"])
+		}
+	}
+	if len(fetched) != 1 {
+		t.Fatalf("want favicon fetched once, got %v", fetched)
+	}
+}
+
+func TestFetchFavicon(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/favicon.png" {
+			w.Header().Set("Content-Type", "image/png; charset=binary")
+			w.Write([]byte{0})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "secret")
+	}))
+	defer site.Close()
+
+	ctx := context.Background()
+	if _, err := fetchFavicon(ctx, site.URL+"/favicon.png"); err == nil {
+		t.Fatal("favicon from local address must not be fetched")
+	}
+
+	defer func(c *http.Client) {
+		faviconClient = c
+	}(faviconClient)
+	faviconClient = site.Client()
+
+	if data, err := fetchFavicon(ctx, site.URL+"/favicon.png"); err != nil || data != "image/png;base64,AA==" {
+		t.Fatalf("unexpected favicon: %q, %v", data, err)
+	}
+	if _, err := fetchFavicon(ctx, site.URL+"/secret.txt"); err == nil {
+		t.Fatal("non image favicon must not be returned")
+	}
+}
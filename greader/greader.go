@@ -0,0 +1,592 @@
+// Package greader implements subset of Google Reader API, as used by mobile
+// and desktop feed reader applications.
+//
+// Clients authenticate with application password, using ClientLogin
+// endpoint, and send returned token with every request in the
+// Authorization header:
+//
+//	Authorization: GoogleLogin auth=<token>
+//
+// Subscriptions are not organized into folders and entries are always
+// listed newest first.
+package greader
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/sanitize"
+	"github.com/husio/feedstream/stream"
+	"github.com/husio/web"
+)
+
+const (
+	readingListTag = "user/-/state/com.google/reading-list"
+	readTag        = "user/-/state/com.google/read"
+	starredTag     = "user/-/state/com.google/starred"
+	keptUnreadTag  = "user/-/state/com.google/kept-unread"
+
+	itemIDPrefix = "tag:google.com,2005:reader/item/"
+)
+
+// ClientLoginHandler authenticates client using application password and
+// returns token that must be used to authenticate further requests. Email
+// is not checked, because password alone identifies the account.
+func ClientLoginHandler(
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := authSrv.LoginWithAppPassword(r.Context(), r.FormValue("Passwd"))
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Error(w, "Error=BadAuthentication", http.StatusForbidden)
+			return
+		default:
+			log.Printf("cannot login with app password: %s", err)
+			http.Error(w, "Error=Unknown", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "SID=%s\nLSID=%s\nAuth=%s\n", token, token, token)
+	}
+}
+
+// currentUser returns user authenticated with given request. Only the
+// Authorization header is accepted, session cookie is ignored. If request
+// is not authenticated, error response is written and false returned.
+func currentUser(w http.ResponseWriter, r *http.Request, authSrv auth.AuthService) (*auth.User, bool) {
+	user, err := authSrv.ClientUser(r.Context(), r)
+	switch err {
+	case nil:
+		return user, true
+	case auth.ErrNotAuthenticated:
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	default:
+		log.Printf("cannot get current user: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// TokenHandler returns token that clients send with every modifying
+// request. Requests are authenticated only with Authorization header,
+// which browsers do not send on their own, so the token is never checked.
+func TokenHandler(
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := currentUser(w, r, authSrv); !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, "feedstream")
+	}
+}
+
+func UserInfoHandler(
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+		id := strconv.FormatInt(user.AccountID, 10)
+		content := struct {
+			UserID        string `json:"userId"`
+			UserName      string `json:"userName"`
+			UserProfileID string `json:"userProfileId"`
+			UserEmail     string `json:"userEmail"`
+		}{
+			UserID:        id,
+			UserName:      user.Name,
+			UserProfileID: id,
+		}
+		web.JSONResp(w, content, http.StatusOK)
+	}
+}
+
+func SubscriptionListHandler(
+	manager stream.Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		subs, err := manager.Subscriptions(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot list subscriptions: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		type subscription struct {
+			ID         string   `json:"id"`
+			Title      string   `json:"title"`
+			Categories []string `json:"categories"`
+			URL        string   `json:"url"`
+			HTMLURL    string   `json:"htmlUrl"`
+			IconURL    string   `json:"iconUrl"`
+		}
+		content := struct {
+			Subscriptions []*subscription `json:"subscriptions"`
+		}{
+			Subscriptions: make([]*subscription, 0, len(subs)),
+		}
+		for _, s := range subs {
+			content.Subscriptions = append(content.Subscriptions, &subscription{
+				ID:         feedStreamID(s.FeedID),
+				Title:      s.Title,
+				Categories: []string{},
+				URL:        s.URL,
+				HTMLURL:    s.URL,
+				IconURL:    s.FeedFaviconURL,
+			})
+		}
+		web.JSONResp(w, content, http.StatusOK)
+	}
+}
+
+// SubscriptionEditHandler subscribes to or unsubscribes from feeds.
+// Renaming and moving subscriptions between folders is not supported and
+// such requests are ignored.
+func SubscriptionEditHandler(
+	manager stream.Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		switch action := r.Form.Get("ac"); action {
+		case "subscribe":
+			for _, s := range r.Form["s"] {
+				url := strings.TrimPrefix(s, "feed/")
+				feedID, err := manager.Subscribe(r.Context(), user.AccountID, url)
+				if err != nil {
+					log.Printf("cannot subscribe to %q: %s", url, err)
+					http.Error(w, "cannot subscribe", http.StatusBadRequest)
+					return
+				}
+				if err := manager.Update(r.Context(), feedID); err != nil {
+					log.Printf("cannot update subscription %q: %s", url, err)
+				}
+			}
+		case "unsubscribe":
+			subs, err := manager.Subscriptions(r.Context(), user.AccountID)
+			if err != nil {
+				log.Printf("cannot list subscriptions: %s", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			for _, s := range r.Form["s"] {
+				feedID, ok := parseFeedStreamID(s)
+				if !ok {
+					http.Error(w, "invalid stream", http.StatusBadRequest)
+					return
+				}
+				for _, sub := range subs {
+					if sub.FeedID != feedID {
+						continue
+					}
+					// subscription removed in the meantime is not an error
+					err := manager.Unsubscribe(r.Context(), sub.SubscriptionID, user.AccountID)
+					if err != nil && err != pg.ErrNotFound {
+						log.Printf("cannot unsubscribe %d: %s", sub.SubscriptionID, err)
+						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+						return
+					}
+				}
+			}
+		case "edit":
+			// folders and custom titles are not supported
+		default:
+			http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, "OK")
+	}
+}
+
+// StreamContentsHandler returns entries of the stream given either as the
+// path argument or "s" parameter.
+func StreamContentsHandler(
+	manager stream.Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		streamID := web.PathArg(r, 0)
+		if streamID == "" {
+			streamID = r.FormValue("s")
+		}
+		q, limit, err := streamQuery(streamID, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.WithContent = true
+		entries, continuation, err := entriesPage(r, manager, user.AccountID, q, limit)
+		if err != nil {
+			log.Printf("cannot list entries: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		type link struct {
+			Href string `json:"href"`
+			Type string `json:"type,omitempty"`
+		}
+		type origin struct {
+			StreamID string `json:"streamId"`
+			Title    string `json:"title"`
+		}
+		type content struct {
+			Content string `json:"content"`
+		}
+		type item struct {
+			ID            string   `json:"id"`
+			CrawlTimeMsec string   `json:"crawlTimeMsec"`
+			TimestampUsec string   `json:"timestampUsec"`
+			Published     int64    `json:"published"`
+			Updated       int64    `json:"updated"`
+			Title         string   `json:"title"`
+			Canonical     []link   `json:"canonical"`
+			Alternate     []link   `json:"alternate"`
+			Categories    []string `json:"categories"`
+			Origin        origin   `json:"origin"`
+			Summary       content  `json:"summary"`
+		}
+		resp := struct {
+			ID           string  `json:"id"`
+			Updated      int64   `json:"updated"`
+			Items        []*item `json:"items"`
+			Continuation string  `json:"continuation,omitempty"`
+		}{
+			ID:           streamID,
+			Updated:      time.Now().Unix(),
+			Items:        make([]*item, 0, len(entries)),
+			Continuation: continuation,
+		}
+		for _, e := range entries {
+			categories := []string{readingListTag}
+			if e.Read {
+				categories = append(categories, readTag)
+			}
+			if e.Starred {
+				categories = append(categories, starredTag)
+			}
+			resp.Items = append(resp.Items, &item{
+				ID:            fmt.Sprintf("%s%016x", itemIDPrefix, e.EntryID),
+				CrawlTimeMsec: strconv.FormatInt(e.Created.UnixNano()/int64(time.Millisecond), 10),
+				TimestampUsec: strconv.FormatInt(e.Published.UnixNano()/int64(time.Microsecond), 10),
+				Published:     e.Published.Unix(),
+				Updated:       e.Published.Unix(),
+				Title:         e.Title,
+				Canonical:     []link{{Href: e.URL}},
+				Alternate:     []link{{Href: e.URL, Type: "text/html"}},
+				Categories:    categories,
+				Origin: origin{
+					StreamID: feedStreamID(e.FeedID),
+					Title:    e.FeedTitle,
+				},
+				Summary: content{Content: sanitize.HTML(e.Content, e.URL)},
+			})
+		}
+		web.JSONResp(w, resp, http.StatusOK)
+	}
+}
+
+// StreamItemIDsHandler returns IDs of entries of the stream given with "s"
+// parameter.
+func StreamItemIDsHandler(
+	manager stream.Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		q, limit, err := streamQuery(r.FormValue("s"), r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, continuation, err := entriesPage(r, manager, user.AccountID, q, limit)
+		if err != nil {
+			log.Printf("cannot list entries: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		type itemRef struct {
+			ID              string   `json:"id"`
+			DirectStreamIDs []string `json:"directStreamIds"`
+			TimestampUsec   string   `json:"timestampUsec"`
+		}
+		resp := struct {
+			ItemRefs     []*itemRef `json:"itemRefs"`
+			Continuation string     `json:"continuation,omitempty"`
+		}{
+			ItemRefs:     make([]*itemRef, 0, len(entries)),
+			Continuation: continuation,
+		}
+		for _, e := range entries {
+			resp.ItemRefs = append(resp.ItemRefs, &itemRef{
+				ID:              strconv.FormatInt(e.EntryID, 10),
+				DirectStreamIDs: []string{},
+				TimestampUsec:   strconv.FormatInt(e.Published.UnixNano()/int64(time.Microsecond), 10),
+			})
+		}
+		web.JSONResp(w, resp, http.StatusOK)
+	}
+}
+
+// EditTagHandler changes read and starred state of entries. Labels are
+// not supported and are ignored.
+func EditTagHandler(
+	manager stream.Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		ids := make([]int64, 0, len(r.Form["i"]))
+		for _, raw := range r.Form["i"] {
+			id, ok := parseItemID(raw)
+			if !ok {
+				http.Error(w, fmt.Sprintf("invalid item %q", raw), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			http.Error(w, "no items", http.StatusBadRequest)
+			return
+		}
+
+		edit := func(tag string, add bool) error {
+			switch normalizeTag(tag) {
+			case readTag:
+				return manager.MarkRead(r.Context(), user.AccountID, ids, add)
+			case keptUnreadTag:
+				return manager.MarkRead(r.Context(), user.AccountID, ids, !add)
+			case starredTag:
+				return manager.MarkStarred(r.Context(), user.AccountID, ids, add)
+			}
+			return nil
+		}
+		for _, tag := range r.Form["a"] {
+			if err := edit(tag, true); err != nil {
+				log.Printf("cannot add %q tag: %s", tag, err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		for _, tag := range r.Form["r"] {
+			if err := edit(tag, false); err != nil {
+				log.Printf("cannot remove %q tag: %s", tag, err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		io.WriteString(w, "OK")
+	}
+}
+
+func UnreadCountHandler(
+	manager stream.Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		counts, err := manager.UnreadCounts(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot count unread entries: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		type unreadCount struct {
+			ID                      string `json:"id"`
+			Count                   int64  `json:"count"`
+			NewestItemTimestampUsec string `json:"newestItemTimestampUsec"`
+		}
+		resp := struct {
+			Max          int            `json:"max"`
+			UnreadCounts []*unreadCount `json:"unreadcounts"`
+		}{
+			Max:          1000,
+			UnreadCounts: make([]*unreadCount, 0, len(counts)+1),
+		}
+		var (
+			total  int64
+			newest time.Time
+		)
+		for _, c := range counts {
+			total += c.Count
+			if c.Newest.After(newest) {
+				newest = c.Newest
+			}
+			resp.UnreadCounts = append(resp.UnreadCounts, &unreadCount{
+				ID:                      feedStreamID(c.FeedID),
+				Count:                   c.Count,
+				NewestItemTimestampUsec: strconv.FormatInt(c.Newest.UnixNano()/int64(time.Microsecond), 10),
+			})
+		}
+		resp.UnreadCounts = append(resp.UnreadCounts, &unreadCount{
+			ID:                      readingListTag,
+			Count:                   total,
+			NewestItemTimestampUsec: strconv.FormatInt(newest.UnixNano()/int64(time.Microsecond), 10),
+		})
+		web.JSONResp(w, resp, http.StatusOK)
+	}
+}
+
+// maxItems is the maximum number of entries returned with a single page.
+const maxItems = 500
+
+// streamQuery returns entries query for given stream ID and request
+// parameters, together with the page size.
+func streamQuery(streamID string, r *http.Request) (stream.EntryQuery, int, error) {
+	var q stream.EntryQuery
+
+	switch streamID = normalizeTag(streamID); streamID {
+	case readingListTag:
+		// all entries
+	case starredTag:
+		q.Starred = true
+	default:
+		feedID, ok := parseFeedStreamID(streamID)
+		if !ok {
+			return q, 0, fmt.Errorf("unsupported stream %q", streamID)
+		}
+		q.FeedID = feedID
+	}
+
+	if normalizeTag(r.FormValue("xt")) == readTag {
+		q.Unread = true
+	}
+	if raw := r.FormValue("ot"); raw != "" {
+		sec, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return q, 0, fmt.Errorf("invalid \"ot\" value")
+		}
+		q.PublishedAfter = time.Unix(sec, 0)
+	}
+	if raw := r.FormValue("c"); raw != "" {
+		c, err := stream.ParseCursor(raw)
+		if err != nil {
+			return q, 0, fmt.Errorf("invalid continuation")
+		}
+		q.Before = c
+	}
+
+	limit := 20
+	if raw := r.FormValue("n"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return q, 0, fmt.Errorf("invalid \"n\" value")
+		}
+		limit = n
+	}
+	if limit > maxItems {
+		limit = maxItems
+	}
+	return q, limit, nil
+}
+
+// entriesPage returns up to limit entries and continuation string, if
+// there are more entries to fetch.
+func entriesPage(r *http.Request, manager stream.Manager, accountID int64, q stream.EntryQuery, limit int) ([]*stream.Entry, string, error) {
+	// fetch one more than requested to know if there is a next page
+	q.Limit = limit + 1
+	entries, err := manager.EntriesPage(r.Context(), accountID, q)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(entries) <= limit {
+		return entries, "", nil
+	}
+	entries = entries[:limit]
+	last := entries[len(entries)-1]
+	return entries, stream.Cursor{Published: last.Published, EntryID: last.EntryID}.String(), nil
+}
+
+var userTagRx = regexp.MustCompile(`^user/\d+/`)
+
+// normalizeTag returns tag with user ID replaced by "-", which means the
+// current user.
+func normalizeTag(tag string) string {
+	return userTagRx.ReplaceAllString(tag, "user/-/")
+}
+
+func feedStreamID(feedID int64) string {
+	return "feed/" + strconv.FormatInt(feedID, 10)
+}
+
+func parseFeedStreamID(s string) (int64, bool) {
+	if !strings.HasPrefix(s, "feed/") {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(s[len("feed/"):], 10, 64)
+	if err != nil || id < 1 {
+		return 0, false
+	}
+	return id, true
+}
+
+// parseItemID returns entry ID from item ID given either in the long,
+// hexadecimal form, or as a decimal number.
+func parseItemID(s string) (int64, bool) {
+	var (
+		id  int64
+		err error
+	)
+	if strings.HasPrefix(s, itemIDPrefix) {
+		id, err = strconv.ParseInt(s[len(itemIDPrefix):], 16, 64)
+	} else {
+		id, err = strconv.ParseInt(s, 10, 64)
+	}
+	if err != nil || id < 1 {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,264 @@
+package greader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/pg/pgtest"
+	"github.com/husio/feedstream/stream"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(pgtest.Main(m))
+}
+
+type stubAuth struct {
+	auth.AuthService
+	user *auth.User
+}
+
+func (a *stubAuth) ClientUser(ctx context.Context, r *http.Request) (*auth.User, error) {
+	if a.user == nil || r.Header.Get("Authorization") != "GoogleLogin auth=token" {
+		return nil, auth.ErrNotAuthenticated
+	}
+	return a.user, nil
+}
+
+func (a *stubAuth) LoginWithAppPassword(ctx context.Context, password string) (string, error) {
+	if password != "s3cr3t" {
+		return "", auth.ErrNotAuthenticated
+	}
+	return "token", nil
+}
+
+type stubManager struct {
+	stream.Manager
+	read    map[int64]bool
+	starred map[int64]bool
+}
+
+func (m *stubManager) MarkRead(ctx context.Context, accountID int64, ids []int64, read bool) error {
+	for _, id := range ids {
+		m.read[id] = read
+	}
+	return nil
+}
+
+func (m *stubManager) MarkStarred(ctx context.Context, accountID int64, ids []int64, starred bool) error {
+	for _, id := range ids {
+		m.starred[id] = starred
+	}
+	return nil
+}
+
+func newRequest(method, path string, form url.Values) *http.Request {
+	var r *http.Request
+	if method == "GET" {
+		r = httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+	} else {
+		r = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r.Header.Set("Authorization", "GoogleLogin auth=token")
+	return r
+}
+
+func TestClientLogin(t *testing.T) {
+	handler := ClientLoginHandler(&stubAuth{})
+
+	w := httptest.NewRecorder()
+	handler(w, newRequest("POST", "/accounts/ClientLogin", url.Values{
+		"Email":  {"bob"},
+		"Passwd": {"s3cr3t"},
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "\nAuth=token\n") {
+		t.Fatalf("unexpected response: %q", body)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, newRequest("POST", "/accounts/ClientLogin", url.Values{
+		"Email":  {"bob"},
+		"Passwd": {"invalid"},
+	}))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("want 403, got %d", w.Code)
+	}
+}
+
+// loadEntries creates feed with five entries, subscribed by the first
+// account, and returns ID of the feed and IDs of its entries, newest first.
+// Third entry is read and starred.
+func loadEntries(t *testing.T, db pg.Database) (int64, []int64) {
+	pgtest.LoadSQLString(t, db, `
+		SELECT subscribe(1, 'http://example.com/feed', 'example', now())
+		---
+		INSERT INTO entries (feed_id, title, url, created, published, content)
+			SELECT f.feed_id, 'entry ' || n, 'http://example.com/' || n, now(), now() - n * interval '1 minute',
+				'<p onclick="x()">Body ' || n || '<script>x()</script></p>'
+			FROM feeds f, generate_series(1, 5) n
+		---
+		INSERT INTO entry_states (account_id, entry_id, read, starred, updated)
+			SELECT 1, entry_id, true, true, now() FROM entries WHERE title = 'entry 3'
+	`)
+	var feedID int64
+	if err := db.Get(&feedID, `SELECT feed_id FROM feeds`); err != nil {
+		t.Fatalf("cannot get feed: %s", err)
+	}
+	var ids []int64
+	if err := db.Select(&ids, `SELECT entry_id FROM entries ORDER BY published DESC`); err != nil {
+		t.Fatalf("cannot list entries: %s", err)
+	}
+	return feedID, ids
+}
+
+func TestStreamItemIDsContinuation(t *testing.T) {
+	db := pgtest.TxDB(t, nil)
+	_, entryIDs := loadEntries(t, db)
+	manager := stream.NewManager(db, nil, nil, "")
+	handler := StreamItemIDsHandler(manager, &stubAuth{user: &auth.User{AccountID: 1}})
+
+	var ids []string
+	continuation := ""
+	for page := 0; page < 5; page++ {
+		w := httptest.NewRecorder()
+		handler(w, newRequest("GET", "/reader/api/0/stream/items/ids", url.Values{
+			"s":  {"user/1/state/com.google/reading-list"},
+			"xt": {"user/-/state/com.google/read"},
+			"n":  {"2"},
+			"c":  {continuation},
+		}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			ItemRefs []struct {
+				ID string
+			}
+			Continuation string
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("cannot decode response: %s", err)
+		}
+		for _, ref := range resp.ItemRefs {
+			ids = append(ids, ref.ID)
+		}
+		if resp.Continuation == "" {
+			break
+		}
+		continuation = resp.Continuation
+	}
+
+	// read entry is excluded
+	var want []string
+	for i, id := range entryIDs {
+		if i != 2 {
+			want = append(want, strconv.FormatInt(id, 10))
+		}
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("want %v, got %v", want, ids)
+	}
+}
+
+func TestStreamContentsFeed(t *testing.T) {
+	db := pgtest.TxDB(t, nil)
+	feedID, entryIDs := loadEntries(t, db)
+	manager := stream.NewManager(db, nil, nil, "")
+	handler := StreamContentsHandler(manager, &stubAuth{user: &auth.User{AccountID: 1}})
+
+	w := httptest.NewRecorder()
+	handler(w, newRequest("GET", "/reader/api/0/stream/contents", url.Values{
+		"s": {fmt.Sprintf("feed/%d", feedID)},
+		"n": {"3"},
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Items []struct {
+			ID         string
+			Categories []string
+			Origin     struct {
+				StreamID string
+			}
+			Summary struct {
+				Content string
+			}
+		}
+		Continuation string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("cannot decode response: %s", err)
+	}
+	if len(resp.Items) != 3 || resp.Continuation == "" {
+		t.Fatalf("want three items and continuation, got %d %q", len(resp.Items), resp.Continuation)
+	}
+	item := resp.Items[2]
+	if want := fmt.Sprintf("%s%016x", itemIDPrefix, entryIDs[2]); item.ID != want {
+		t.Fatalf("want %q item, got %q", want, item.ID)
+	}
+	if want := fmt.Sprintf("feed/%d", feedID); item.Origin.StreamID != want {
+		t.Fatalf("want %q origin, got %q", want, item.Origin.StreamID)
+	}
+	if want := "<p>Body 3</p>"; item.Summary.Content != want {
+		t.Fatalf("want %q summary, got %q", want, item.Summary.Content)
+	}
+	want := []string{readingListTag, readTag, starredTag}
+	if !reflect.DeepEqual(item.Categories, want) {
+		t.Fatalf("want %v categories, got %v", want, item.Categories)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, newRequest("GET", "/reader/api/0/stream/contents", url.Values{
+		"s": {"user/-/label/news"},
+	}))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want 400 for unsupported stream, got %d", w.Code)
+	}
+}
+
+func TestEditTag(t *testing.T) {
+	manager := &stubManager{
+		read:    make(map[int64]bool),
+		starred: make(map[int64]bool),
+	}
+	handler := EditTagHandler(manager, &stubAuth{user: &auth.User{AccountID: 1}})
+
+	w := httptest.NewRecorder()
+	handler(w, newRequest("POST", "/reader/api/0/edit-tag", url.Values{
+		"i": {"tag:google.com,2005:reader/item/000000000000001f", "12"},
+		"a": {"user/-/state/com.google/read", "user/-/label/ignored"},
+		"r": {"user/42/state/com.google/starred"},
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", w.Code, w.Body)
+	}
+	if want := map[int64]bool{31: true, 12: true}; !reflect.DeepEqual(manager.read, want) {
+		t.Fatalf("want %v read, got %v", want, manager.read)
+	}
+	if want := map[int64]bool{31: false, 12: false}; !reflect.DeepEqual(manager.starred, want) {
+		t.Fatalf("want %v starred, got %v", want, manager.starred)
+	}
+
+	w = httptest.NewRecorder()
+	r := newRequest("POST", "/reader/api/0/edit-tag", url.Values{"i": {"12"}})
+	r.Header.Del("Authorization")
+	handler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %d", w.Code)
+	}
+}
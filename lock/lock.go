@@ -0,0 +1,86 @@
+// Package lock provides distributed locks, that can be used to make sure
+// that only one process at a time is running given job.
+package lock
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/husio/feedstream/randstr"
+)
+
+// Locker manages locks. Every lock is held by an owner, identified by a
+// token, and expires after given time unless extended. Only the owner can
+// extend or release the lock.
+type Locker interface {
+	// Acquire takes the lock for given owner. It returns ErrLocked if lock
+	// is held by another owner.
+	Acquire(ctx context.Context, key, token string, ttl time.Duration) error
+
+	// Extend resets expiration time of the lock held by given owner. It
+	// returns ErrNotHeld if the lock is not held by given owner.
+	Extend(ctx context.Context, key, token string, ttl time.Duration) error
+
+	// Release frees the lock held by given owner. It returns ErrNotHeld if
+	// the lock is not held by given owner.
+	Release(ctx context.Context, key, token string) error
+}
+
+var (
+	// ErrLocked is returned when lock is held by another owner.
+	ErrLocked = errors.New("locked")
+
+	// ErrNotHeld is returned when lock is not held by the owner, either
+	// because it was never acquired or it expired.
+	ErrNotHeld = errors.New("lock not held")
+)
+
+// NewToken returns random owner token.
+func NewToken() string {
+	return randstr.New(16)
+}
+
+// Run acquires the lock and runs given function while holding it. Lock is
+// extended in the background for as long as the function is running and
+// released when it returns. If the lock cannot be extended, context passed
+// to the function is cancelled.
+//
+// Run returns ErrLocked if the lock is held by another owner.
+func Run(ctx context.Context, l Locker, key string, ttl time.Duration, fn func(context.Context) error) error {
+	token := NewToken()
+	if err := l.Acquire(ctx, key, token, ttl); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := l.Extend(ctx, key, token, ttl); err != nil {
+					log.Printf("cannot extend %q lock: %s", key, err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := fn(ctx)
+
+	// lock must be released even if the context is already cancelled
+	if rerr := l.Release(context.Background(), key, token); rerr != nil && rerr != ErrNotHeld {
+		log.Printf("cannot release %q lock: %s", key, rerr)
+	}
+	return err
+}
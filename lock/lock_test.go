@@ -0,0 +1,143 @@
+package lock
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// testLocker run standard test set on given locker implementation.
+func testLocker(t *testing.T, l Locker) {
+	ctx := context.Background()
+
+	if err := l.Acquire(ctx, "job", "a", time.Second); err != nil {
+		t.Fatalf("cannot acquire: %s", err)
+	}
+	if err := l.Acquire(ctx, "job", "b", time.Second); err != ErrLocked {
+		t.Fatalf("want ErrLocked, got %v", err)
+	}
+	if err := l.Acquire(ctx, "other-job", "b", time.Second); err != nil {
+		t.Fatalf("cannot acquire other lock: %s", err)
+	}
+
+	if err := l.Extend(ctx, "job", "b", time.Second); err != ErrNotHeld {
+		t.Fatalf("want ErrNotHeld, got %v", err)
+	}
+	if err := l.Release(ctx, "job", "b"); err != ErrNotHeld {
+		t.Fatalf("want ErrNotHeld, got %v", err)
+	}
+	if err := l.Extend(ctx, "job", "a", 2*time.Second); err != nil {
+		t.Fatalf("cannot extend: %s", err)
+	}
+	if err := l.Release(ctx, "job", "a"); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+	if err := l.Release(ctx, "job", "a"); err != ErrNotHeld {
+		t.Fatalf("want ErrNotHeld, got %v", err)
+	}
+
+	// released lock can be acquired by anyone
+	if err := l.Acquire(ctx, "job", "b", 100*time.Millisecond); err != nil {
+		t.Fatalf("cannot acquire: %s", err)
+	}
+
+	// expired lock can be acquired by anyone
+	time.Sleep(150 * time.Millisecond)
+	if err := l.Extend(ctx, "job", "b", time.Second); err != ErrNotHeld {
+		t.Fatalf("want ErrNotHeld, got %v", err)
+	}
+	if err := l.Acquire(ctx, "job", "c", time.Second); err != nil {
+		t.Fatalf("cannot acquire expired lock: %s", err)
+	}
+	if err := l.Release(ctx, "job", "c"); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+	if err := l.Release(ctx, "other-job", "b"); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+}
+
+func TestMemoryLocker(t *testing.T) {
+	testLocker(t, NewMemoryLocker())
+}
+
+func TestRedisLocker(t *testing.T) {
+	pool := &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL("redis://localhost:6379/15")
+		},
+	}
+	defer pool.Close()
+
+	rc := pool.Get()
+	_, err := rc.Do("FLUSHDB")
+	rc.Close()
+	if err != nil {
+		t.Skipf("redis not available: %s", err)
+	}
+
+	testLocker(t, NewRedisLocker(pool))
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLocker()
+
+	var running int32
+	err := Run(ctx, l, "job", 30*time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&running, 1)
+
+		// lock is held while function is running, even if it takes
+		// longer than lock ttl
+		time.Sleep(100 * time.Millisecond)
+		if err := l.Acquire(ctx, "job", "other", time.Second); err != ErrLocked {
+			t.Errorf("want ErrLocked, got %v", err)
+		}
+		err := Run(ctx, l, "job", time.Second, func(ctx context.Context) error {
+			atomic.AddInt32(&running, 1)
+			return nil
+		})
+		if err != ErrLocked {
+			t.Errorf("want ErrLocked, got %v", err)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("cannot run: %s", err)
+	}
+	if n := atomic.LoadInt32(&running); n != 1 {
+		t.Fatalf("want single run, got %d", n)
+	}
+
+	// lock is released after function returns
+	if err := l.Acquire(ctx, "job", "other", time.Second); err != nil {
+		t.Fatalf("lock not released: %s", err)
+	}
+}
+
+type expiringLocker struct {
+	Locker
+}
+
+func (expiringLocker) Extend(ctx context.Context, key, token string, ttl time.Duration) error {
+	return ErrNotHeld
+}
+
+func TestRunLockLost(t *testing.T) {
+	l := expiringLocker{NewMemoryLocker()}
+	err := Run(context.Background(), l, "job", 30*time.Millisecond, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("want context cancelled, got %v", err)
+	}
+}
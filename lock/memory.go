@@ -0,0 +1,74 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]memoryLock
+}
+
+type memoryLock struct {
+	token   string
+	expires time.Time
+}
+
+var _ Locker = (*memoryLocker)(nil)
+
+// NewMemoryLocker returns locker keeping locks in the process memory. It
+// can be used only if there is a single process running.
+func NewMemoryLocker() Locker {
+	return &memoryLocker{
+		locks: make(map[string]memoryLock),
+	}
+}
+
+// held returns true if lock is held by given owner. Expired lock is
+// removed. Must be called with lock held.
+func (m *memoryLocker) held(key, token string) bool {
+	l, ok := m.locks[key]
+	if !ok {
+		return false
+	}
+	if !time.Now().Before(l.expires) {
+		delete(m.locks, key)
+		return false
+	}
+	return l.token == token
+}
+
+func (m *memoryLocker) Acquire(ctx context.Context, key, token string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, ok := m.locks[key]; ok && time.Now().Before(l.expires) {
+		return ErrLocked
+	}
+	m.locks[key] = memoryLock{token: token, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryLocker) Extend(ctx context.Context, key, token string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held(key, token) {
+		return ErrNotHeld
+	}
+	m.locks[key] = memoryLock{token: token, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryLocker) Release(ctx context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held(key, token) {
+		return ErrNotHeld
+	}
+	delete(m.locks, key)
+	return nil
+}
@@ -0,0 +1,80 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+type redisLocker struct {
+	pool *redis.Pool
+}
+
+var _ Locker = (*redisLocker)(nil)
+
+// NewRedisLocker returns locker using redis storage. Locks are shared by
+// all processes using the same redis database.
+func NewRedisLocker(pool *redis.Pool) Locker {
+	return &redisLocker{pool: pool}
+}
+
+func (r *redisLocker) Acquire(ctx context.Context, key, token string, ttl time.Duration) error {
+	rc := r.pool.Get()
+	defer rc.Close()
+
+	switch _, err := redis.String(rc.Do("SET", key, token, "PX", int(ttl/time.Millisecond), "NX")); err {
+	case nil:
+		return nil
+	case redis.ErrNil:
+		return ErrLocked
+	default:
+		return fmt.Errorf("redis failed: %s", err)
+	}
+}
+
+// extendScript resets expiration time only if the lock is held by given
+// owner.
+var extendScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+func (r *redisLocker) Extend(ctx context.Context, key, token string, ttl time.Duration) error {
+	rc := r.pool.Get()
+	defer rc.Close()
+
+	n, err := redis.Int(extendScript.Do(rc, key, token, int(ttl/time.Millisecond)))
+	if err != nil {
+		return fmt.Errorf("redis failed: %s", err)
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// releaseScript deletes the lock only if it is held by given owner.
+var releaseScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+func (r *redisLocker) Release(ctx context.Context, key, token string) error {
+	rc := r.pool.Get()
+	defer rc.Close()
+
+	n, err := redis.Int(releaseScript.Do(rc, key, token))
+	if err != nil {
+		return fmt.Errorf("redis failed: %s", err)
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
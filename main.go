@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/husio/envconf"
 	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/blob"
 	"github.com/husio/feedstream/cache"
+	"github.com/husio/feedstream/fever"
+	"github.com/husio/feedstream/greader"
+	"github.com/husio/feedstream/lock"
 	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/sanitize"
 	"github.com/husio/feedstream/stream"
 	"github.com/husio/feedstream/ui"
 	"github.com/husio/web"
@@ -27,6 +36,21 @@ func main() {
 		Debug              bool
 		NewspaperApi       string `envconf:"NEWSPAPER_API"`
 		NewspaperApiSecret string `envconf:"NEWSPAPER_API_SECRET"`
+		SlowQueryMs        int    `envconf:"SLOW_QUERY_MS"`
+
+		// Runtime metrics and operational endpoints are served on a
+		// separate, internal only port. They are not served if the port
+		// is not set.
+		InternalHTTPPort string `envconf:"INTERNAL_PORT"`
+
+		// Archived pages are kept in the S3 bucket if configured, in the
+		// BlobDir directory otherwise.
+		BlobDir     string
+		S3Endpoint  string `envconf:"S3_ENDPOINT"`
+		S3Region    string `envconf:"S3_REGION"`
+		S3Bucket    string `envconf:"S3_BUCKET"`
+		S3AccessKey string `envconf:"S3_ACCESS_KEY"`
+		S3SecretKey string `envconf:"S3_SECRET_KEY"`
 
 		RedditOAuth2ClientID     string `envconf:"REDDIT_OAUTH2_CLIENT_ID"`
 		RedditOAuth2ClientSecret string `envconf:"REDDIT_OAUTH2_CLIENT_SECRET"`
@@ -41,18 +65,32 @@ func main() {
 		StaticsDir:    "./static",
 		TemplatesGlob: "./templates/**/*.tmpl",
 		NewspaperApi:  "https://articlemeta-api.herokuapp.com",
+		SlowQueryMs:   200,
+		BlobDir:       "./blobs",
+		S3Endpoint:    "https://s3.amazonaws.com",
+		S3Region:      "us-east-1",
 	}
 	log.SetFlags(log.Lshortfile | log.Ltime)
 	envconf.Parse(&conf)
 
-	db, err := pg.Connect(conf.Postgres)
+	queryDurations := pg.NewHistogram()
+	expvar.Publish("pg_query_duration", queryDurations)
+
+	db, err := pg.Connect(conf.Postgres,
+		pg.WithDurationHistogram(queryDurations),
+		pg.WithSlowQueryLog(time.Duration(conf.SlowQueryMs)*time.Millisecond, nil))
 	if err != nil {
 		log.Fatalf("cannot connect to postgres: %s", err)
 	}
 	defer db.Close()
 
-	pg.MustLoadSchema(db, auth.Schema)
-	pg.MustLoadSchema(db, stream.Schema)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), db, os.Args[2:]); err != nil {
+			log.Fatalf("migration failed: %s", err)
+		}
+		return
+	}
+	pg.MustMigrate(context.Background(), db)
 
 	newspaper := stream.NewNewspaperClient(conf.NewspaperApi, conf.NewspaperApiSecret)
 	bookmarklet := &stream.Bookmarklet{
@@ -62,7 +100,10 @@ func main() {
 		MaxIdle:     3,
 		IdleTimeout: 2 * time.Minute,
 		Dial: func() (redis.Conn, error) {
-			return redis.DialURL(conf.Redis)
+			return redis.DialURL(conf.Redis,
+				redis.DialConnectTimeout(time.Second),
+				redis.DialReadTimeout(time.Second),
+				redis.DialWriteTimeout(time.Second))
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
 			if time.Since(t) < time.Minute {
@@ -73,7 +114,11 @@ func main() {
 		},
 	}
 	defer rp.Close()
-	cacheSrv := cache.NewRedisCacheService(&rp)
+	cacheSrv := cache.NewRedisCacheService(&rp,
+		cache.WithCodec(cache.GobCodec),
+		cache.WithCompression(4<<10),
+		cache.WithTimeout(200*time.Millisecond),
+		cache.WithCircuitBreaker(5, 10*time.Second))
 	oauthRedirectUrl := conf.Site + "/login/success"
 	var providers []*auth.Provider
 	if conf.GoogleOAuth2ClientID != "" && conf.GoogleOAuth2ClientSecret != "" {
@@ -81,8 +126,27 @@ func main() {
 	}
 	authSrv := auth.NewAuthService(db, cacheSrv, providers)
 
-	streamManager := stream.NewManager(db, &rp, newspaper)
-	tmpl, err := ui.NewHTMLRenderer(conf.TemplatesGlob, conf.Debug)
+	locker := lock.NewRedisLocker(&rp)
+	streamManager := stream.NewManager(db, locker, newspaper, conf.Site)
+
+	var blobStore blob.Store
+	if conf.S3Bucket != "" {
+		blobStore = blob.NewS3Store(conf.S3Endpoint, conf.S3Region, conf.S3Bucket, conf.S3AccessKey, conf.S3SecretKey)
+	} else {
+		blobStore, err = blob.NewFSStore(conf.BlobDir)
+		if err != nil {
+			log.Fatalf("cannot create blob store: %s", err)
+		}
+	}
+	archiver := stream.NewPageArchiver(streamManager, blobStore, locker)
+	events, err := stream.NewEventBroker(conf.Postgres)
+	if err != nil {
+		log.Fatalf("cannot create event broker: %s", err)
+	}
+	defer events.Close()
+
+	tmpl, err := ui.NewHTMLRenderer(conf.TemplatesGlob, conf.Debug,
+		ui.WithSanitizeOptions(sanitize.WithImageURL(stream.ProxiedImageURL)))
 	if err != nil {
 		log.Fatalf("cannot create render service: %s", err)
 	}
@@ -90,19 +154,69 @@ func main() {
 	rt := web.NewRouter()
 	rt.Add(`/`, "GET", stream.EntriesHandler(streamManager, authSrv, tmpl))
 	rt.Add(`/subscriptions`, "GET,POST", stream.SubscriptionHandler(streamManager, bookmarklet, authSrv, tmpl))
+	rt.Add(`/subscriptions/feed-token/reset`, "POST", stream.ResetFeedTokenHandler(streamManager, authSrv, tmpl))
+	rt.Add(`/u/(token)/(name:bookmarks|all|tag/[^/]+)\.(format:atom|rss)`, "GET", stream.SyndicationHandler(streamManager, conf.Site))
 	rt.Add(`/subscriptions/(subscription-id)/remove`, "POST", stream.RemoveSubscriptionHandler(streamManager, authSrv, tmpl))
-	rt.Add(`/bookmarks`, "OPTIONS,POST", stream.BookmarkHandler(streamManager, authSrv))
+	rt.Add(`/shares`, "POST", stream.CreateShareHandler(streamManager, authSrv, tmpl))
+	rt.Add(`/shares/(share-id)/remove`, "POST", stream.RemoveShareHandler(streamManager, authSrv, tmpl))
+	rt.Add(`/s/(token:[0-9a-f]+)(format:\.atom|)`, "GET", stream.ShareHandler(streamManager, tmpl, conf.Site))
+	rt.Add(`/events`, "GET", stream.EventsHandler(streamManager, events, authSrv, tmpl))
+	rt.Add(`/bookmarks`, "OPTIONS,POST", stream.BookmarkHandler(streamManager, archiver, authSrv))
+	rt.Add(`/bookmarks/(entry-id)`, "GET,POST", stream.EditBookmarkHandler(streamManager, authSrv, tmpl))
+	rt.Add(`/entries/(entry-id)`, "GET", stream.ReaderHandler(streamManager, authSrv, tmpl))
+	rt.Add(`/entries/(entry-id)/archive`, "GET", stream.ArchiveHandler(streamManager, blobStore, authSrv, tmpl))
+	rt.Add(`/images`, "GET", stream.ImageProxyHandler(authSrv))
+	rt.Add(`/import`, "GET,POST", stream.ImportHandler(streamManager, archiver, authSrv, cacheSrv, tmpl))
+	rt.Add(`/import/(job-id)`, "GET", stream.ImportProgressHandler(authSrv, cacheSrv, tmpl))
 	rt.Add(`/bookmarklet`, "GET", stream.BookmarkletHandler())
+	rt.Add(`/account/export`, "GET", stream.ExportAccountHandler(streamManager, blobStore, authSrv, tmpl))
+	rt.Add(`/account/delete`, "POST", stream.DeleteAccountHandler(streamManager, blobStore, authSrv, tmpl, conf.Site))
+
+	rt.Add(`/api/v1/openapi.json`, "GET", stream.APIDocHandler(filepath.Join(conf.StaticsDir, "api", "openapi.json")))
+	rt.Add(`/api/v1/entries`, "GET", stream.APIEntriesHandler(streamManager, authSrv))
+	rt.Add(`/api/v1/entries/(entry-id)`, "GET", stream.APIEntryHandler(streamManager, authSrv))
+	rt.Add(`/api/v1/subscriptions`, "GET,POST", stream.APISubscriptionsHandler(streamManager, authSrv))
+	rt.Add(`/api/v1/subscriptions/(subscription-id)`, "DELETE", stream.APISubscriptionHandler(streamManager, authSrv))
+	rt.Add(`/api/v1/feeds/(feed-id)`, "GET", stream.APIFeedHandler(streamManager, authSrv))
+	rt.Add(`/api/v1/bookmarks`, "GET,POST", stream.APIBookmarksHandler(streamManager, archiver, authSrv))
+	rt.Add(`/api/v1/bookmarks/(entry-id)`, "PUT", stream.APIBookmarkHandler(streamManager, authSrv))
+
+	rt.Add(`/accounts/ClientLogin`, "GET,POST", greader.ClientLoginHandler(authSrv))
+	rt.Add(`/reader/api/0/token`, "GET", greader.TokenHandler(authSrv))
+	rt.Add(`/reader/api/0/user-info`, "GET", greader.UserInfoHandler(authSrv))
+	rt.Add(`/reader/api/0/subscription/list`, "GET", greader.SubscriptionListHandler(streamManager, authSrv))
+	rt.Add(`/reader/api/0/subscription/edit`, "POST", greader.SubscriptionEditHandler(streamManager, authSrv))
+	rt.Add(`/reader/api/0/stream/contents/(stream-id:.+)`, "GET", greader.StreamContentsHandler(streamManager, authSrv))
+	rt.Add(`/reader/api/0/stream/contents`, "GET,POST", greader.StreamContentsHandler(streamManager, authSrv))
+	rt.Add(`/reader/api/0/stream/items/ids`, "GET,POST", greader.StreamItemIDsHandler(streamManager, authSrv))
+	rt.Add(`/reader/api/0/edit-tag`, "POST", greader.EditTagHandler(streamManager, authSrv))
+	rt.Add(`/reader/api/0/unread-count`, "GET", greader.UnreadCountHandler(streamManager, authSrv))
+
+	rt.Add(`/fever/?`, "GET,POST", fever.Handler(streamManager, authSrv, cacheSrv))
 
 	rt.Add(`/login`, "GET", auth.SelectLoginHandler(authSrv, tmpl))
 	rt.Add(`/login/success`, "GET", auth.OAuthLoginCallbackHandler(authSrv, cacheSrv, tmpl))
 	rt.Add(`/login/(provider)`, "GET", auth.OAuthLoginHandler(authSrv, cacheSrv, tmpl))
+	rt.Add(`/settings`, "GET", auth.SettingsHandler(authSrv, tmpl))
+	rt.Add(`/settings/app-passwords`, "POST", auth.CreateAppPasswordHandler(authSrv, tmpl))
+	rt.Add(`/settings/app-passwords/(app-password-id)/delete`, "POST", auth.DeleteAppPasswordHandler(authSrv, tmpl))
 
 	rt.Add(`/static/.*`, "GET", http.StripPrefix("/static", http.FileServer(http.Dir(conf.StaticsDir))))
 
 	// XXX
 	rt.Add(`/_/updateoutdated`, "POST", stream.UpdateOutdatedHandler(streamManager))
 
+	if conf.InternalHTTPPort != "" {
+		internal := web.NewRouter()
+		internal.Add(`/debug/vars`, "GET", expvar.Handler())
+		internal.Add(`/_/archivebookmarks`, "POST", stream.ArchiveBookmarksHandler(archiver))
+		go func() {
+			if err := http.ListenAndServe("localhost:"+conf.InternalHTTPPort, internal); err != nil {
+				log.Fatalf("internal server error: %s", err)
+			}
+		}()
+	}
+
 	if err := http.ListenAndServe("localhost:"+conf.HTTPPort, rt); err != nil {
 		log.Fatalf("server error: %s", err)
 	}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/husio/feedstream/pg"
+)
+
+const migrateUsage = `usage:
+	migrate up                     apply all pending migrations
+	migrate down <package> [steps] revert recent migrations of the package
+	migrate status                 list all migrations
+`
+
+// runMigrate executes migrate subcommand with given arguments.
+func runMigrate(ctx context.Context, db pg.Database, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return errors.New("command required")
+	}
+
+	switch args[0] {
+	case "up":
+		return pg.Migrate(ctx, db)
+	case "down":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, migrateUsage)
+			return errors.New("package name required")
+		}
+		steps := 1
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid steps number: %q", args[2])
+			}
+			steps = n
+		}
+		return pg.MigrateDown(ctx, db, args[1], steps)
+	case "status":
+		states, err := pg.Migrations(ctx, db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PACKAGE\tVERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			applied := "pending"
+			if !s.Applied.IsZero() {
+				applied = s.Applied.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", s.Package, s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
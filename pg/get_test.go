@@ -0,0 +1,43 @@
+package pg_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/pg/pgtest"
+)
+
+func TestGetContext(t *testing.T) {
+	ctx := context.Background()
+	db := pg.Use(pgtest.CreateDB(t, nil))
+
+	var n int
+	if err := db.GetContext(ctx, &n, `SELECT x FROM generate_series(1, 100000) x ORDER BY x`); err != nil {
+		t.Fatalf("cannot get scalar: %s", err)
+	}
+	if n != 1 {
+		t.Fatalf("want first row, got %d", n)
+	}
+
+	var now time.Time
+	if err := db.GetContext(ctx, &now, `SELECT now()`); err != nil {
+		t.Fatalf("cannot get time: %s", err)
+	}
+
+	var row struct {
+		ID   int
+		Name string
+	}
+	if err := db.GetContext(ctx, &row, `SELECT 3 AS id, 'three' AS name`); err != nil {
+		t.Fatalf("cannot get struct: %s", err)
+	}
+	if row.ID != 3 || row.Name != "three" {
+		t.Fatalf("unexpected row: %+v", row)
+	}
+
+	if err := db.GetContext(ctx, &n, `SELECT 1 WHERE false`); err != pg.ErrNotFound {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package pg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Option configures database created by Use or Connect functions.
+type Option func(*options)
+
+type options struct {
+	hooks []QueryHook
+}
+
+// QueryHook is notified about every query made using Database or any
+// Connection created by it.
+type QueryHook interface {
+	// BeforeQuery is called before query is executed. Returned context is
+	// used to execute the query and is passed to AfterQuery. Methods that
+	// do not accept context, such as Get or Commit, cannot pass it to the
+	// database driver.
+	BeforeQuery(ctx context.Context, q *Query) context.Context
+
+	// AfterQuery is called after query is executed, with Duration and Err
+	// attributes set.
+	AfterQuery(ctx context.Context, q *Query)
+}
+
+// Query represents single database operation.
+type Query struct {
+	// Method is the name of the called Database or Connection method,
+	// for example Get, ExecContext or Commit.
+	Method   string
+	SQL      string
+	Args     []interface{}
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// WithQueryHook registers hook that is called for every query.
+func WithQueryHook(h QueryHook) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, h)
+	}
+}
+
+// WithDurationHistogram registers hook that records every query duration in
+// given histogram.
+func WithDurationHistogram(h *Histogram) Option {
+	return WithQueryHook(&histogramHook{h: h})
+}
+
+// WithSlowQueryLog registers hook that logs every query that took longer
+// than given threshold. If logf is nil, standard logger is used.
+func WithSlowQueryLog(threshold time.Duration, logf func(string, ...interface{})) Option {
+	if logf == nil {
+		logf = log.Printf
+	}
+	return WithQueryHook(&slowQueryHook{threshold: threshold, logf: logf})
+}
+
+// WithTracer registers hook that creates tracing span for every query.
+func WithTracer(t Tracer) Option {
+	return WithQueryHook(&tracerHook{t: t})
+}
+
+// Tracer creates tracing spans. It's interface can be easily implemented
+// using any tracing library.
+type Tracer interface {
+	StartSpan(ctx context.Context, operation string) (context.Context, Span)
+}
+
+// Span represents single traced operation.
+type Span interface {
+	SetTag(key string, value interface{})
+	Finish()
+}
+
+// observe runs given function, notifying all registered hooks about it.
+// Function is called with the context returned by the hooks.
+func (o *options) observe(ctx context.Context, method, query string, args []interface{}, fn func(context.Context) error) error {
+	if o == nil || len(o.hooks) == 0 {
+		return fn(ctx)
+	}
+
+	q := &Query{
+		Method: method,
+		SQL:    query,
+		Args:   args,
+		Start:  time.Now(),
+	}
+	for _, h := range o.hooks {
+		ctx = h.BeforeQuery(ctx, q)
+	}
+	q.Err = fn(ctx)
+	q.Duration = time.Since(q.Start)
+	for i := len(o.hooks) - 1; i >= 0; i-- {
+		o.hooks[i].AfterQuery(ctx, q)
+	}
+	return q.Err
+}
+
+type histogramHook struct {
+	h *Histogram
+}
+
+func (hh *histogramHook) BeforeQuery(ctx context.Context, q *Query) context.Context {
+	return ctx
+}
+
+func (hh *histogramHook) AfterQuery(ctx context.Context, q *Query) {
+	name := q.Method
+	if q.SQL != "" {
+		name = NormalizeSQL(q.SQL)
+	}
+	hh.h.Observe(name, q.Duration)
+}
+
+type slowQueryHook struct {
+	threshold time.Duration
+	logf      func(string, ...interface{})
+}
+
+func (sh *slowQueryHook) BeforeQuery(ctx context.Context, q *Query) context.Context {
+	return ctx
+}
+
+func (sh *slowQueryHook) AfterQuery(ctx context.Context, q *Query) {
+	if q.Duration < sh.threshold {
+		return
+	}
+	sh.logf("slow query: %s %s: %s", q.Method, q.Duration, NormalizeSQL(q.SQL))
+}
+
+type tracerHook struct {
+	t Tracer
+}
+
+type spanKey struct{}
+
+func (th *tracerHook) BeforeQuery(ctx context.Context, q *Query) context.Context {
+	ctx, span := th.t.StartSpan(ctx, "pg."+q.Method)
+	if q.SQL != "" {
+		span.SetTag("db.statement", NormalizeSQL(q.SQL))
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (th *tracerHook) AfterQuery(ctx context.Context, q *Query) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	if !ok {
+		return
+	}
+	if q.Err != nil {
+		span.SetTag("error", q.Err.Error())
+	}
+	span.Finish()
+}
+
+// NormalizeSQL returns query with all whitespaces collapsed and literal
+// values replaced with question mark, so that it can be used to group
+// queries.
+func NormalizeSQL(query string) string {
+	var (
+		b     bytes.Buffer
+		runes = []rune(query)
+		space bool
+	)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			// comment till the end of the line
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case r == '\'':
+			// string literal, where quote is escaped by another quote
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case unicode.IsDigit(r) && !partOfIdent(b.Bytes()):
+			for i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// partOfIdent returns true if next character written to given buffer would
+// be part of an identifier or a placeholder.
+func partOfIdent(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	c := rune(b[len(b)-1])
+	return c == '$' || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// Histogram records duration of the queries, grouped by name. It implements
+// expvar.Var interface, so that it can be published.
+type Histogram struct {
+	buckets []time.Duration
+
+	mu     sync.Mutex
+	series map[string]*HistogramSeries
+}
+
+// HistogramSeries represents duration distribution of single query.
+// Counts[i] is the number of observations not greater than Buckets[i].
+// Observations greater than the last bucket are counted only by Count.
+type HistogramSeries struct {
+	Buckets []time.Duration
+	Counts  []int64
+	Count   int64
+	Sum     time.Duration
+}
+
+// DefaultBuckets are used by histogram created without buckets.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// NewHistogram returns histogram using given buckets upper limits. If no
+// buckets are given, DefaultBuckets are used.
+func NewHistogram(buckets ...time.Duration) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]time.Duration(nil), buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return &Histogram{
+		buckets: buckets,
+		series:  make(map[string]*HistogramSeries),
+	}
+}
+
+// Observe records single duration for given name.
+func (h *Histogram) Observe(name string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[name]
+	if !ok {
+		s = &HistogramSeries{
+			Buckets: h.buckets,
+			Counts:  make([]int64, len(h.buckets)),
+		}
+		h.series[name] = s
+	}
+	s.Count++
+	s.Sum += d
+	for i, limit := range h.buckets {
+		if d <= limit {
+			s.Counts[i]++
+		}
+	}
+}
+
+// Snapshot returns copy of all recorded series.
+func (h *Histogram) Snapshot() map[string]HistogramSeries {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snap := make(map[string]HistogramSeries, len(h.series))
+	for name, s := range h.series {
+		cp := *s
+		cp.Counts = append([]int64(nil), s.Counts...)
+		snap[name] = cp
+	}
+	return snap
+}
+
+// String returns JSON serialized histogram.
+func (h *Histogram) String() string {
+	type series struct {
+		Buckets map[string]int64 `json:"buckets"`
+		Count   int64            `json:"count"`
+		Sum     float64          `json:"sum_seconds"`
+	}
+	out := make(map[string]series)
+	for name, s := range h.Snapshot() {
+		buckets := make(map[string]int64, len(s.Buckets))
+		for i, limit := range s.Buckets {
+			buckets[limit.String()] = s.Counts[i]
+		}
+		out[name] = series{
+			Buckets: buckets,
+			Count:   s.Count,
+			Sum:     s.Sum.Seconds(),
+		}
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
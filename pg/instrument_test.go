@@ -0,0 +1,126 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	cases := map[string]struct {
+		SQL  string
+		Want string
+	}{
+		"whitespaces": {
+			SQL: `
+				SELECT *
+				FROM feeds
+				WHERE feed_id = $1
+			`,
+			Want: "SELECT * FROM feeds WHERE feed_id = $1",
+		},
+		"string-literals": {
+			SQL:  `SELECT 'it''s', 'x' FROM feeds`,
+			Want: "SELECT ?, ? FROM feeds",
+		},
+		"numbers": {
+			SQL:  `SELECT * FROM entries WHERE word_count > 200 AND x = 1.5 LIMIT 10`,
+			Want: "SELECT * FROM entries WHERE word_count > ? AND x = ? LIMIT ?",
+		},
+		"identifiers-and-placeholders": {
+			SQL:  `SELECT col2 FROM t1 WHERE a = $12`,
+			Want: "SELECT col2 FROM t1 WHERE a = $12",
+		},
+		"comments": {
+			SQL:  "SELECT 1 -- comment\nFROM feeds",
+			Want: "SELECT ? FROM feeds",
+		},
+	}
+
+	for tname, tc := range cases {
+		t.Run(tname, func(t *testing.T) {
+			if got := NormalizeSQL(tc.SQL); got != tc.Want {
+				t.Fatalf("want %q, got %q", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestHistogramObserve(t *testing.T) {
+	h := NewHistogram(10*time.Millisecond, time.Millisecond)
+
+	h.Observe("q", 500*time.Microsecond)
+	h.Observe("q", 5*time.Millisecond)
+	h.Observe("q", time.Second)
+
+	s, ok := h.Snapshot()["q"]
+	if !ok {
+		t.Fatal("series not found")
+	}
+	if s.Count != 3 {
+		t.Fatalf("want 3 observations, got %d", s.Count)
+	}
+	if s.Counts[0] != 1 || s.Counts[1] != 2 {
+		t.Fatalf("invalid bucket counts: %v", s.Counts)
+	}
+}
+
+func TestObserveCallsHooks(t *testing.T) {
+	var logged []string
+	o := buildOptions([]Option{
+		WithSlowQueryLog(0, func(format string, args ...interface{}) {
+			logged = append(logged, format)
+		}),
+	})
+
+	wantErr := errors.New("failure")
+	err := o.observe(context.Background(), "Exec", "SELECT 1", nil, func(context.Context) error {
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("want %v, got %v", wantErr, err)
+	}
+	if len(logged) != 1 {
+		t.Fatalf("want one slow query logged, got %d", len(logged))
+	}
+}
+
+type stubTracer struct {
+	spans []*stubSpan
+}
+
+type stubSpan struct {
+	operation string
+	tags      map[string]interface{}
+	finished  bool
+}
+
+func (t *stubTracer) StartSpan(ctx context.Context, operation string) (context.Context, Span) {
+	s := &stubSpan{operation: operation, tags: make(map[string]interface{})}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, t, s), s
+}
+
+func (s *stubSpan) SetTag(key string, value interface{}) { s.tags[key] = value }
+func (s *stubSpan) Finish()                              { s.finished = true }
+
+func TestObserveHookContext(t *testing.T) {
+	tracer := &stubTracer{}
+	o := buildOptions([]Option{WithTracer(tracer)})
+
+	err := o.observe(context.Background(), "ExecContext", "SELECT 1", nil, func(ctx context.Context) error {
+		// query must run within the span, so that driver work can be
+		// traced as its child
+		if ctx.Value(tracer) == nil {
+			t.Fatal("query context does not carry the span")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tracer.spans) != 1 || !tracer.spans[0].finished || tracer.spans[0].operation != "pg.ExecContext" {
+		t.Fatalf("unexpected spans: %+v", tracer.spans)
+	}
+}
@@ -0,0 +1,289 @@
+package pg
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Migration represents single, versioned change of the database schema.
+// Once released, migration must never be changed. Instead, new migration
+// with higher version must be registered.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState describes registered migration and when it was applied. Not
+// yet applied migration has zero Applied time.
+type MigrationState struct {
+	Package string
+	Version int
+	Name    string
+	Applied time.Time
+}
+
+var registry struct {
+	mu       sync.Mutex
+	packages []string
+	byPkg    map[string][]Migration
+}
+
+// RegisterMigrations registers schema migrations of given package. Packages
+// are migrated in the order of registration, so package must be registered
+// after all packages it depends on. Within single package, migrations are
+// applied in version order.
+//
+// This function is meant to be called from package init function.
+func RegisterMigrations(pkg string, migrations ...Migration) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registry.byPkg == nil {
+		registry.byPkg = make(map[string][]Migration)
+	}
+	if _, ok := registry.byPkg[pkg]; !ok {
+		registry.packages = append(registry.packages, pkg)
+	}
+
+	all := append(registry.byPkg[pkg], migrations...)
+	sort.Sort(byVersion(all))
+	for i := 1; i < len(all); i++ {
+		if all[i].Version == all[i-1].Version {
+			panic(fmt.Sprintf("%s migration version %d registered twice", pkg, all[i].Version))
+		}
+	}
+	registry.byPkg[pkg] = all
+}
+
+type byVersion []Migration
+
+func (v byVersion) Len() int           { return len(v) }
+func (v byVersion) Less(i, j int) bool { return v[i].Version < v[j].Version }
+func (v byVersion) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// migrationsLockID is used to acquire PostgreSQL advisory lock while
+// migrating, so that only one process at a time can change the schema.
+const migrationsLockID = 5736101
+
+// MustMigrate apply all pending migrations. On failure, it prints error
+// and exit the program.
+func MustMigrate(ctx context.Context, db Database) {
+	err := Migrate(ctx, db)
+	if err == nil {
+		return
+	}
+
+	if err, ok := err.(*SchemaError); ok {
+		fmt.Fprintf(os.Stderr, "cannot migrate schema: %s\n%s\n", err, err.Query)
+	} else {
+		fmt.Fprintf(os.Stderr, "cannot migrate schema: %s\n", err)
+	}
+	os.Exit(1)
+}
+
+// Migrate apply all registered, not yet applied migrations. All migrations
+// are applied within single transaction.
+func Migrate(ctx context.Context, db Database) error {
+	return withMigrationsLock(ctx, db, func(tx Connection) error {
+		applied, err := appliedMigrations(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+
+		for _, pkg := range registry.packages {
+			for _, m := range registry.byPkg[pkg] {
+				if _, ok := applied[migrationKey{pkg, m.Version}]; ok {
+					continue
+				}
+				if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+					return &SchemaError{
+						Package: pkg,
+						Version: m.Version,
+						Query:   m.Up,
+						Err:     err,
+					}
+				}
+				_, err := tx.ExecContext(ctx, `
+					INSERT INTO schema_migrations (package, version, name, applied)
+					VALUES ($1, $2, $3, $4)
+				`, pkg, m.Version, m.Name, time.Now())
+				if err != nil {
+					return fmt.Errorf("cannot mark %s migration %d as applied: %s", pkg, m.Version, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts given number of the most recently applied migrations
+// of given package.
+func MigrateDown(ctx context.Context, db Database, pkg string, steps int) error {
+	registry.mu.Lock()
+	migrations, ok := registry.byPkg[pkg]
+	registry.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no migrations registered for %q", pkg)
+	}
+
+	return withMigrationsLock(ctx, db, func(tx Connection) error {
+		applied, err := appliedMigrations(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[migrationKey{pkg, m.Version}]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("%s migration %d cannot be reverted", pkg, m.Version)
+			}
+			if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+				return &SchemaError{
+					Package: pkg,
+					Version: m.Version,
+					Query:   m.Down,
+					Err:     err,
+				}
+			}
+			_, err := tx.ExecContext(ctx, `
+				DELETE FROM schema_migrations
+				WHERE package = $1 AND version = $2
+			`, pkg, m.Version)
+			if err != nil {
+				return fmt.Errorf("cannot mark %s migration %d as reverted: %s", pkg, m.Version, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Migrations returns state of all registered migrations.
+func Migrations(ctx context.Context, db Database) ([]*MigrationState, error) {
+	var states []*MigrationState
+	err := withMigrationsLock(ctx, db, func(tx Connection) error {
+		applied, err := appliedMigrations(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+
+		for _, pkg := range registry.packages {
+			for _, m := range registry.byPkg[pkg] {
+				states = append(states, &MigrationState{
+					Package: pkg,
+					Version: m.Version,
+					Name:    m.Name,
+					Applied: applied[migrationKey{pkg, m.Version}],
+				})
+			}
+		}
+		return nil
+	})
+	return states, err
+}
+
+// MigrationsChecksum returns checksum of all registered migrations. It
+// changes whenever any migration is registered or modified.
+func MigrationsChecksum() string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	h := sha1.New()
+	for _, pkg := range registry.packages {
+		for _, m := range registry.byPkg[pkg] {
+			fmt.Fprintf(h, "%s\x00%d\x00%s\x00%s\x00", pkg, m.Version, m.Up, m.Down)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type migrationKey struct {
+	pkg     string
+	version int
+}
+
+func appliedMigrations(ctx context.Context, c Connection) (map[migrationKey]time.Time, error) {
+	var rows []struct {
+		Package string
+		Version int
+		Applied time.Time
+	}
+	err := c.SelectContext(ctx, &rows, `
+		SELECT package, version, applied FROM schema_migrations
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list applied migrations: %s", err)
+	}
+	applied := make(map[migrationKey]time.Time)
+	for _, r := range rows {
+		applied[migrationKey{r.Package, r.Version}] = r.Applied
+	}
+	return applied, nil
+}
+
+// withMigrationsLock run given function within transaction, holding
+// migrations advisory lock. Transaction is commited only if function
+// returns no error.
+func withMigrationsLock(ctx context.Context, db Database, fn func(Connection) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("cannot start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationsLockID); err != nil {
+		return fmt.Errorf("cannot acquire lock: %s", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS
+		schema_migrations (
+			package TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			applied TIMESTAMPTZ NOT NULL,
+
+			PRIMARY KEY (package, version)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("cannot create migrations table: %s", err)
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit transaction: %s", err)
+	}
+	return nil
+}
+
+type SchemaError struct {
+	Package string
+	Version int
+	Query   string
+	Err     error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("schema error: %s migration %d: %s", e.Package, e.Version, e.Err.Error())
+}
@@ -0,0 +1,44 @@
+package pg
+
+import "testing"
+
+func TestRegisterMigrationsOrder(t *testing.T) {
+	RegisterMigrations("test-order",
+		Migration{Version: 3, Name: "third"},
+		Migration{Version: 1, Name: "first"},
+	)
+	RegisterMigrations("test-order",
+		Migration{Version: 2, Name: "second"},
+	)
+
+	var names []string
+	for _, m := range registry.byPkg["test-order"] {
+		names = append(names, m.Name)
+	}
+	if len(names) != 3 || names[0] != "first" || names[1] != "second" || names[2] != "third" {
+		t.Fatalf("invalid migrations order: %v", names)
+	}
+}
+
+func TestRegisterMigrationsDuplicatedVersion(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicated version registered")
+		}
+	}()
+	RegisterMigrations("test-duplicated",
+		Migration{Version: 1, Name: "first"},
+		Migration{Version: 1, Name: "second"},
+	)
+}
+
+func TestMigrationsChecksum(t *testing.T) {
+	before := MigrationsChecksum()
+	if before != MigrationsChecksum() {
+		t.Fatal("checksum is not stable")
+	}
+	RegisterMigrations("test-checksum", Migration{Version: 1, Name: "first"})
+	if before == MigrationsChecksum() {
+		t.Fatal("checksum not changed after registering migration")
+	}
+}
@@ -1,30 +1,44 @@
 package pg
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
 	"github.com/lib/pq"
 )
 
 // Getter is generic interface for getting single entity
 type Getter interface {
 	Get(dest interface{}, query string, args ...interface{}) error
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 }
 
 // Selector is generic interface for getting multiple enties
 type Selector interface {
 	Select(dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 }
 
 // Execer is generic interface for executing SQL query with no result
 type Execer interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
 type Database interface {
 	Beginx() (Connection, error)
+
+	// BeginTx starts transaction that is rolled back when given context
+	// is cancelled. If options are not provided, database defaults are
+	// used.
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (Connection, error)
+
 	Getter
 	Selector
 	Execer
@@ -43,42 +57,116 @@ type Connection interface {
 // can be easily mocked. This wrapper is required, because of sqlx.DB's Beginx
 // method notation
 type sqlxDb struct {
-	dbx *sqlx.DB
+	dbx  *sqlx.DB
+	opts *options
 }
 
 var _ Database = (*sqlxDb)(nil)
 
-func Use(db *sql.DB) Database {
+func Use(db *sql.DB, opts ...Option) Database {
 	dbx := sqlx.NewDb(db, "postgres")
-	return &sqlxDb{dbx: dbx}
+	return &sqlxDb{dbx: dbx, opts: buildOptions(opts)}
 }
 
-func Connect(credentials string) (Database, error) {
+func Connect(credentials string, opts ...Option) (Database, error) {
 	dbx, err := sqlx.Connect("postgres", credentials)
 	if err != nil {
 		return nil, err
 	}
-	return &sqlxDb{dbx: dbx}, nil
+	return &sqlxDb{dbx: dbx, opts: buildOptions(opts)}, nil
+}
+
+func buildOptions(opts []Option) *options {
+	var o options
+	for _, fn := range opts {
+		fn(&o)
+	}
+	return &o
 }
 
 func (x *sqlxDb) Beginx() (Connection, error) {
-	tx, err := x.dbx.Beginx()
-	return &sqlxTx{tx: tx}, castErr(err)
+	var tx *sqlx.Tx
+	err := x.opts.observe(context.Background(), "Beginx", "", nil, func(context.Context) (err error) {
+		tx, err = x.dbx.Beginx()
+		return castErr(err)
+	})
+	return &sqlxTx{tx: tx, opts: x.opts}, err
+}
+
+func (x *sqlxDb) BeginTx(ctx context.Context, opts *sql.TxOptions) (Connection, error) {
+	// database driver does not support transaction options, so they must
+	// be set using SQL statement
+	setopts, err := txOptionsQuery(opts)
+	if err != nil {
+		return nil, err
+	}
+	var tx *sql.Tx
+	err = x.opts.observe(ctx, "BeginTx", setopts, nil, func(ctx context.Context) (err error) {
+		tx, err = x.dbx.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return castErr(err)
+		}
+		if setopts != "" {
+			if _, err := tx.ExecContext(ctx, setopts); err != nil {
+				tx.Rollback()
+				return castErr(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	txx := &sqlx.Tx{Tx: tx, Mapper: x.dbx.Mapper}
+	return &sqlxTx{tx: txx, opts: x.opts}, nil
 }
 
 func (x *sqlxDb) Get(dest interface{}, query string, args ...interface{}) error {
-	err := x.dbx.Get(dest, query, args...)
-	return castErr(err)
+	return x.opts.observe(context.Background(), "Get", query, args, func(context.Context) error {
+		err := x.dbx.Get(dest, query, args...)
+		return castErr(err)
+	})
+}
+
+func (x *sqlxDb) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return x.opts.observe(ctx, "GetContext", query, args, func(ctx context.Context) error {
+		q := &ctxQueryer{ctx: ctx, q: x.dbx.DB, ext: x.dbx, mapper: x.dbx.Mapper}
+		err := getContext(q, dest, query, args...)
+		return castErr(err)
+	})
 }
 
 func (x *sqlxDb) Select(dest interface{}, query string, args ...interface{}) error {
-	err := x.dbx.Select(dest, query, args...)
-	return castErr(err)
+	return x.opts.observe(context.Background(), "Select", query, args, func(context.Context) error {
+		err := x.dbx.Select(dest, query, args...)
+		return castErr(err)
+	})
+}
+
+func (x *sqlxDb) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return x.opts.observe(ctx, "SelectContext", query, args, func(ctx context.Context) error {
+		q := &ctxQueryer{ctx: ctx, q: x.dbx.DB, ext: x.dbx, mapper: x.dbx.Mapper}
+		err := sqlx.Select(q, dest, query, args...)
+		return castErr(err)
+	})
 }
 
 func (x *sqlxDb) Exec(query string, args ...interface{}) (sql.Result, error) {
-	res, err := x.dbx.Exec(query, args...)
-	return res, castErr(err)
+	var res sql.Result
+	err := x.opts.observe(context.Background(), "Exec", query, args, func(context.Context) (err error) {
+		res, err = x.dbx.Exec(query, args...)
+		return castErr(err)
+	})
+	return res, err
+}
+
+func (x *sqlxDb) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := x.opts.observe(ctx, "ExecContext", query, args, func(ctx context.Context) (err error) {
+		res, err = x.dbx.DB.ExecContext(ctx, query, args...)
+		return castErr(err)
+	})
+	return res, err
 }
 
 func (x *sqlxDb) Close() error {
@@ -87,34 +175,182 @@ func (x *sqlxDb) Close() error {
 }
 
 type sqlxTx struct {
-	tx *sqlx.Tx
+	tx   *sqlx.Tx
+	opts *options
 }
 
 var _ Connection = (*sqlxTx)(nil)
 
 func (x *sqlxTx) Get(dest interface{}, query string, args ...interface{}) error {
-	err := x.tx.Get(dest, query, args...)
-	return castErr(err)
+	return x.opts.observe(context.Background(), "Get", query, args, func(context.Context) error {
+		err := x.tx.Get(dest, query, args...)
+		return castErr(err)
+	})
+}
+
+func (x *sqlxTx) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return x.opts.observe(ctx, "GetContext", query, args, func(ctx context.Context) error {
+		q := &ctxQueryer{ctx: ctx, q: x.tx.Tx, ext: x.tx, mapper: x.tx.Mapper}
+		err := getContext(q, dest, query, args...)
+		return castErr(err)
+	})
 }
 
 func (x *sqlxTx) Select(dest interface{}, query string, args ...interface{}) error {
-	err := x.tx.Select(dest, query, args...)
-	return castErr(err)
+	return x.opts.observe(context.Background(), "Select", query, args, func(context.Context) error {
+		err := x.tx.Select(dest, query, args...)
+		return castErr(err)
+	})
+}
+
+func (x *sqlxTx) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return x.opts.observe(ctx, "SelectContext", query, args, func(ctx context.Context) error {
+		q := &ctxQueryer{ctx: ctx, q: x.tx.Tx, ext: x.tx, mapper: x.tx.Mapper}
+		err := sqlx.Select(q, dest, query, args...)
+		return castErr(err)
+	})
 }
 
 func (x *sqlxTx) Exec(query string, args ...interface{}) (sql.Result, error) {
-	res, err := x.tx.Exec(query, args...)
-	return res, castErr(err)
+	var res sql.Result
+	err := x.opts.observe(context.Background(), "Exec", query, args, func(context.Context) (err error) {
+		res, err = x.tx.Exec(query, args...)
+		return castErr(err)
+	})
+	return res, err
+}
+
+func (x *sqlxTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := x.opts.observe(ctx, "ExecContext", query, args, func(ctx context.Context) (err error) {
+		res, err = x.tx.Tx.ExecContext(ctx, query, args...)
+		return castErr(err)
+	})
+	return res, err
 }
 
 func (x *sqlxTx) Rollback() error {
-	err := x.tx.Rollback()
-	return castErr(err)
+	return x.opts.observe(context.Background(), "Rollback", "", nil, func(context.Context) error {
+		err := x.tx.Rollback()
+		return castErr(err)
+	})
 }
 
 func (x *sqlxTx) Commit() error {
-	err := x.tx.Commit()
-	return castErr(err)
+	return x.opts.observe(context.Background(), "Commit", "", nil, func(context.Context) error {
+		err := x.tx.Commit()
+		return castErr(err)
+	})
+}
+
+// ctxQueryer implements sqlx.Queryer interface using context aware query
+// methods, so that sqlx helper functions can be used with context.
+//
+// Only Query and Queryx methods are context aware. QueryRowx is using
+// underlying sqlx structure, because sqlx.Row cannot be created outside of
+// sqlx package.
+type ctxQueryer struct {
+	ctx    context.Context
+	q      queryerContext
+	ext    sqlx.Queryer
+	mapper *reflectx.Mapper
+}
+
+type queryerContext interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+var _ sqlx.Queryer = (*ctxQueryer)(nil)
+
+func (c *ctxQueryer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.q.QueryContext(c.ctx, query, args...)
+}
+
+func (c *ctxQueryer) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	rows, err := c.q.QueryContext(c.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlx.Rows{Rows: rows, Mapper: c.mapper}, nil
+}
+
+func (c *ctxQueryer) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	return c.ext.QueryRowx(query, args...)
+}
+
+// getContext works like sqlx.Get, but it's using Queryx method of given
+// queryer, so that context can be used. Only the first row is read, the
+// rest of the result is discarded.
+func getContext(q sqlx.Queryer, dest interface{}, query string, args ...interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("must pass a non nil pointer to Get destination")
+	}
+	rows, err := q.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if isScannable(v.Type().Elem(), rows.Mapper) {
+		err = rows.Scan(dest)
+	} else {
+		err = rows.StructScan(dest)
+	}
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+var scannerInterface = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// isScannable returns true if value of given type is scanned from a single
+// column rather than mapped to struct fields, using the same rules as sqlx.
+func isScannable(t reflect.Type, m *reflectx.Mapper) bool {
+	if reflect.PtrTo(t).Implements(scannerInterface) || t.Kind() != reflect.Struct {
+		return true
+	}
+	if m == nil {
+		m = reflectx.NewMapperFunc("db", strings.ToLower)
+	}
+	return len(m.TypeMap(t).Index) == 0
+}
+
+// txOptionsQuery returns SQL statement that must be executed as the first
+// statement of the transaction in order to apply given options.
+func txOptionsQuery(opts *sql.TxOptions) (string, error) {
+	if opts == nil {
+		return "", nil
+	}
+	var chunks []string
+	switch opts.Isolation {
+	case sql.LevelDefault:
+		// nothing to set
+	case sql.LevelReadUncommitted:
+		chunks = append(chunks, "ISOLATION LEVEL READ UNCOMMITTED")
+	case sql.LevelReadCommitted:
+		chunks = append(chunks, "ISOLATION LEVEL READ COMMITTED")
+	case sql.LevelRepeatableRead:
+		chunks = append(chunks, "ISOLATION LEVEL REPEATABLE READ")
+	case sql.LevelSerializable:
+		chunks = append(chunks, "ISOLATION LEVEL SERIALIZABLE")
+	default:
+		return "", fmt.Errorf("isolation level %d not supported", opts.Isolation)
+	}
+	if opts.ReadOnly {
+		chunks = append(chunks, "READ ONLY")
+	}
+	if len(chunks) == 0 {
+		return "", nil
+	}
+	return "SET TRANSACTION " + strings.Join(chunks, " "), nil
 }
 
 // castErr inspect given error and replace generic SQL error with easier to
@@ -128,13 +364,22 @@ func castErr(err error) error {
 	if err == sql.ErrNoRows {
 		return ErrNotFound
 	}
-	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
-		return ErrConflict
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code {
+		case "23505":
+			return ErrConflict
+		case "40001":
+			return ErrSerialization
+		case "40P01":
+			return ErrDeadlock
+		}
 	}
 	return err
 }
 
 var (
-	ErrNotFound = errors.New("not found")
-	ErrConflict = errors.New("conflict")
+	ErrNotFound      = errors.New("not found")
+	ErrConflict      = errors.New("conflict")
+	ErrSerialization = errors.New("serialization failure")
+	ErrDeadlock      = errors.New("deadlock detected")
 )
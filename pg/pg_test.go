@@ -0,0 +1,57 @@
+package pg
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestTxOptionsQuery(t *testing.T) {
+	cases := map[string]struct {
+		Opts  *sql.TxOptions
+		Query string
+		Err   bool
+	}{
+		"nil": {
+			Opts:  nil,
+			Query: "",
+		},
+		"default": {
+			Opts:  &sql.TxOptions{},
+			Query: "",
+		},
+		"serializable": {
+			Opts:  &sql.TxOptions{Isolation: sql.LevelSerializable},
+			Query: "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE",
+		},
+		"read-only": {
+			Opts:  &sql.TxOptions{ReadOnly: true},
+			Query: "SET TRANSACTION READ ONLY",
+		},
+		"repeatable-read-only": {
+			Opts:  &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true},
+			Query: "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY",
+		},
+		"not-supported": {
+			Opts: &sql.TxOptions{Isolation: sql.LevelLinearizable},
+			Err:  true,
+		},
+	}
+
+	for tname, tc := range cases {
+		t.Run(tname, func(t *testing.T) {
+			query, err := txOptionsQuery(tc.Opts)
+			if tc.Err {
+				if err == nil {
+					t.Fatalf("want error, got %q", query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if query != tc.Query {
+				t.Fatalf("want %q, got %q", tc.Query, query)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package pgtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/husio/feedstream/pg"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+// Mock implements pg.Database interface, that verifies every call against
+// defined expectations.
+//
+// Expectation matches call if method is the same, query matches regular
+// expression and all arguments are equal or accepted by Argument matcher.
+// Before matching, all whitespaces in the query are collapsed into single
+// space. By default, expectations must be met in the order they were
+// defined.
+//
+// Transaction started by the mock is the mock itself, so calls made using
+// transaction are verified the same way as calls made using database.
+//
+//	m := pgtest.NewMock()
+//	m.ExpectBegin()
+//	m.ExpectExec(`INSERT INTO feeds`).WithArgs("/?feed=1", pgtest.AnyArg(), 1)
+//	m.ExpectGet(`SELECT feed_id`).WillReturnRows(pgtest.NewRows("feed_id").AddRow(3))
+//	m.ExpectCommit()
+//
+//	// run tested code
+//
+//	if err := m.ExpectationsWereMet(); err != nil {
+//		t.Fatal(err)
+//	}
+type Mock struct {
+	mu        sync.Mutex
+	expected  []*Expectation
+	unordered bool
+	inTx      bool
+}
+
+var _ pg.Database = (*Mock)(nil)
+
+// NewMock returns mock without any expectations.
+func NewMock() *Mock {
+	return &Mock{}
+}
+
+// MatchUnordered configures mock to accept expectations in any order.
+func (m *Mock) MatchUnordered() *Mock {
+	m.mu.Lock()
+	m.unordered = true
+	m.mu.Unlock()
+	return m
+}
+
+// Expectation describes single expected call and it's result.
+type Expectation struct {
+	method string
+	query  *regexp.Regexp
+	args   []interface{}
+	// args are checked only if they were set
+	withArgs bool
+
+	rows   *Rows
+	result sql.Result
+	err    error
+
+	triggered bool
+}
+
+// WithArgs sets arguments that call must be made with. Argument can be
+// either value or Argument matcher.
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	e.withArgs = true
+	return e
+}
+
+// WillReturnRows sets rows loaded into destination of Get or Select call.
+// Get with no rows returns sql.ErrNoRows.
+func (e *Expectation) WillReturnRows(rows *Rows) *Expectation {
+	e.rows = rows
+	return e
+}
+
+// WillReturnResult sets result returned by Exec call.
+func (e *Expectation) WillReturnResult(res sql.Result) *Expectation {
+	e.result = res
+	return e
+}
+
+// WillReturnError sets error returned by the call.
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+func (e *Expectation) String() string {
+	if e.query == nil {
+		return e.method
+	}
+	if e.withArgs {
+		return fmt.Sprintf("%s %q with %v", e.method, e.query, e.args)
+	}
+	return fmt.Sprintf("%s %q", e.method, e.query)
+}
+
+// Argument is used to match argument value with custom logic.
+type Argument interface {
+	Match(v driver.Value) bool
+}
+
+type anyArg struct{}
+
+func (anyArg) Match(driver.Value) bool { return true }
+
+func (anyArg) String() string { return "<any>" }
+
+// AnyArg returns argument matcher that accepts any value.
+func AnyArg() Argument {
+	return anyArg{}
+}
+
+// Rows is the result of the Get or Select call.
+type Rows struct {
+	columns []string
+	values  [][]interface{}
+}
+
+// NewRows returns empty result with given columns.
+func NewRows(columns ...string) *Rows {
+	return &Rows{columns: columns}
+}
+
+// AddRow adds row to the result. Values must be given in columns order.
+func (r *Rows) AddRow(values ...interface{}) *Rows {
+	if len(values) != len(r.columns) {
+		panic(fmt.Sprintf("want %d values, got %d", len(r.columns), len(values)))
+	}
+	r.values = append(r.values, values)
+	return r
+}
+
+func (m *Mock) expect(method, query string) *Expectation {
+	e := &Expectation{method: method}
+	if query != "" {
+		e.query = regexp.MustCompile(query)
+	}
+	m.mu.Lock()
+	m.expected = append(m.expected, e)
+	m.mu.Unlock()
+	return e
+}
+
+// ExpectBegin expects transaction to be started using Beginx or BeginTx.
+func (m *Mock) ExpectBegin() *Expectation { return m.expect("Begin", "") }
+
+// ExpectCommit expects transaction to be commited.
+func (m *Mock) ExpectCommit() *Expectation { return m.expect("Commit", "") }
+
+// ExpectRollback expects transaction to be rolled back.
+func (m *Mock) ExpectRollback() *Expectation { return m.expect("Rollback", "") }
+
+// ExpectGet expects Get or GetContext call with query matching given regular
+// expression.
+func (m *Mock) ExpectGet(query string) *Expectation { return m.expect("Get", query) }
+
+// ExpectSelect expects Select or SelectContext call with query matching given
+// regular expression.
+func (m *Mock) ExpectSelect(query string) *Expectation { return m.expect("Select", query) }
+
+// ExpectExec expects Exec or ExecContext call with query matching given
+// regular expression.
+func (m *Mock) ExpectExec(query string) *Expectation { return m.expect("Exec", query) }
+
+// ExpectationsWereMet returns error if any of the expectations was not
+// triggered.
+func (m *Mock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var missing []string
+	for _, e := range m.expected {
+		if !e.triggered {
+			missing = append(missing, e.String())
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("expectations not met:\n\t%s", strings.Join(missing, "\n\t"))
+	}
+	return nil
+}
+
+// match finds expectation for given call and mark it as triggered.
+func (m *Mock) match(method, query string, args []interface{}) (*Expectation, error) {
+	query = strings.Join(strings.Fields(query), " ")
+
+	for _, e := range m.expected {
+		if e.triggered {
+			continue
+		}
+		err := e.match(method, query, args)
+		if err == nil {
+			e.triggered = true
+			return e, nil
+		}
+		if !m.unordered {
+			return nil, fmt.Errorf("%s: %s %q %v: %s", ErrUnexpectedCall, method, query, args, err)
+		}
+	}
+	return nil, fmt.Errorf("%s: %s %q %v: no matching expectation", ErrUnexpectedCall, method, query, args)
+}
+
+func (e *Expectation) match(method, query string, args []interface{}) error {
+	if e.method != method {
+		return fmt.Errorf("want %s", e)
+	}
+	if e.query != nil && !e.query.MatchString(query) {
+		return fmt.Errorf("query does not match %q", e.query)
+	}
+	if !e.withArgs {
+		return nil
+	}
+	if len(e.args) != len(args) {
+		return fmt.Errorf("want %d arguments, got %d", len(e.args), len(args))
+	}
+	for i, want := range e.args {
+		got, err := driver.DefaultParameterConverter.ConvertValue(args[i])
+		if err != nil {
+			return fmt.Errorf("cannot convert argument %d: %s", i, err)
+		}
+		if m, ok := want.(Argument); ok {
+			if !m.Match(got) {
+				return fmt.Errorf("argument %d does not match: %v", i, got)
+			}
+			continue
+		}
+		want, err := driver.DefaultParameterConverter.ConvertValue(want)
+		if err != nil {
+			return fmt.Errorf("cannot convert expected argument %d: %s", i, err)
+		}
+		if !equalValues(want, got) {
+			return fmt.Errorf("argument %d: want %v, got %v", i, want, got)
+		}
+	}
+	return nil
+}
+
+func equalValues(a, b driver.Value) bool {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		return ok && at.Equal(bt)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (m *Mock) Beginx() (pg.Connection, error) {
+	return m.BeginTx(context.Background(), nil)
+}
+
+func (m *Mock) BeginTx(ctx context.Context, opts *sql.TxOptions) (pg.Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.match("Begin", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	m.inTx = true
+	return m, nil
+}
+
+func (m *Mock) Commit() error {
+	return m.finishTx("Commit")
+}
+
+func (m *Mock) Rollback() error {
+	return m.finishTx("Rollback")
+}
+
+func (m *Mock) finishTx(method string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the same as sql.Tx, allow to call rollback on already finished
+	// transaction, which is common when rollback is deferred
+	if !m.inTx {
+		return sql.ErrTxDone
+	}
+	e, err := m.match(method, "", nil)
+	if err != nil {
+		return err
+	}
+	m.inTx = false
+	return e.err
+}
+
+func (m *Mock) Get(dest interface{}, query string, args ...interface{}) error {
+	return m.GetContext(context.Background(), dest, query, args...)
+}
+
+func (m *Mock) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.match("Get", query, args)
+	if err != nil {
+		return err
+	}
+	if e.err != nil {
+		return e.err
+	}
+	if e.rows == nil || len(e.rows.values) == 0 {
+		return sql.ErrNoRows
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("destination must be a non nil pointer, got %T", dest)
+	}
+	return scanRow(v.Elem(), e.rows.columns, e.rows.values[0])
+}
+
+func (m *Mock) Select(dest interface{}, query string, args ...interface{}) error {
+	return m.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (m *Mock) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.match("Select", query, args)
+	if err != nil {
+		return err
+	}
+	if e.err != nil {
+		return e.err
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("destination must be a pointer to slice, got %T", dest)
+	}
+	slice := v.Elem()
+	if e.rows == nil {
+		return nil
+	}
+
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	for _, values := range e.rows.values {
+		elem := reflect.New(elemType)
+		if err := scanRow(elem.Elem(), e.rows.columns, values); err != nil {
+			return err
+		}
+		if isPtr {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+	return nil
+}
+
+func (m *Mock) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return m.ExecContext(context.Background(), query, args...)
+}
+
+func (m *Mock) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, err := m.match("Exec", query, args)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.result == nil {
+		return &ExecResultMock{}, nil
+	}
+	return e.result, nil
+}
+
+func (m *Mock) Close() error {
+	return nil
+}
+
+// mapper maps columns to struct fields the same way sqlx does.
+var mapper = reflectx.NewMapperFunc("db", strings.ToLower)
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// scanRow loads values into given addressable value. If value is a struct,
+// columns are mapped to fields, otherwise single column is expected.
+func scanRow(dest reflect.Value, columns []string, values []interface{}) error {
+	isScannable := dest.Kind() != reflect.Struct ||
+		reflect.PtrTo(dest.Type()).Implements(scannerType) ||
+		dest.Type() == reflect.TypeOf(time.Time{})
+	if isScannable {
+		if len(columns) != 1 {
+			return fmt.Errorf("scannable destination %s requires single column, got %d", dest.Type(), len(columns))
+		}
+		return assign(dest, values[0])
+	}
+
+	traversals := mapper.TraversalsByName(dest.Type(), columns)
+	for i, traversal := range traversals {
+		if len(traversal) == 0 {
+			return fmt.Errorf("missing destination name %q in %s", columns[i], dest.Type())
+		}
+		field := reflectx.FieldByIndexes(dest, traversal)
+		if err := assign(field, values[i]); err != nil {
+			return fmt.Errorf("cannot assign %q column: %s", columns[i], err)
+		}
+	}
+	return nil
+}
+
+func assign(dest reflect.Value, value interface{}) error {
+	if s, ok := dest.Addr().Interface().(sql.Scanner); ok {
+		return s.Scan(value)
+	}
+	if value == nil {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if !v.Type().ConvertibleTo(dest.Type()) {
+		return fmt.Errorf("cannot convert %T to %s", value, dest.Type())
+	}
+	dest.Set(v.Convert(dest.Type()))
+	return nil
+}
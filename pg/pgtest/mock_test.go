@@ -0,0 +1,93 @@
+package pgtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMockOrdered(t *testing.T) {
+	m := NewMock()
+	m.ExpectExec(`INSERT INTO a`).WithArgs(1, AnyArg())
+	m.ExpectExec(`INSERT INTO b`)
+
+	if _, err := m.Exec(`INSERT INTO b VALUES (1)`); err == nil {
+		t.Fatal("out of order call accepted")
+	}
+	if _, err := m.Exec(`INSERT INTO a VALUES ($1, $2)`, 2, "x"); err == nil {
+		t.Fatal("call with invalid argument accepted")
+	}
+	if _, err := m.Exec(`INSERT  INTO   a VALUES ($1, $2)`, int64(1), "x"); err != nil {
+		t.Fatalf("cannot exec: %s", err)
+	}
+	if err := m.ExpectationsWereMet(); err == nil {
+		t.Fatal("expectations met")
+	}
+	if _, err := m.Exec(`INSERT INTO b VALUES (1)`); err != nil {
+		t.Fatalf("cannot exec: %s", err)
+	}
+	if err := m.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMockUnordered(t *testing.T) {
+	m := NewMock().MatchUnordered()
+	m.ExpectExec(`INSERT INTO a`)
+	m.ExpectExec(`INSERT INTO b`)
+
+	if _, err := m.Exec(`INSERT INTO b VALUES (1)`); err != nil {
+		t.Fatalf("cannot exec: %s", err)
+	}
+	if _, err := m.Exec(`INSERT INTO a VALUES (1)`); err != nil {
+		t.Fatalf("cannot exec: %s", err)
+	}
+	if err := m.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMockRows(t *testing.T) {
+	now := time.Now()
+	m := NewMock()
+	m.ExpectGet(`SELECT`).WillReturnRows(NewRows("account_id", "name", "created").AddRow(1, "bob", now))
+	m.ExpectSelect(`SELECT`).WillReturnRows(NewRows("account_id", "name", "created").
+		AddRow(1, "bob", now).
+		AddRow(2, "alice", now))
+	m.ExpectGet(`SELECT`).WillReturnRows(NewRows("count").AddRow(42))
+	m.ExpectGet(`SELECT`).WillReturnError(errors.New("failure"))
+
+	type account struct {
+		AccountID int64 `db:"account_id"`
+		Name      string
+		Created   time.Time
+	}
+
+	var a account
+	if err := m.Get(&a, `SELECT * FROM accounts LIMIT 1`); err != nil {
+		t.Fatalf("cannot get: %s", err)
+	}
+	if a.AccountID != 1 || a.Name != "bob" || !a.Created.Equal(now) {
+		t.Fatalf("unexpected result: %+v", a)
+	}
+
+	var all []*account
+	if err := m.Select(&all, `SELECT * FROM accounts`); err != nil {
+		t.Fatalf("cannot select: %s", err)
+	}
+	if len(all) != 2 || all[1].Name != "alice" {
+		t.Fatalf("unexpected result: %+v", all)
+	}
+
+	var cnt int
+	if err := m.Get(&cnt, `SELECT COUNT(*) FROM accounts`); err != nil {
+		t.Fatalf("cannot get: %s", err)
+	}
+	if cnt != 42 {
+		t.Fatalf("want 42, got %d", cnt)
+	}
+
+	if err := m.Get(&cnt, `SELECT 1`); err == nil || err.Error() != "failure" {
+		t.Fatalf("want failure, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package pgtest
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"reflect"
@@ -20,6 +21,8 @@ type DB struct {
 	Fatalf func(string, ...interface{})
 }
 
+var _ pg.Database = (*DB)(nil)
+
 // ResultMock defines result of DB method call. It must define Method name
 // (Get, Select) that will be matched and result that DB call should return.
 type ResultMock struct {
@@ -52,6 +55,15 @@ func (db *DB) Beginx() (pg.Connection, error) {
 	return db, mock.Err
 }
 
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (pg.Connection, error) {
+	mock := db.pop("BeginTx", "", nil)
+	if want, got := "BeginTx", mock.Method; want != got {
+		db.Fatalf("want %q, got %q", want, got)
+		return nil, ErrUnexpectedCall
+	}
+	return db, mock.Err
+}
+
 func (db *DB) Commit() error {
 	mock := db.pop("Commit", "", nil)
 	if want, got := "Commit", mock.Method; want != got {
@@ -82,6 +94,10 @@ func (db *DB) Get(dest interface{}, query string, args ...interface{}) error {
 	return mock.Err
 }
 
+func (db *DB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.Get(dest, query, args...)
+}
+
 func (db *DB) Select(dest interface{}, query string, args ...interface{}) error {
 	mock := db.pop("Select", query, args)
 	if want, got := "Select", mock.Method; want != got {
@@ -94,6 +110,10 @@ func (db *DB) Select(dest interface{}, query string, args ...interface{}) error
 	return mock.Err
 }
 
+func (db *DB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.Select(dest, query, args...)
+}
+
 func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	mock := db.pop("Exec", query, args)
 	if want, got := "Exec", mock.Method; want != got {
@@ -114,6 +134,14 @@ func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	return res, mock.Err
 }
 
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.Exec(query, args...)
+}
+
+func (db *DB) Close() error {
+	return nil
+}
+
 func (db *DB) pop(method, query string, args []interface{}) *ResultMock {
 	if len(db.Stack) == 0 {
 		db.Fatalf("mock call stack empty: %q %s %+v", method, query, args)
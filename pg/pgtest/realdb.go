@@ -1,10 +1,12 @@
 package pgtest
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,7 +14,7 @@ import (
 )
 
 // CreateDB connect to PostgreSQL instance, create database and return
-// connection to it.
+// connection to it. Database is dropped when the test finishes.
 //
 // Unless option is provided, defaults are used:
 //   * Database name: test_database_<creation time in unix ns>
@@ -22,37 +24,19 @@ import (
 //   * User: postgres
 //
 // Function connects to 'postgres' database first to create new database.
-func CreateDB(t *testing.T, o *DBOpts) *sql.DB {
+func CreateDB(t testing.TB, o *DBOpts) *sql.DB {
 	if o == nil {
 		o = &DBOpts{}
 	}
 	assignDefaultOpts(o)
 
-	connstr := fmt.Sprintf(
-		"host='%s' port='%d' user='%s' dbname='postgres' sslmode='%s'",
-		o.Host, o.Port, o.User, o.SSLMode)
-	db, err := sql.Open("postgres", connstr)
-	if err != nil {
-		t.Skipf("cannot connect to postgres: %s", err)
-	}
-	defer db.Close()
+	admin := connectAdmin(t, o)
+	defer admin.Close()
 
-	if err := db.Ping(); err != nil {
-		t.Skipf("cannot ping postgres: %s", err)
-	}
-
-	if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE %s", o.DBName)); err != nil {
+	if _, err := admin.Exec(fmt.Sprintf("CREATE DATABASE %s", o.DBName)); err != nil {
 		t.Fatalf("cannot create database: %s", err)
-		db.Close()
-	}
-
-	connstr = fmt.Sprintf(
-		"host='%s' port='%d' user='%s' dbname='%s' sslmode='%s'",
-		o.Host, o.Port, o.User, o.DBName, o.SSLMode)
-	db, err = sql.Open("postgres", connstr)
-	if err != nil {
-		t.Fatalf("cannot connect to created database: %s", err)
 	}
+	db := connectCreated(t, o)
 
 	t.Logf("test database created: %s", o.DBName)
 	return db
@@ -85,48 +69,185 @@ func assignDefaultOpts(o *DBOpts) {
 	}
 }
 
-// CloneDB creates clone of given database.
+func connString(o *DBOpts, dbname string) string {
+	return fmt.Sprintf(
+		"host='%s' port='%d' user='%s' dbname='%s' sslmode='%s'",
+		o.Host, o.Port, o.User, dbname, o.SSLMode)
+}
+
+// connectAdmin returns connection to 'postgres' database, that can be used to
+// create and drop databases. Test is skipped if PostgreSQL is not available.
+func connectAdmin(t testing.TB, o *DBOpts) *sql.DB {
+	db, err := sql.Open("postgres", connString(o, "postgres"))
+	if err != nil {
+		t.Skipf("cannot connect to postgres: %s", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Skipf("cannot ping postgres: %s", err)
+	}
+	return db
+}
+
+// connectCreated returns connection to just created o.DBName database and
+// register cleanup function that close it and drop the database.
+func connectCreated(t testing.TB, o *DBOpts) *sql.DB {
+	// cleanup is registered before connecting, so that database is
+	// dropped even if connection fails
+	name := o.DBName
+	t.Cleanup(func() { dropDB(t, o, name) })
+
+	db, err := sql.Open("postgres", connString(o, name))
+	if err != nil {
+		t.Fatalf("cannot connect to created database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatalf("cannot ping created database: %s", err)
+	}
+	return db
+}
+
+func dropDB(t testing.TB, o *DBOpts, name string) {
+	admin, err := sql.Open("postgres", connString(o, "postgres"))
+	if err != nil {
+		t.Errorf("cannot connect to postgres: %s", err)
+		return
+	}
+	defer admin.Close()
+
+	if _, err := admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", name)); err != nil {
+		t.Errorf("cannot drop %q database: %s", name, err)
+	}
+}
+
+// CloneDB creates clone of given database. Created database is dropped when
+// the test finishes.
 //
 // While this may speedup tests that require bootstraping with a lot of
 // fixtures, be aware that content layout on the hard drive may differ from
 // origin and default ordering may differ from original database.
-func CloneDB(t *testing.T, from string, o *DBOpts) *sql.DB {
+func CloneDB(t testing.TB, from string, o *DBOpts) *sql.DB {
 	if o == nil {
 		o = &DBOpts{}
 	}
 	assignDefaultOpts(o)
 
-	connstr := fmt.Sprintf(
-		"host='%s' port='%d' user='%s' dbname='%s' sslmode='%s'",
-		o.Host, o.Port, o.User, from, o.SSLMode)
-	db, err := sql.Open("postgres", connstr)
-	if err != nil {
-		t.Skipf("cannot connect to postgres: %s", err)
-	}
-	defer db.Close()
+	admin := connectAdmin(t, o)
+	defer admin.Close()
 
 	query := fmt.Sprintf("CREATE DATABASE %s WITH TEMPLATE %s", o.DBName, from)
-	if _, err := db.Exec(query); err != nil {
+	if _, err := admin.Exec(query); err != nil {
 		t.Fatalf("cannot clone %q database: %s", from, err)
 	}
+	db := connectCreated(t, o)
+
+	t.Logf("test database cloned: %s (from %s)", o.DBName, from)
+	return db
+}
+
+// MigratedDB returns connection to a new database with all registered
+// migrations applied. Database is dropped when the test finishes.
+//
+// Migrations are applied only once, to the template database that is then
+// cloned for every test. Template database name contains checksum of
+// registered migrations, so that it is reused by following test runs until
+// any migration changes.
+func MigratedDB(t testing.TB, o *DBOpts) pg.Database {
+	if o == nil {
+		o = &DBOpts{}
+	}
+	assignDefaultOpts(o)
+
+	tmpl := ensureTemplate(t, o)
+	return pg.Use(CloneDB(t, tmpl, o))
+}
+
+var templates struct {
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+// templateLockID is used to acquire PostgreSQL advisory lock while creating
+// template database, because tests of many packages can run in parallel.
+const templateLockID = 5736102
+
+// ensureTemplate creates template database with all registered migrations
+// applied, unless it already exists, and returns its name.
+func ensureTemplate(t testing.TB, o *DBOpts) string {
+	name := "test_template_" + pg.MigrationsChecksum()[:16]
+
+	templates.mu.Lock()
+	defer templates.mu.Unlock()
+
+	if templates.created[name] {
+		return name
+	}
 
-	cdb, err := sql.Open("postgres", connstr)
+	admin := connectAdmin(t, o)
+	defer admin.Close()
+
+	ctx := context.Background()
+	conn, err := admin.Conn(ctx)
 	if err != nil {
-		t.Fatalf("cannot connect to created database: %s", err)
+		t.Fatalf("cannot acquire connection: %s", err)
 	}
+	defer conn.Close()
 
-	if err := cdb.Ping(); err != nil {
-		t.Fatalf("cannot ping cloned database: %s", err)
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, templateLockID); err != nil {
+		t.Fatalf("cannot acquire template lock: %s", err)
 	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, templateLockID)
 
-	t.Logf("test database cloned: %s (from %s)", o.DBName, from)
-	return cdb
+	var exists bool
+	err = conn.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)
+	`, name).Scan(&exists)
+	if err != nil {
+		t.Fatalf("cannot check template database: %s", err)
+	}
+
+	if !exists {
+		// template is migrated using temporary name, so that failed
+		// migration does not leave broken template behind
+		tmpName := name + "_tmp"
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s", tmpName)); err != nil {
+			t.Fatalf("cannot drop %q database: %s", tmpName, err)
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s", tmpName)); err != nil {
+			t.Fatalf("cannot create template database: %s", err)
+		}
+		if err := migrateDB(ctx, connString(o, tmpName)); err != nil {
+			conn.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s", tmpName))
+			t.Fatalf("cannot migrate template database: %s", err)
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", tmpName, name)); err != nil {
+			t.Fatalf("cannot rename template database: %s", err)
+		}
+		t.Logf("test template database created: %s", name)
+	}
+
+	if templates.created == nil {
+		templates.created = make(map[string]bool)
+	}
+	templates.created[name] = true
+	return name
+}
+
+func migrateDB(ctx context.Context, connstr string) error {
+	db, err := pg.Connect(connstr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return pg.Migrate(ctx, db)
 }
 
 // LoadSQL execute all SQL statements the same way as LoadSQLString function
 // does, but instead of using input string, it loads statements from fixture
 // file.
-func LoadSQL(t *testing.T, e pg.Execer, fixture string) {
+func LoadSQL(t testing.TB, e pg.Execer, fixture string) {
 	query, err := ioutil.ReadFile(fixture)
 	if err != nil {
 		t.Fatalf("cannot read %q fixture: %s", fixture, err)
@@ -137,7 +258,7 @@ func LoadSQL(t *testing.T, e pg.Execer, fixture string) {
 
 // LoadSQLString execute all SQL statements from given string. SQL statements
 // must be separated by SQLSeparator.
-func LoadSQLString(t *testing.T, e pg.Execer, fixture string) {
+func LoadSQLString(t testing.TB, e pg.Execer, fixture string) {
 	for _, query := range strings.Split(fixture, SQLSeparator) {
 		query = strings.TrimSpace(query)
 		if len(query) == 0 {
@@ -0,0 +1,260 @@
+package pgtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/husio/feedstream/pg"
+)
+
+// TxDB returns database that executes all operations within single
+// transaction, that is rolled back when the test finishes. All tests using
+// TxDB share the same, migrated database, which makes them much faster than
+// tests using MigratedDB. Shared database is dropped by Main.
+//
+// Transactions started using returned database are emulated with
+// savepoints, so transaction options are ignored. Every statement executed
+// outside of such transaction is protected with savepoint as well, so that
+// failed statement does not abort the whole test transaction.
+//
+// Tests running in parallel do not see each other changes, but they may
+// block each other when modifying the same rows.
+func TxDB(t testing.TB, o *DBOpts) pg.Database {
+	db := sharedDB(t, o)
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("cannot start transaction: %s", err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return &txDB{tx: tx}
+}
+
+var shared struct {
+	mu   sync.Mutex
+	db   pg.Database
+	opts DBOpts
+}
+
+func sharedDB(t testing.TB, o *DBOpts) pg.Database {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if shared.db != nil {
+		return shared.db
+	}
+
+	if o == nil {
+		o = &DBOpts{}
+	}
+	assignDefaultOpts(o)
+
+	tmpl := ensureTemplate(t, o)
+
+	admin := connectAdmin(t, o)
+	defer admin.Close()
+
+	query := fmt.Sprintf("CREATE DATABASE %s WITH TEMPLATE %s", o.DBName, tmpl)
+	if _, err := admin.Exec(query); err != nil {
+		t.Fatalf("cannot clone %q database: %s", tmpl, err)
+	}
+	db, err := pg.Connect(connString(o, o.DBName))
+	if err != nil {
+		t.Fatalf("cannot connect to shared database: %s", err)
+	}
+
+	shared.db = db
+	shared.opts = *o
+	return db
+}
+
+// Main runs the tests and drops database shared by TxDB. It should be called
+// by TestMain of any package that is using TxDB:
+//
+//	func TestMain(m *testing.M) {
+//	        os.Exit(pgtest.Main(m))
+//	}
+func Main(m *testing.M) int {
+	code := m.Run()
+
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if shared.db == nil {
+		return code
+	}
+	shared.db.Close()
+	shared.db = nil
+
+	admin, err := sql.Open("postgres", connString(&shared.opts, "postgres"))
+	if err != nil {
+		fmt.Printf("cannot connect to postgres: %s\n", err)
+		return code
+	}
+	defer admin.Close()
+	if _, err := admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", shared.opts.DBName)); err != nil {
+		fmt.Printf("cannot drop %q database: %s\n", shared.opts.DBName, err)
+	}
+	return code
+}
+
+// txDB implements pg.Database using single transaction.
+type txDB struct {
+	// transaction is bound to single connection and must not be used
+	// concurrently
+	mu        sync.Mutex
+	tx        pg.Connection
+	savepoint int
+}
+
+var _ pg.Database = (*txDB)(nil)
+
+// protect runs given function within savepoint, that is released on success
+// and rolled back on failure.
+func (db *txDB) protect(ctx context.Context, fn func() error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	name := db.nextSavepoint()
+	if _, err := db.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		db.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		return err
+	}
+	_, err := db.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+func (db *txDB) nextSavepoint() string {
+	db.savepoint++
+	return fmt.Sprintf("pgtest_%d", db.savepoint)
+}
+
+func (db *txDB) Beginx() (pg.Connection, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db *txDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (pg.Connection, error) {
+	db.mu.Lock()
+	name := db.nextSavepoint()
+	_, err := db.tx.ExecContext(ctx, "SAVEPOINT "+name)
+	db.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &txSavepoint{db: db, name: name}, nil
+}
+
+func (db *txDB) Get(dest interface{}, query string, args ...interface{}) error {
+	return db.GetContext(context.Background(), dest, query, args...)
+}
+
+func (db *txDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.protect(ctx, func() error {
+		return db.tx.GetContext(ctx, dest, query, args...)
+	})
+}
+
+func (db *txDB) Select(dest interface{}, query string, args ...interface{}) error {
+	return db.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (db *txDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.protect(ctx, func() error {
+		return db.tx.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+func (db *txDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *txDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := db.protect(ctx, func() (err error) {
+		res, err = db.tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+// Close does nothing. Transaction is rolled back when the test finishes.
+func (db *txDB) Close() error {
+	return nil
+}
+
+// txSavepoint emulates transaction started by txDB.
+type txSavepoint struct {
+	db   *txDB
+	name string
+	done bool
+}
+
+var _ pg.Connection = (*txSavepoint)(nil)
+
+func (s *txSavepoint) Get(dest interface{}, query string, args ...interface{}) error {
+	return s.GetContext(context.Background(), dest, query, args...)
+}
+
+func (s *txSavepoint) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.done {
+		return sql.ErrTxDone
+	}
+	return s.db.tx.GetContext(ctx, dest, query, args...)
+}
+
+func (s *txSavepoint) Select(dest interface{}, query string, args ...interface{}) error {
+	return s.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (s *txSavepoint) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.done {
+		return sql.ErrTxDone
+	}
+	return s.db.tx.SelectContext(ctx, dest, query, args...)
+}
+
+func (s *txSavepoint) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.ExecContext(context.Background(), query, args...)
+}
+
+func (s *txSavepoint) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.done {
+		return nil, sql.ErrTxDone
+	}
+	return s.db.tx.ExecContext(ctx, query, args...)
+}
+
+func (s *txSavepoint) Commit() error {
+	return s.finish("RELEASE SAVEPOINT ")
+}
+
+func (s *txSavepoint) Rollback() error {
+	return s.finish("ROLLBACK TO SAVEPOINT ")
+}
+
+func (s *txSavepoint) finish(query string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.done {
+		return sql.ErrTxDone
+	}
+	// failed release leaves savepoint open, so that it can still be
+	// rolled back
+	if _, err := s.db.tx.Exec(query + s.name); err != nil {
+		return err
+	}
+	s.done = true
+	return nil
+}
@@ -0,0 +1,114 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// TxMaxAttempts is the maximum number of times WithTx runs transaction
+// function before giving up.
+var TxMaxAttempts = 5
+
+// WithTx runs given function within transaction created with given options.
+// Transaction is commited if function returns no error, otherwise it is
+// rolled back.
+//
+// If transaction fails because of serialization failure or deadlock, it is
+// retried with backoff. Function must be safe to call multiple times and
+// must not have side effects other than database changes made using given
+// connection.
+func WithTx(ctx context.Context, db Database, opts *sql.TxOptions, fn func(Connection) error) error {
+	var err error
+	for attempt := 0; attempt < TxMaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryDelay(attempt)):
+			}
+		}
+
+		var retry bool
+		retry, err = runTx(ctx, db, opts, fn)
+		if !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("transaction failed after %d attempts: %s", TxMaxAttempts, err)
+}
+
+// runTx runs single transaction attempt. It returns true if transaction
+// failed and can be retried.
+func runTx(ctx context.Context, db Database, opts *sql.TxOptions, fn func(Connection) error) (bool, error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+	c := &retryConn{Connection: tx}
+	defer c.Rollback()
+
+	if err := fn(c); err != nil {
+		return c.retriable, err
+	}
+	if err := c.Commit(); err != nil {
+		return c.retriable, err
+	}
+	return false, nil
+}
+
+func retryDelay(attempt int) time.Duration {
+	base := 10 * time.Millisecond << uint(attempt)
+	return base + time.Duration(rand.Int63n(int64(base)))
+}
+
+func isRetriable(err error) bool {
+	return err == ErrSerialization || err == ErrDeadlock
+}
+
+// retryConn remembers if any of the operations failed because of an error
+// that allows to retry the whole transaction. Transaction function may wrap
+// returned errors, so the result of the function cannot be used for this.
+type retryConn struct {
+	Connection
+	retriable bool
+}
+
+func (c *retryConn) check(err error) error {
+	if isRetriable(err) {
+		c.retriable = true
+	}
+	return err
+}
+
+func (c *retryConn) Get(dest interface{}, query string, args ...interface{}) error {
+	return c.check(c.Connection.Get(dest, query, args...))
+}
+
+func (c *retryConn) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return c.check(c.Connection.GetContext(ctx, dest, query, args...))
+}
+
+func (c *retryConn) Select(dest interface{}, query string, args ...interface{}) error {
+	return c.check(c.Connection.Select(dest, query, args...))
+}
+
+func (c *retryConn) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return c.check(c.Connection.SelectContext(ctx, dest, query, args...))
+}
+
+func (c *retryConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	res, err := c.Connection.Exec(query, args...)
+	return res, c.check(err)
+}
+
+func (c *retryConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	res, err := c.Connection.ExecContext(ctx, query, args...)
+	return res, c.check(err)
+}
+
+func (c *retryConn) Commit() error {
+	return c.check(c.Connection.Commit())
+}
@@ -0,0 +1,108 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRetryConnRemembersRetriableErrors(t *testing.T) {
+	c := &retryConn{}
+
+	c.check(errors.New("cannot insert: boom"))
+	if c.retriable {
+		t.Fatal("generic error marked as retriable")
+	}
+	c.check(ErrConflict)
+	if c.retriable {
+		t.Fatal("conflict marked as retriable")
+	}
+
+	c.check(ErrSerialization)
+	if !c.retriable {
+		t.Fatal("serialization failure not marked as retriable")
+	}
+
+	// following errors must not reset the state, because once
+	// transaction fails, all other statements fail as well
+	c.check(errors.New("current transaction is aborted"))
+	if !c.retriable {
+		t.Fatal("retriable state lost")
+	}
+}
+
+func TestWithTxRetriesSerializationFailures(t *testing.T) {
+	db := &fakeTxDB{failures: 2}
+	var calls int
+	err := WithTx(context.Background(), db, nil, func(c Connection) error {
+		calls++
+		if _, err := c.ExecContext(context.Background(), "UPDATE x"); err != nil {
+			return fmt.Errorf("cannot update: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %s", err)
+	}
+	if calls != 3 {
+		t.Fatalf("want 3 calls, got %d", calls)
+	}
+	if db.commits != 1 {
+		t.Fatalf("want 1 commit, got %d", db.commits)
+	}
+}
+
+func TestWithTxNotRetryingOtherErrors(t *testing.T) {
+	db := &fakeTxDB{}
+	var calls int
+	wantErr := errors.New("boom")
+	err := WithTx(context.Background(), db, nil, func(c Connection) error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("want %v, got %v", wantErr, err)
+	}
+	if calls != 1 {
+		t.Fatalf("want 1 call, got %d", calls)
+	}
+	if db.commits != 0 {
+		t.Fatalf("want no commits, got %d", db.commits)
+	}
+}
+
+// fakeTxDB returns connections, which Exec call fails with serialization
+// error until configured number of failures is reached.
+type fakeTxDB struct {
+	Database
+	failures int
+	commits  int
+}
+
+func (db *fakeTxDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (Connection, error) {
+	return &fakeTxConn{db: db}, nil
+}
+
+type fakeTxConn struct {
+	Connection
+	db *fakeTxDB
+}
+
+func (c *fakeTxConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if c.db.failures > 0 {
+		c.db.failures--
+		return nil, ErrSerialization
+	}
+	return nil, nil
+}
+
+func (c *fakeTxConn) Commit() error {
+	c.db.commits++
+	return nil
+}
+
+func (c *fakeTxConn) Rollback() error {
+	return nil
+}
@@ -0,0 +1,264 @@
+// Package sanitize cleans up untrusted HTML, so that it can be embedded
+// into application pages.
+//
+// Only elements and attributes from a strict allowlist are kept. Scripts,
+// styles and other active content are removed together with their content,
+// while any other element is replaced by its content. Links are forced to
+// open without access to the application window, relative URLs are
+// resolved and tracking pixels are removed.
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Option changes the default sanitization.
+type Option func(*policy)
+
+type policy struct {
+	base     *url.URL
+	imageURL func(string) string
+}
+
+// WithImageURL rewrites source of every image using given function. It
+// can be used to serve images through a proxy. Function is called with
+// absolute URL.
+func WithImageURL(fn func(string) string) Option {
+	return func(p *policy) {
+		p.imageURL = fn
+	}
+}
+
+// elements are allowed elements, with their allowed attributes.
+var elements = map[string][]string{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": nil,
+	"br":         nil,
+	"caption":    nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title"},
+	"ins":        nil,
+	"li":         nil,
+	"ol":         nil,
+	"p":          nil,
+	"pre":        nil,
+	"q":          nil,
+	"s":          nil,
+	"small":      nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"colspan", "rowspan"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+// voidElements cannot have any content.
+var voidElements = map[string]bool{
+	"br":  true,
+	"hr":  true,
+	"img": true,
+}
+
+// droppedElements are removed together with their content.
+var droppedElements = map[string]bool{
+	"applet":   true,
+	"audio":    true,
+	"embed":    true,
+	"frame":    true,
+	"frameset": true,
+	"head":     true,
+	"iframe":   true,
+	"noembed":  true,
+	"noframes": true,
+	"noscript": true,
+	"object":   true,
+	"script":   true,
+	"select":   true,
+	"style":    true,
+	"template": true,
+	"textarea": true,
+	"title":    true,
+	"video":    true,
+}
+
+// trackers are URL prefixes of known tracking images, without scheme.
+var trackers = []string{
+	"feeds.feedburner.com/~r/",
+	"pixel.wp.com/",
+	"stats.wordpress.com/",
+	"pixel.quantserve.com/",
+	"www.google-analytics.com/",
+}
+
+// HTML returns sanitized HTML fragment. Relative URLs are resolved against
+// given base URL, which is usually the URL of the page the content comes
+// from. If base URL is not valid, elements with relative URLs are removed.
+func HTML(content, baseURL string, opts ...Option) string {
+	p := policy{imageURL: func(s string) string { return s }}
+	if u, err := url.Parse(baseURL); err == nil && u.IsAbs() {
+		p.base = u
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(content), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return html.EscapeString(content)
+	}
+	var b bytes.Buffer
+	for _, n := range nodes {
+		p.write(&b, n)
+	}
+	return b.String()
+}
+
+func (p *policy) write(b *bytes.Buffer, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+		// handled below
+	default:
+		return
+	}
+	if n.Namespace != "" || droppedElements[n.Data] {
+		return
+	}
+
+	allowed, ok := elements[n.Data]
+	if ok {
+		if n.Data == "img" && p.isTracker(n) {
+			return
+		}
+		b.WriteString("<" + n.Data)
+		for _, attr := range n.Attr {
+			if attr.Namespace != "" || !contains(allowed, attr.Key) {
+				continue
+			}
+			val := attr.Val
+			switch attr.Key {
+			case "href", "src":
+				u, ok := p.resolve(val)
+				if !ok {
+					continue
+				}
+				val = u
+				if attr.Key == "src" {
+					val = p.imageURL(val)
+				}
+			case "colspan", "rowspan":
+				if _, err := strconv.ParseUint(val, 10, 16); err != nil {
+					continue
+				}
+			}
+			b.WriteString(" " + attr.Key + `="` + html.EscapeString(val) + `"`)
+		}
+		if n.Data == "a" {
+			b.WriteString(` rel="noopener noreferrer"`)
+		}
+		b.WriteString(">")
+		if voidElements[n.Data] {
+			return
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		p.write(b, c)
+	}
+	if ok {
+		b.WriteString("</" + n.Data + ">")
+	}
+}
+
+// resolve returns absolute URL, if given one is a valid web URL.
+func (p *policy) resolve(rawurl string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil {
+		return "", false
+	}
+	if !u.IsAbs() {
+		if p.base == nil {
+			return "", false
+		}
+		u = p.base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// isTracker returns true if given image is not meant to be seen, but to
+// notify the publisher that the content was displayed.
+func (p *policy) isTracker(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "width", "height":
+			val := strings.TrimSuffix(strings.TrimSpace(attr.Val), "px")
+			if size, err := strconv.Atoi(val); err == nil && size <= 1 {
+				return true
+			}
+		case "style":
+			style := strings.ToLower(strings.Replace(attr.Val, " ", "", -1))
+			if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+				return true
+			}
+		case "src":
+			u, ok := p.resolve(attr.Val)
+			if !ok {
+				continue
+			}
+			u = u[strings.Index(u, "//")+2:]
+			for _, prefix := range trackers {
+				if strings.HasPrefix(u, prefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, el := range list {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func TestHTML(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		base    string
+		opts    []Option
+		want    string
+	}{
+		"plain text": {
+			content: `a < b & c`,
+			want:    `a &lt; b &amp; c`,
+		},
+		"allowed elements": {
+			content: `<p>Hello <strong>world</strong><br></p>`,
+			want:    `<p>Hello <strong>world</strong><br></p>`,
+		},
+		"scripts and styles": {
+			content: `<p>a<script>alert(1)</script><style>p{}</style>b</p>`,
+			want:    `<p>ab</p>`,
+		},
+		"unknown elements are unwrapped": {
+			content: `<div class="x"><span style="color:red">text</span></div>`,
+			want:    `text`,
+		},
+		"foreign content": {
+			content: `<svg><script>alert(1)</script><text>a</text></svg>b`,
+			want:    `b`,
+		},
+		"attributes": {
+			content: `<p onclick="alert(1)" class="x">a</p><td colspan="2" rowspan="x">b</td>`,
+			want:    `<p>a</p>b`,
+		},
+		"links": {
+			content: `<a href="../other" target="_blank" rel="opener">a</a><a href="javascript:alert(1)">b</a>`,
+			base:    "http://example.com/blog/post",
+			want:    `<a href="http://example.com/other" rel="noopener noreferrer">a</a><a rel="noopener noreferrer">b</a>`,
+		},
+		"relative links without base": {
+			content: `<a href="/other">a</a><a href="https://example.com/">b</a>`,
+			want:    `<a rel="noopener noreferrer">a</a><a href="https://example.com/" rel="noopener noreferrer">b</a>`,
+		},
+		"images": {
+			content: `<img src="/img.png" alt="x" width="600">`,
+			base:    "http://example.com/blog/post",
+			opts:    []Option{WithImageURL(func(u string) string { return "/proxy?" + u })},
+			want:    `<img src="/proxy?http://example.com/img.png" alt="x">`,
+		},
+		"tracking pixels": {
+			content: `<p>a<img src="/t.gif" width="1" height="1"><img src="/t.gif" style="display: none">` +
+				`<img src="http://feeds.feedburner.com/~r/blog/~4/x"><img src="https://pixel.wp.com/b.gif?x=1"></p>`,
+			base: "http://example.com/",
+			want: `<p>a</p>`,
+		},
+		"escaped attribute": {
+			content: `<img src="x.png" alt="&quot;><script>">`,
+			base:    "http://example.com/blog/post",
+			want:    `<img src="http://example.com/blog/x.png" alt="&#34;&gt;&lt;script&gt;">`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := HTML(tc.content, tc.base, tc.opts...); got != tc.want {
+				t.Fatalf("want\n%s\ngot\n%s", tc.want, got)
+			}
+		})
+	}
+}
+
+func FuzzHTML(f *testing.F) {
+	for _, seed := range []string{
+		`<p>Hello <a href="/x" onclick="y()">world</a></p>`,
+		`<script>alert(1)</script><style>*{}</style>`,
+		`<img src="javascript:alert(1)"><img src=x width=1>`,
+		`<table><tr><td colspan=2>a<td>b</table>`,
+		`<svg><foreignObject><p>a</p></foreignObject></svg>`,
+		`<math><mi><style><img src=x onerror=alert(1)></style></mi></math>`,
+		`<noscript><p title="</noscript><img src=x onerror=alert(1)>">`,
+		`<a href="  JaVaScRiPt:alert(1)">x</a><a href="data:text/html,x">y</a>`,
+		`<!-- <script> --><p>x</p><![CDATA[<script>]]>`,
+		`<textarea><script></textarea><xmp><script></xmp><plaintext><b>`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, content string) {
+		out := HTML(content, "http://example.com/a/b")
+
+		// sanitized output is parsed again, as the browser would do,
+		// and must contain only allowed elements and attributes
+		nodes, err := html.ParseFragment(strings.NewReader(out), &html.Node{
+			Type:     html.ElementNode,
+			Data:     "div",
+			DataAtom: atom.Div,
+		})
+		if err != nil {
+			t.Fatalf("cannot parse output: %s", err)
+		}
+		var check func(*html.Node)
+		check = func(n *html.Node) {
+			switch n.Type {
+			case html.CommentNode:
+				t.Fatalf("comment in output %q", out)
+			case html.ElementNode:
+				allowed, ok := elements[n.Data]
+				if !ok || n.Namespace != "" {
+					t.Fatalf("%q element in output %q", n.Data, out)
+				}
+				for _, attr := range n.Attr {
+					if attr.Key == "rel" && n.Data == "a" {
+						continue
+					}
+					if !contains(allowed, attr.Key) {
+						t.Fatalf("%q attribute of %q in output %q", attr.Key, n.Data, out)
+					}
+					if attr.Key == "href" || attr.Key == "src" {
+						if !strings.HasPrefix(attr.Val, "http://") && !strings.HasPrefix(attr.Val, "https://") {
+							t.Fatalf("%q URL in output %q", attr.Val, out)
+						}
+					}
+				}
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				check(c)
+			}
+		}
+		for _, n := range nodes {
+			check(n)
+		}
+	})
+}
@@ -12,6 +12,7 @@
   if (canonical && canonical.href) {
     url = canonical.href
   }
-  bookmark({title: document.title, url: url})
+  var selection = window.getSelection ? String(window.getSelection()) : ""
+  bookmark({title: document.title, url: url, selection: selection})
 
 }())
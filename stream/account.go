@@ -0,0 +1,224 @@
+package stream
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/blob"
+	"github.com/husio/feedstream/csrf"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/ui"
+)
+
+// ExportAccountHandler returns zip archive with all data of the current
+// account. Snapshots of bookmarked pages are included as separate files.
+func ExportAccountHandler(
+	manager Manager,
+	store blob.Store,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		export, err := exportAccount(r.Context(), manager, authSrv, user)
+		if err != nil {
+			log.Printf("cannot export %d account: %s", user.AccountID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="feedstream-%s.zip"`, export.Exported.Format("2006-01-02")))
+
+		arch := zip.NewWriter(w)
+		if f, err := arch.Create("feedstream.json"); err != nil {
+			log.Printf("cannot create archive file: %s", err)
+			return
+		} else {
+			b, err := json.MarshalIndent(export, "", "\t")
+			if err != nil {
+				log.Printf("cannot serialize export: %s", err)
+				return
+			}
+			if _, err := f.Write(b); err != nil {
+				log.Printf("cannot write export: %s", err)
+				return
+			}
+		}
+		if f, err := arch.Create("subscriptions.opml"); err != nil {
+			log.Printf("cannot create archive file: %s", err)
+			return
+		} else if err := writeOPML(f, "feedstream subscriptions", export.Subscriptions); err != nil {
+			log.Printf("cannot write opml: %s", err)
+			return
+		}
+		for _, a := range export.Archives {
+			if a.BlobKey == "" {
+				continue
+			}
+			if err := exportArchive(r.Context(), arch, store, a); err != nil {
+				log.Printf("cannot export %d archive: %s", a.EntryID, err)
+				return
+			}
+		}
+		if err := arch.Close(); err != nil {
+			log.Printf("cannot write archive: %s", err)
+		}
+	}
+}
+
+type accountExport struct {
+	Exported      time.Time
+	Account       *auth.User
+	Identities    []*auth.Identity
+	Subscriptions []*Subscription
+	Bookmarks     []*Entry
+	EntryStates   []*EntryState
+	Shares        []*Share
+	Archives      []*Archive
+}
+
+func exportAccount(
+	ctx context.Context,
+	manager Manager,
+	authSrv auth.AuthService,
+	user *auth.User,
+) (*accountExport, error) {
+	export := accountExport{
+		Exported: time.Now(),
+		Account:  user,
+	}
+	var err error
+	if export.Identities, err = authSrv.Identities(ctx, user.AccountID); err != nil {
+		return nil, fmt.Errorf("cannot list identities: %s", err)
+	}
+	if export.Subscriptions, err = manager.Subscriptions(ctx, user.AccountID); err != nil {
+		return nil, fmt.Errorf("cannot list subscriptions: %s", err)
+	}
+	if export.Bookmarks, err = manager.Bookmarks(ctx, user.AccountID); err != nil {
+		return nil, fmt.Errorf("cannot list bookmarks: %s", err)
+	}
+	if export.EntryStates, err = manager.EntryStates(ctx, user.AccountID); err != nil {
+		return nil, fmt.Errorf("cannot list entry states: %s", err)
+	}
+	if export.Shares, err = manager.Shares(ctx, user.AccountID); err != nil {
+		return nil, fmt.Errorf("cannot list shares: %s", err)
+	}
+	if export.Archives, err = manager.Archives(ctx, user.AccountID); err != nil {
+		return nil, fmt.Errorf("cannot list archives: %s", err)
+	}
+	return &export, nil
+}
+
+// exportArchive copies snapshot content into the zip archive, using blob
+// key as the file name.
+func exportArchive(ctx context.Context, w *zip.Writer, store blob.Store, a *Archive) error {
+	content, err := store.Get(ctx, a.BlobKey)
+	switch err {
+	case nil:
+		// all good
+	case blob.ErrNotFound:
+		return nil
+	default:
+		return fmt.Errorf("cannot read content: %s", err)
+	}
+	defer content.Close()
+
+	f, err := w.Create(a.BlobKey)
+	if err != nil {
+		return fmt.Errorf("cannot create archive file: %s", err)
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		return fmt.Errorf("cannot copy content: %s", err)
+	}
+	return nil
+}
+
+// DeleteAccountHandler removes current account together with all its data,
+// including archived snapshots. Deletion cannot be undone, so only requests
+// sent from the site pages are accepted.
+func DeleteAccountHandler(
+	manager Manager,
+	store blob.Store,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+	site string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		if !csrf.SameOrigin(r, site) {
+			log.Printf("cross origin %d account deletion rejected: %q", user.AccountID, r.Header.Get("Origin"))
+			tmpl.RenderStd(w, http.StatusForbidden)
+			return
+		}
+		if r.FormValue("confirm") == "" {
+			http.Redirect(w, r, "/settings", http.StatusSeeOther)
+			return
+		}
+
+		// archive content is not kept in the database, so it must be
+		// listed before the account data is gone
+		archives, err := manager.Archives(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot list %d account archives: %s", user.AccountID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		err = authSrv.DeleteAccount(r.Context(), user.AccountID, func(c pg.Connection) error {
+			return DeleteAccountData(r.Context(), c, user.AccountID)
+		})
+		if err != nil {
+			log.Printf("cannot delete %d account: %s", user.AccountID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		for _, a := range archives {
+			if a.BlobKey == "" {
+				continue
+			}
+			if err := store.Delete(r.Context(), a.BlobKey); err != nil {
+				log.Printf("cannot delete %d archive content: %s", a.EntryID, err)
+			}
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:   auth.SessionCookie,
+			Path:   "/",
+			MaxAge: -1,
+		})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
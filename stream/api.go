@@ -0,0 +1,507 @@
+package stream
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/web"
+)
+
+// Cursor points to an entry position within entries listing. Listing is
+// ordered by publication time, with entry ID used to break ties.
+type Cursor struct {
+	Published time.Time
+	EntryID   int64
+}
+
+// IsZero returns true if cursor does not point to any position.
+func (c Cursor) IsZero() bool {
+	return c.EntryID == 0 && c.Published.IsZero()
+}
+
+// String returns opaque, URL safe representation of the cursor.
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.Published.UnixNano(), 10) + ":" + strconv.FormatInt(c.EntryID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ParseCursor returns cursor decoded from its string representation.
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	chunks := strings.SplitN(string(raw), ":", 2)
+	if len(chunks) != 2 {
+		return Cursor{}, errInvalidCursor
+	}
+	nano, err := strconv.ParseInt(chunks[0], 10, 64)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	id, err := strconv.ParseInt(chunks[1], 10, 64)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{Published: time.Unix(0, nano), EntryID: id}, nil
+}
+
+// apiEntry is JSON representation of an entry, as returned by the API.
+type apiEntry struct {
+	EntryID   int64     `json:"entry_id"`
+	FeedID    int64     `json:"feed_id"`
+	FeedTitle string    `json:"feed_title"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	WordCount int       `json:"word_count"`
+	Published time.Time `json:"published"`
+	Created   time.Time `json:"created"`
+	Read      bool      `json:"read"`
+	Starred   bool      `json:"starred"`
+	Tags      []string  `json:"tags,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	Selection string    `json:"selection,omitempty"`
+	Archived  bool      `json:"archived,omitempty"`
+}
+
+func newAPIEntry(e *Entry) *apiEntry {
+	return &apiEntry{
+		EntryID:   e.EntryID,
+		FeedID:    e.FeedID,
+		FeedTitle: e.FeedTitle,
+		Title:     e.Title,
+		URL:       e.URL,
+		WordCount: e.WordCount,
+		Published: e.Published,
+		Created:   e.Created,
+		Read:      e.Read,
+		Starred:   e.Starred,
+		Tags:      e.Tags,
+		Note:      e.Note,
+		Selection: e.Selection,
+		Archived:  e.Archived,
+	}
+}
+
+func newAPIEntries(entries []*Entry) []*apiEntry {
+	res := make([]*apiEntry, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, newAPIEntry(e))
+	}
+	return res
+}
+
+type apiSubscription struct {
+	SubscriptionID int64     `json:"subscription_id"`
+	FeedID         int64     `json:"feed_id"`
+	Title          string    `json:"title"`
+	URL            string    `json:"url"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+}
+
+func newAPISubscription(s *Subscription) *apiSubscription {
+	return &apiSubscription{
+		SubscriptionID: s.SubscriptionID,
+		FeedID:         s.FeedID,
+		Title:          s.Title,
+		URL:            s.URL,
+		Created:        s.Created,
+		Updated:        s.Updated,
+	}
+}
+
+type apiFeed struct {
+	FeedID      int64     `json:"feed_id"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	FaviconURL  string    `json:"favicon_url"`
+	Updated     time.Time `json:"updated"`
+	Autorefresh bool      `json:"autorefresh"`
+}
+
+// apiUser returns user authenticated with given request. If request is not
+// authenticated, error response is written and false returned.
+func apiUser(w http.ResponseWriter, r *http.Request, authSrv auth.AuthService) (*auth.User, bool) {
+	user, err := authSrv.CurrentUser(r.Context(), r)
+	switch err {
+	case nil:
+		return user, true
+	case auth.ErrNotAuthenticated:
+		web.JSONErr(w, "authentication required", http.StatusUnauthorized)
+	default:
+		log.Printf("cannot get current user: %s", err)
+		web.StdJSONResp(w, http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// decodeInput reads JSON request body into given destination. If request
+// is not valid, error response is written and false returned.
+//
+// Only requests with JSON content type are accepted. Browsers cannot send
+// such requests cross site without asking for permission first, which
+// protects cookie authenticated clients from forged requests.
+func decodeInput(w http.ResponseWriter, r *http.Request, dest interface{}) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		web.JSONErr(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(dest); err != nil {
+		web.JSONErr(w, "invalid input json: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// APIEntriesHandler returns single page of entries from subscribed feeds.
+// Response contains cursor that must be used to request the next page.
+func APIEntriesHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := apiUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		var q EntryQuery
+		var errs []string
+		if raw := r.URL.Query().Get("feed"); raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id < 1 {
+				errs = append(errs, `"feed" must be a feed ID`)
+			}
+			q.FeedID = id
+		}
+		q.Limit = 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > 200 {
+				errs = append(errs, `"limit" must be a number between 1 and 200`)
+			}
+			q.Limit = n
+		}
+		if raw := r.URL.Query().Get("cursor"); raw != "" {
+			c, err := ParseCursor(raw)
+			if err != nil {
+				errs = append(errs, `"cursor" is not valid`)
+			}
+			q.Before = c
+		}
+		if len(errs) != 0 {
+			web.JSONErrs(w, errs, http.StatusBadRequest)
+			return
+		}
+
+		// fetch one more than requested to know if there is a next page
+		limit := q.Limit
+		q.Limit++
+		entries, err := manager.EntriesPage(r.Context(), user.AccountID, q)
+		if err != nil {
+			log.Printf("cannot list entries: %s", err)
+			web.StdJSONResp(w, http.StatusInternalServerError)
+			return
+		}
+
+		content := struct {
+			Entries    []*apiEntry `json:"entries"`
+			NextCursor string      `json:"next_cursor,omitempty"`
+		}{}
+		if len(entries) > limit {
+			entries = entries[:limit]
+			last := entries[len(entries)-1]
+			content.NextCursor = Cursor{Published: last.Published, EntryID: last.EntryID}.String()
+		}
+		content.Entries = newAPIEntries(entries)
+		web.JSONResp(w, content, http.StatusOK)
+	}
+}
+
+// APIEntryHandler returns single entry.
+func APIEntryHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := apiUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		entryID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			web.StdJSONResp(w, http.StatusNotFound)
+			return
+		}
+
+		entry, err := manager.Entry(r.Context(), user.AccountID, entryID)
+		switch err {
+		case nil:
+			web.JSONResp(w, newAPIEntry(entry), http.StatusOK)
+		case pg.ErrNotFound:
+			web.StdJSONResp(w, http.StatusNotFound)
+		default:
+			log.Printf("cannot get entry %d: %s", entryID, err)
+			web.StdJSONResp(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+// APISubscriptionsHandler lists subscriptions of the current account on GET
+// and subscribes to a new feed on POST.
+func APISubscriptionsHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := apiUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		if r.Method == "GET" {
+			subs, err := manager.Subscriptions(r.Context(), user.AccountID)
+			if err != nil {
+				log.Printf("cannot list subscriptions: %s", err)
+				web.StdJSONResp(w, http.StatusInternalServerError)
+				return
+			}
+			content := struct {
+				Subscriptions []*apiSubscription `json:"subscriptions"`
+			}{
+				Subscriptions: make([]*apiSubscription, 0, len(subs)),
+			}
+			for _, s := range subs {
+				content.Subscriptions = append(content.Subscriptions, newAPISubscription(s))
+			}
+			web.JSONResp(w, content, http.StatusOK)
+			return
+		}
+
+		var input struct {
+			Url string `json:"url"`
+		}
+		if !decodeInput(w, r, &input) {
+			return
+		}
+		input.Url = strings.TrimSpace(input.Url)
+		if input.Url == "" {
+			web.JSONErr(w, `"url" is required`, http.StatusBadRequest)
+			return
+		}
+
+		feedID, err := manager.Subscribe(r.Context(), user.AccountID, input.Url)
+		if err != nil {
+			log.Printf("cannot subscribe to %q: %s", input.Url, err)
+			web.JSONErr(w, "cannot subscribe", http.StatusInternalServerError)
+			return
+		}
+		if err := manager.Update(r.Context(), feedID); err != nil {
+			log.Printf("cannot update subscription %q: %s", input.Url, err)
+		}
+
+		subs, err := manager.Subscriptions(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot list subscriptions: %s", err)
+			web.StdJSONResp(w, http.StatusInternalServerError)
+			return
+		}
+		for _, s := range subs {
+			if s.FeedID == feedID {
+				web.JSONResp(w, newAPISubscription(s), http.StatusCreated)
+				return
+			}
+		}
+		log.Printf("subscription of feed %d not found", feedID)
+		web.StdJSONResp(w, http.StatusInternalServerError)
+	}
+}
+
+// APISubscriptionHandler removes single subscription of the current account.
+func APISubscriptionHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := apiUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		subID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			web.StdJSONResp(w, http.StatusNotFound)
+			return
+		}
+		switch err := manager.Unsubscribe(r.Context(), subID, user.AccountID); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case pg.ErrNotFound:
+			web.JSONErr(w, "subscription not found", http.StatusNotFound)
+		default:
+			log.Printf("cannot unsubscribe %d: %s", subID, err)
+			web.StdJSONResp(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+// APIFeedHandler returns single feed the current account is subscribed to.
+func APIFeedHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := apiUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		feedID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			web.StdJSONResp(w, http.StatusNotFound)
+			return
+		}
+
+		feed, err := manager.SubscribedFeed(r.Context(), user.AccountID, feedID)
+		switch err {
+		case nil:
+			web.JSONResp(w, &apiFeed{
+				FeedID:      feed.FeedID,
+				Title:       feed.Title,
+				URL:         feed.URL,
+				FaviconURL:  feed.FaviconURL,
+				Updated:     feed.Updated,
+				Autorefresh: feed.Autorefresh,
+			}, http.StatusOK)
+		case pg.ErrNotFound:
+			web.StdJSONResp(w, http.StatusNotFound)
+		default:
+			log.Printf("cannot get feed %d: %s", feedID, err)
+			web.StdJSONResp(w, http.StatusInternalServerError)
+		}
+	}
+}
+
+// APIBookmarksHandler lists bookmarks of the current account on GET and
+// creates a new bookmark on POST.
+func APIBookmarksHandler(
+	manager Manager,
+	archiver Archiver,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := apiUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		if r.Method == "GET" {
+			bookmarks, err := manager.TaggedBookmarks(r.Context(), user.AccountID, normalizeTag(r.URL.Query().Get("tag")), 0)
+			if err != nil {
+				log.Printf("cannot list bookmarks: %s", err)
+				web.StdJSONResp(w, http.StatusInternalServerError)
+				return
+			}
+			content := struct {
+				Bookmarks []*apiEntry `json:"bookmarks"`
+			}{
+				Bookmarks: newAPIEntries(bookmarks),
+			}
+			web.JSONResp(w, content, http.StatusOK)
+			return
+		}
+
+		var input struct {
+			Title     string   `json:"title"`
+			Url       string   `json:"url"`
+			Tags      []string `json:"tags"`
+			Note      string   `json:"note"`
+			Selection string   `json:"selection"`
+		}
+		if !decodeInput(w, r, &input) {
+			return
+		}
+		if input.Url == "" {
+			web.JSONErr(w, `"url" is required`, http.StatusBadRequest)
+			return
+		}
+		err := manager.Bookmark(r.Context(), user.AccountID, input.Url, input.Title,
+			WithTags(input.Tags...),
+			WithNote(input.Note),
+			WithSelection(input.Selection))
+		if err != nil {
+			log.Printf("cannot create bookmark: %s", err)
+			web.JSONErr(w, "cannot create bookmark", http.StatusInternalServerError)
+			return
+		}
+		archiveInBackground(archiver)
+		web.StdJSONResp(w, http.StatusCreated)
+	}
+}
+
+// APIBookmarkHandler replaces tags and note of a single bookmark.
+func APIBookmarkHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := apiUser(w, r, authSrv)
+		if !ok {
+			return
+		}
+
+		entryID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			web.JSONErr(w, "invalid entry id", http.StatusBadRequest)
+			return
+		}
+		var input struct {
+			Tags []string `json:"tags"`
+			Note string   `json:"note"`
+		}
+		if !decodeInput(w, r, &input) {
+			return
+		}
+
+		switch err := manager.EditBookmark(r.Context(), user.AccountID, entryID, input.Tags, input.Note); err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			web.JSONErr(w, "bookmark not found", http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot edit bookmark %d: %s", entryID, err)
+			web.StdJSONResp(w, http.StatusInternalServerError)
+			return
+		}
+
+		entry, err := manager.BookmarkEntry(r.Context(), user.AccountID, entryID)
+		if err != nil {
+			log.Printf("cannot get bookmark %d: %s", entryID, err)
+			web.StdJSONResp(w, http.StatusInternalServerError)
+			return
+		}
+		web.JSONResp(w, newAPIEntry(entry), http.StatusOK)
+	}
+}
+
+// APIDocHandler serves OpenAPI document describing the API.
+func APIDocHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		http.ServeFile(w, r, path)
+	}
+}
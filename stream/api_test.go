@@ -0,0 +1,270 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/pg/pgtest"
+	"github.com/husio/web"
+)
+
+type stubAuth struct {
+	auth.AuthService
+	user *auth.User
+}
+
+func (a *stubAuth) CurrentUser(ctx context.Context, r *http.Request) (*auth.User, error) {
+	if a.user == nil {
+		return nil, auth.ErrNotAuthenticated
+	}
+	return a.user, nil
+}
+
+type stubManager struct {
+	Manager
+	entries []*Entry
+	queries []EntryQuery
+}
+
+func (m *stubManager) EntriesPage(ctx context.Context, accountID int64, q EntryQuery) ([]*Entry, error) {
+	m.queries = append(m.queries, q)
+	var res []*Entry
+	for _, e := range m.entries {
+		if !q.Before.IsZero() && !e.Published.Before(q.Before.Published) {
+			continue
+		}
+		if len(res) == q.Limit {
+			break
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func (m *stubManager) Entry(ctx context.Context, accountID, entryID int64) (*Entry, error) {
+	for _, e := range m.entries {
+		if e.EntryID == entryID {
+			return e, nil
+		}
+	}
+	return nil, pg.ErrNotFound
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{Published: time.Unix(1500000000, 123), EntryID: 42}
+	got, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatalf("cannot parse cursor: %s", err)
+	}
+	if !got.Published.Equal(c.Published) || got.EntryID != c.EntryID {
+		t.Fatalf("want %+v, got %+v", c, got)
+	}
+
+	for _, raw := range []string{"", "xyz", "MTIz", "YTpi"} {
+		if _, err := ParseCursor(raw); err == nil {
+			t.Errorf("%q: want error", raw)
+		}
+	}
+}
+
+func TestAPIEntriesHandlerPagination(t *testing.T) {
+	now := time.Now()
+	manager := &stubManager{}
+	for i := 1; i <= 5; i++ {
+		manager.entries = append(manager.entries, &Entry{
+			EntryID:   int64(i),
+			Title:     "entry",
+			Published: now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+	handler := APIEntriesHandler(manager, &stubAuth{user: &auth.User{AccountID: 1}})
+
+	var ids []int64
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/entries?limit=2&cursor="+cursor, nil)
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Entries []struct {
+				EntryID int64 `json:"entry_id"`
+			}
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("cannot decode response: %s", err)
+		}
+		for _, e := range resp.Entries {
+			ids = append(ids, e.EntryID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+
+	if len(ids) != 5 {
+		t.Fatalf("want all 5 entries, got %v", ids)
+	}
+	for i, id := range ids {
+		if id != int64(i+1) {
+			t.Fatalf("unexpected order: %v", ids)
+		}
+	}
+	if len(manager.queries) != 3 {
+		t.Fatalf("want 3 pages, got %d", len(manager.queries))
+	}
+}
+
+func TestAPIHandlersErrors(t *testing.T) {
+	manager := &stubManager{
+		entries: []*Entry{{EntryID: 1, Title: "first"}},
+	}
+	user := &auth.User{AccountID: 1}
+
+	cases := map[string]struct {
+		handler  http.HandlerFunc
+		url      string
+		wantCode int
+	}{
+		"not authenticated": {
+			handler:  APIEntriesHandler(manager, &stubAuth{}),
+			url:      "/api/v1/entries",
+			wantCode: http.StatusUnauthorized,
+		},
+		"invalid limit": {
+			handler:  APIEntriesHandler(manager, &stubAuth{user: user}),
+			url:      "/api/v1/entries?limit=1000",
+			wantCode: http.StatusBadRequest,
+		},
+		"invalid cursor": {
+			handler:  APIEntriesHandler(manager, &stubAuth{user: user}),
+			url:      "/api/v1/entries?cursor=xyz",
+			wantCode: http.StatusBadRequest,
+		},
+		"entry not found": {
+			handler:  APIEntryHandler(manager, &stubAuth{user: user}),
+			url:      "/api/v1/entries/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for tname, tc := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tc.url, nil)
+		tc.handler(w, r)
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: want %d, got %d", tname, tc.wantCode, w.Code)
+			continue
+		}
+		var resp struct {
+			Code   int
+			Errors []string
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: cannot decode response: %s", tname, err)
+		} else if resp.Code != tc.wantCode || len(resp.Errors) == 0 {
+			t.Errorf("%s: unexpected error response: %s", tname, w.Body)
+		}
+	}
+}
+
+type unsubscribeManager struct {
+	stubManager
+	subs map[int64]int64
+}
+
+func (m *unsubscribeManager) Unsubscribe(ctx context.Context, subscriptionID, accountID int64) error {
+	if m.subs[subscriptionID] != accountID {
+		return pg.ErrNotFound
+	}
+	delete(m.subs, subscriptionID)
+	return nil
+}
+
+func TestAPISubscriptionHandler(t *testing.T) {
+	m := &unsubscribeManager{subs: map[int64]int64{3: 1, 4: 2}}
+	rt := web.NewRouter()
+	rt.Add(`/api/v1/subscriptions/(subscription-id)`, "DELETE", APISubscriptionHandler(m, &stubAuth{user: &auth.User{AccountID: 1}}))
+
+	cases := []struct {
+		path     string
+		wantCode int
+	}{
+		{"/api/v1/subscriptions/3", http.StatusNoContent},
+		{"/api/v1/subscriptions/3", http.StatusNotFound},
+		{"/api/v1/subscriptions/4", http.StatusNotFound},
+		{"/api/v1/subscriptions/5", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("DELETE", tc.path, nil))
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: want %d, got %d", tc.path, tc.wantCode, w.Code)
+		}
+	}
+	if _, ok := m.subs[4]; !ok {
+		t.Fatal("subscription of another account removed")
+	}
+}
+
+func TestAPIRequiresJSONInput(t *testing.T) {
+	authSrv := &stubAuth{user: &auth.User{AccountID: 1}}
+	rt := web.NewRouter()
+	rt.Add(`/api/v1/subscriptions`, "POST", APISubscriptionsHandler(&stubManager{}, authSrv))
+	rt.Add(`/api/v1/bookmarks`, "POST", APIBookmarksHandler(&stubManager{}, nil, authSrv))
+	rt.Add(`/api/v1/bookmarks/(entry-id)`, "PUT", APIBookmarkHandler(&stubManager{}, authSrv))
+
+	for _, req := range []string{"POST /api/v1/subscriptions", "POST /api/v1/bookmarks", "PUT /api/v1/bookmarks/3"} {
+		for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+			// body of a cross site form using text/plain encoding
+			body := strings.NewReader(`{"url": "http://example.com/", "x": "="}`)
+			chunks := strings.SplitN(req, " ", 2)
+			r := httptest.NewRequest(chunks[0], chunks[1], body)
+			if contentType != "" {
+				r.Header.Set("Content-Type", contentType)
+			}
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, r)
+			if w.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("%s %q: want 415, got %d", req, contentType, w.Code)
+			}
+		}
+	}
+}
+
+func TestManagerEntriesPageQuery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := Cursor{Published: time.Unix(1500000000, 0), EntryID: 13}
+
+	db := pgtest.NewMock()
+	db.ExpectSelect(`SELECT .* \(e.published, e.entry_id\) < \(\$3, \$4::bigint\)`).
+		WithArgs(1, false, before.Published, 13, 7, time.Time{}, true, false, 20, false).
+		WillReturnRows(pgtest.NewRows("entry_id", "feed_id", "title").
+			AddRow(12, 7, "first").
+			AddRow(11, 7, "second"))
+
+	m := NewManager(db, nil, nil, "")
+	entries, err := m.EntriesPage(ctx, 1, EntryQuery{FeedID: 7, Before: before, Unread: true, Limit: 20})
+	if err != nil {
+		t.Fatalf("cannot list entries: %s", err)
+	}
+	if len(entries) != 2 || entries[0].EntryID != 12 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if err := db.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,508 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/blob"
+	"github.com/husio/feedstream/lock"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/ui"
+	"github.com/husio/web"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxArchivePageSize is the maximum size of archived HTML document,
+	// before images are inlined.
+	maxArchivePageSize = 5 << 20
+
+	// maxArchiveImageSize is the maximum size of a single inlined image.
+	// Bigger images are not included in the snapshot.
+	maxArchiveImageSize = 2 << 20
+
+	// maxArchiveImagesSize is the maximum size of all images inlined into
+	// a single snapshot.
+	maxArchiveImagesSize = 10 << 20
+)
+
+// archiveCSP is the content security policy of served snapshots. Archived
+// pages are not trusted, so nothing but inlined content is allowed and
+// the page is sandboxed, in case anything unsafe survived the cleanup.
+const archiveCSP = "default-src 'none'; img-src data:; style-src 'unsafe-inline'; sandbox allow-popups allow-popups-to-escape-sandbox"
+
+// Archiver takes snapshots of bookmarked pages.
+type Archiver interface {
+	// ArchivePending takes snapshots of all bookmarks that were not
+	// archived yet.
+	ArchivePending(ctx context.Context) error
+}
+
+// PageArchiver fetches bookmarked pages and stores their cleaned up copy,
+// with all images inlined, as a single HTML document.
+type PageArchiver struct {
+	manager Manager
+	store   blob.Store
+	locker  lock.Locker
+
+	// Client is used to fetch pages and images. By default it connects
+	// to public addresses only.
+	Client *http.Client
+}
+
+var _ Archiver = (*PageArchiver)(nil)
+
+func NewPageArchiver(manager Manager, store blob.Store, locker lock.Locker) *PageArchiver {
+	return &PageArchiver{
+		manager: manager,
+		store:   store,
+		locker:  locker,
+		Client:  NewPublicClient(30 * time.Second),
+	}
+}
+
+// ArchivePending takes snapshots of all bookmarks that were not archived
+// yet. Only one process at a time is archiving. If another process is
+// already running, ArchivePending returns immediately.
+func (a *PageArchiver) ArchivePending(ctx context.Context) error {
+	err := lock.Run(ctx, a.locker, "stream:archive", time.Minute, func(ctx context.Context) error {
+		for {
+			pending, err := a.manager.PendingArchives(ctx, 20)
+			if err != nil {
+				return fmt.Errorf("cannot list pending archives: %s", err)
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+			for _, arch := range pending {
+				if err := a.archive(ctx, arch); err != nil {
+					return err
+				}
+			}
+		}
+	})
+	if err == lock.ErrLocked {
+		return nil
+	}
+	return err
+}
+
+// archive takes and stores snapshot of given bookmark. Failure to take the
+// snapshot is recorded and does not result in an error, so that the page
+// is not retried.
+func (a *PageArchiver) archive(ctx context.Context, arch *Archive) error {
+	arch.Created = time.Now()
+	doc, err := a.snapshot(ctx, arch.URL, arch.Created)
+	if err != nil {
+		log.Printf("cannot archive %q: %s", arch.URL, err)
+		arch.Error = err.Error()
+	} else {
+		arch.BlobKey = archiveKey(arch.EntryID)
+		arch.Size = int64(len(doc))
+		if err := a.store.Put(ctx, arch.BlobKey, bytes.NewReader(doc)); err != nil {
+			return fmt.Errorf("cannot store %d archive: %s", arch.EntryID, err)
+		}
+	}
+	return a.manager.SaveArchive(ctx, arch)
+}
+
+func archiveKey(entryID int64) string {
+	return fmt.Sprintf("archive/%d.html", entryID)
+}
+
+// snapshot returns single file HTML document with the content of the page
+// under given URL. Scripts, frames, plugins and event handlers are
+// removed, links are made absolute and images are inlined.
+func (a *PageArchiver) snapshot(ctx context.Context, pageURL string, taken time.Time) ([]byte, error) {
+	resp, err := a.get(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "text/html") && !strings.HasPrefix(contentType, "application/xhtml+xml") {
+		return nil, fmt.Errorf("not an HTML page: %q", contentType)
+	}
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxArchivePageSize), contentType)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode page: %s", err)
+	}
+	doc, err := html.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse page: %s", err)
+	}
+
+	// redirects are followed, so relative URLs are resolved against the
+	// final location, unless the document declares its own base
+	base := resp.Request.URL
+	if href := baseHref(doc); href != "" {
+		if u, err := base.Parse(href); err == nil {
+			base = u
+		}
+	}
+
+	cleanArchive(doc, base)
+	a.inlineImages(ctx, doc)
+	addArchiveHeader(doc, pageURL, taken)
+
+	var b bytes.Buffer
+	if err := html.Render(&b, doc); err != nil {
+		return nil, fmt.Errorf("cannot render snapshot: %s", err)
+	}
+	return b.Bytes(), nil
+}
+
+func (a *PageArchiver) get(ctx context.Context, rawurl string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %s", err)
+	}
+	resp, err := a.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// archiveDroppedElements are removed from snapshots together with their
+// content.
+var archiveDroppedElements = map[string]bool{
+	"script":   true,
+	"noscript": true,
+	"iframe":   true,
+	"frame":    true,
+	"frameset": true,
+	"object":   true,
+	"embed":    true,
+	"applet":   true,
+	"base":     true,
+	"link":     true,
+	"meta":     true,
+	"template": true,
+}
+
+// archiveURLAttrs are attributes containing URL, that are made absolute.
+var archiveURLAttrs = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"action": true,
+	"poster": true,
+	"cite":   true,
+}
+
+func baseHref(n *html.Node) string {
+	if n.Type == html.ElementNode && n.Data == "base" {
+		return attrValue(n, "href")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if href := baseHref(c); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+// cleanArchive removes from the document all active content and resolves
+// relative URLs against given base.
+func cleanArchive(n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode && archiveDroppedElements[c.Data]:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode:
+			cleanArchiveAttrs(c, base)
+			cleanArchive(c, base)
+		}
+		c = next
+	}
+}
+
+func cleanArchiveAttrs(n *html.Node, base *url.URL) {
+	// lazy loaded images keep the real source in data attribute
+	if n.Data == "img" {
+		if src := attrValue(n, "data-src"); src != "" {
+			if cur := attrValue(n, "src"); cur == "" || strings.HasPrefix(cur, "data:") {
+				setAttr(n, "src", src)
+			}
+		}
+	}
+
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		switch {
+		case strings.HasPrefix(key, "on"):
+			continue
+		case key == "srcset" || key == "integrity" || key == "nonce":
+			continue
+		case archiveURLAttrs[key]:
+			u, err := base.Parse(strings.TrimSpace(attr.Val))
+			if err != nil {
+				continue
+			}
+			switch u.Scheme {
+			case "http", "https", "mailto":
+				attr.Val = u.String()
+			case "data":
+				if n.Data != "img" || key != "src" {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+
+	if n.Data == "a" {
+		setAttr(n, "rel", "noopener noreferrer")
+	}
+}
+
+// inlineImages replaces sources of all images with data URIs. Images that
+// cannot be fetched or are too big are left unchanged, which means they
+// will not be displayed.
+func (a *PageArchiver) inlineImages(ctx context.Context, doc *html.Node) {
+	var (
+		budget int64 = maxArchiveImagesSize
+		cache        = make(map[string]string)
+		walk   func(*html.Node)
+	)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "img" {
+			src := attrValue(n, "src")
+			if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+				data, ok := cache[src]
+				if !ok && budget > 0 {
+					var err error
+					data, err = a.fetchImage(ctx, src, budget)
+					if err != nil {
+						log.Printf("cannot inline %q image: %s", src, err)
+					}
+					budget -= int64(len(data))
+					cache[src] = data
+				}
+				if data != "" {
+					setAttr(n, "src", data)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+}
+
+// fetchImage returns image under given URL, encoded as data URI.
+func (a *PageArchiver) fetchImage(ctx context.Context, imageURL string, budget int64) (string, error) {
+	resp, err := a.get(ctx, imageURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	limit := int64(maxArchiveImageSize)
+	if budget < limit {
+		limit = budget
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return "", fmt.Errorf("cannot read: %s", err)
+	}
+	if int64(len(b)) > limit {
+		return "", fmt.Errorf("image too big")
+	}
+	mime := http.DetectContentType(b)
+	if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "image/") {
+		mime = ct
+	}
+	if i := strings.Index(mime, ";"); i != -1 {
+		mime = mime[:i]
+	}
+	if !strings.HasPrefix(mime, "image/") {
+		return "", fmt.Errorf("not an image: %q", mime)
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// addArchiveHeader declares document encoding and adds information about
+// the snapshot at the top of the page.
+func addArchiveHeader(doc *html.Node, pageURL string, taken time.Time) {
+	var head, body *html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "head":
+				head = n
+			case "body":
+				body = n
+			}
+		}
+		for c := n.FirstChild; c != nil && (head == nil || body == nil); c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+
+	if head != nil {
+		meta := &html.Node{
+			Type: html.ElementNode,
+			Data: "meta",
+			Attr: []html.Attribute{{Key: "charset", Val: "utf-8"}},
+		}
+		head.InsertBefore(meta, head.FirstChild)
+	}
+	if body != nil {
+		link := &html.Node{
+			Type: html.ElementNode,
+			Data: "a",
+			Attr: []html.Attribute{
+				{Key: "href", Val: pageURL},
+				{Key: "rel", Val: "noopener noreferrer"},
+			},
+		}
+		link.AppendChild(&html.Node{Type: html.TextNode, Data: pageURL})
+		info := &html.Node{
+			Type: html.ElementNode,
+			Data: "p",
+			Attr: []html.Attribute{{Key: "style", Val: "margin:0;padding:8px;background:#ffd;border-bottom:1px solid #ccc;font:14px sans-serif;color:#333"}},
+		}
+		info.AppendChild(&html.Node{Type: html.TextNode, Data: "Snapshot of "})
+		info.AppendChild(link)
+		info.AppendChild(&html.Node{Type: html.TextNode, Data: " taken on " + taken.Format("2 January 2006") + "."})
+		body.InsertBefore(info, body.FirstChild)
+	}
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// archiveInBackground takes snapshots of pending bookmarks without
+// blocking the caller.
+func archiveInBackground(archiver Archiver) {
+	// request context is cancelled once response is written, so it
+	// cannot be used
+	go func() {
+		if err := archiver.ArchivePending(context.Background()); err != nil {
+			log.Printf("cannot archive bookmarks: %s", err)
+		}
+	}()
+}
+
+// ArchiveHandler serves snapshot of a bookmarked page.
+func ArchiveHandler(
+	manager Manager,
+	store blob.Store,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		entryID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			tmpl.RenderStd(w, http.StatusBadRequest)
+			return
+		}
+
+		arch, err := manager.Archive(r.Context(), user.AccountID, entryID)
+		switch err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot get %d archive: %s", entryID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+		if arch.Error != "" {
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		}
+
+		content, err := store.Get(r.Context(), arch.BlobKey)
+		switch err {
+		case nil:
+			// all good
+		case blob.ErrNotFound:
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot read %d archive: %s", entryID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", archiveCSP)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		if _, err := io.Copy(w, content); err != nil {
+			log.Printf("cannot write %d archive: %s", entryID, err)
+		}
+	}
+}
+
+// ArchiveBookmarksHandler takes snapshots of all bookmarks that were not
+// archived yet. Archiving is running in the background.
+func ArchiveBookmarksHandler(
+	archiver Archiver,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		archiveInBackground(archiver)
+		web.StdJSONResp(w, http.StatusAccepted)
+	}
+}
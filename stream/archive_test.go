@@ -0,0 +1,184 @@
+package stream
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/blob"
+	"github.com/husio/feedstream/lock"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/web"
+)
+
+// gifPixel is the smallest valid GIF image.
+var gifPixel = []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+func newArchivedSite() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<!doctype html>
+<html><head>
+	<title>Article</title>
+	<script src="/tracker.js"></script>
+	<link rel="stylesheet" href="/style.css">
+	<meta http-equiv="refresh" content="0; url=/elsewhere">
+</head>
+<body onload="track()">
+	<!-- comment -->
+	<h1>Article</h1>
+	<p>Read <a href="/other" onclick="steal()">other</a> or <a href="javascript:alert(1)">this</a>.</p>
+	<img src="/pixel.gif" srcset="/big.gif 2x">
+	<img data-src="pixel.gif" src="data:image/gif;base64,AAAA">
+	<img src="/missing.gif">
+	<iframe src="http://ads.example.com/"></iframe>
+	<script>steal()</script>
+</body></html>`))
+	})
+	mux.HandleFunc("/pixel.gif", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		w.Write(gifPixel)
+	})
+	mux.HandleFunc("/data.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestSnapshot(t *testing.T) {
+	site := newArchivedSite()
+	defer site.Close()
+
+	a := NewPageArchiver(nil, nil, nil)
+	if _, err := a.snapshot(context.Background(), site.URL+"/article", time.Now()); err == nil {
+		t.Fatal("snapshot of local page must fail")
+	}
+	a.Client = site.Client()
+	b, err := a.snapshot(context.Background(), site.URL+"/article", time.Now())
+	if err != nil {
+		t.Fatalf("cannot take snapshot: %s", err)
+	}
+	doc := string(b)
+
+	for _, unwanted := range []string{
+		"<script", "steal()", "track()", "tracker.js", "style.css", "refresh",
+		"<iframe", "comment", "javascript:", "srcset", "big.gif", `"/other"`,
+	} {
+		if strings.Contains(doc, unwanted) {
+			t.Errorf("snapshot contains %q:\n%s", unwanted, doc)
+		}
+	}
+	for _, wanted := range []string{
+		`<meta charset="utf-8"/>`,
+		`<h1>Article</h1>`,
+		`href="` + site.URL + `/other" rel="noopener noreferrer"`,
+		`<img src="data:image/gif;base64,R0lGODlhAQABAAAAADs="/>`,
+		`<img src="` + site.URL + `/missing.gif"/>`,
+		`Snapshot of <a href="` + site.URL + `/article"`,
+	} {
+		if !strings.Contains(doc, wanted) {
+			t.Errorf("snapshot does not contain %q:\n%s", wanted, doc)
+		}
+	}
+	if n := strings.Count(doc, "data:image/gif;base64,R0lGODlhAQABAAAAADs="); n != 2 {
+		t.Errorf("want both images inlined, got %d:\n%s", n, doc)
+	}
+
+	if _, err := a.snapshot(context.Background(), site.URL+"/data.json", time.Now()); err == nil {
+		t.Error("snapshot of non HTML document must fail")
+	}
+}
+
+type archiveManager struct {
+	stubManager
+	pending []*Archive
+	saved   []*Archive
+}
+
+func (m *archiveManager) PendingArchives(ctx context.Context, limit int) ([]*Archive, error) {
+	pending := m.pending
+	m.pending = nil
+	return pending, nil
+}
+
+func (m *archiveManager) SaveArchive(ctx context.Context, a *Archive) error {
+	m.saved = append(m.saved, a)
+	return nil
+}
+
+func (m *archiveManager) Archive(ctx context.Context, accountID, entryID int64) (*Archive, error) {
+	for _, a := range m.saved {
+		if a.AccountID == accountID && a.EntryID == entryID {
+			return a, nil
+		}
+	}
+	return nil, pg.ErrNotFound
+}
+
+func TestArchivePending(t *testing.T) {
+	site := newArchivedSite()
+	defer site.Close()
+
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("cannot create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := blob.NewFSStore(dir)
+	if err != nil {
+		t.Fatalf("cannot create store: %s", err)
+	}
+
+	m := &archiveManager{
+		pending: []*Archive{
+			{EntryID: 3, AccountID: 1, URL: site.URL + "/article"},
+			{EntryID: 4, AccountID: 1, URL: site.URL + "/gone"},
+		},
+	}
+	a := NewPageArchiver(m, store, lock.NewMemoryLocker())
+	a.Client = site.Client()
+	if err := a.ArchivePending(context.Background()); err != nil {
+		t.Fatalf("cannot archive: %s", err)
+	}
+	if len(m.saved) != 2 {
+		t.Fatalf("want two archives saved, got %d", len(m.saved))
+	}
+	if ok := m.saved[0]; ok.BlobKey != "archive/3.html" || ok.Error != "" || ok.Size == 0 {
+		t.Fatalf("unexpected archive: %+v", ok)
+	}
+	if failed := m.saved[1]; failed.BlobKey != "" || failed.Error == "" {
+		t.Fatalf("unexpected archive: %+v", failed)
+	}
+
+	rt := web.NewRouter()
+	rt.Add(`/entries/(entry-id)/archive`, "GET", ArchiveHandler(m, store, &stubAuth{user: &auth.User{AccountID: 1}}, &stubRenderer{}))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/entries/3/archive")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); csp != archiveCSP {
+		t.Fatalf("unexpected content security policy: %q", csp)
+	}
+	if !strings.Contains(w.Body.String(), "<h1>Article</h1>") {
+		t.Fatalf("unexpected archive content: %s", w.Body)
+	}
+	for _, path := range []string{"/entries/4/archive", "/entries/5/archive"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: want 404, got %d", path, w.Code)
+		}
+	}
+}
@@ -35,7 +35,8 @@ f.onload = function () {
 	var url = location.href
 	var canonical = document.querySelector("link[rel='canonical']")
 	if (canonical && canonical.href) { url = canonical.href }
-	f.contentWindow.postMessage({title: document.title, url: url}, "*")
+	var selection = window.getSelection ? String(window.getSelection()) : ""
+	f.contentWindow.postMessage({title: document.title, url: url, selection: selection}, "*")
 }
 document.body.appendChild(f)
 `))
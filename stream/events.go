@@ -0,0 +1,231 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/ui"
+	"github.com/lib/pq"
+)
+
+// entriesChannel is the name of PostgreSQL notification channel used to
+// announce new entries.
+const entriesChannel = "stream_entries"
+
+// EntriesEvent is published every time new entries are added to a feed.
+type EntriesEvent struct {
+	FeedID  int64 `json:"feed_id"`
+	Entries int64 `json:"entries"`
+}
+
+// EventBroker listens for PostgreSQL notifications about new entries and fan
+// them out to all subscribers interested in given feed. Because
+// notifications are send by the database, entries inserted by any process
+// are published.
+type EventBroker struct {
+	listener *pq.Listener
+	stop     chan struct{}
+
+	mu   sync.Mutex
+	subs map[*eventSub]struct{}
+}
+
+type eventSub struct {
+	feeds map[int64]struct{}
+	c     chan *EntriesEvent
+}
+
+// NewEventBroker returns broker listening for notifications using dedicated
+// connection to database described by given connection string.
+func NewEventBroker(credentials string) (*EventBroker, error) {
+	listener := pq.NewListener(credentials, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("notification listener error: %s", err)
+		}
+	})
+	if err := listener.Listen(entriesChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot listen: %s", err)
+	}
+
+	b := newEventBroker()
+	b.listener = listener
+	go b.listen(listener.NotificationChannel())
+	return b, nil
+}
+
+func newEventBroker() *EventBroker {
+	return &EventBroker{
+		stop: make(chan struct{}),
+		subs: make(map[*eventSub]struct{}),
+	}
+}
+
+func (b *EventBroker) listen(notifications <-chan *pq.Notification) {
+	// listener connection must be pinged from time to time to detect
+	// connection loss
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			// nil notification is send after reconnecting, when some
+			// notifications might have been lost
+			if n != nil {
+				b.dispatch(n.Extra)
+			}
+		case <-ping.C:
+			go func() {
+				if err := b.listener.Ping(); err != nil {
+					log.Printf("cannot ping notification listener: %s", err)
+				}
+			}()
+		}
+	}
+}
+
+func (b *EventBroker) dispatch(payload string) {
+	var ev EntriesEvent
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+		log.Printf("cannot deserialize entries event %q: %s", payload, err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		if _, ok := s.feeds[ev.FeedID]; !ok {
+			continue
+		}
+		select {
+		case s.c <- &ev:
+		default:
+			// slow consumer, drop event instead of blocking all
+			// other subscribers
+		}
+	}
+}
+
+// Subscribe returns channel that receives events about new entries of any of
+// given feeds. Returned function must be called to release the subscription.
+func (b *EventBroker) Subscribe(feedIDs []int64) (<-chan *EntriesEvent, func()) {
+	s := &eventSub{
+		feeds: make(map[int64]struct{}, len(feedIDs)),
+		c:     make(chan *EntriesEvent, 16),
+	}
+	for _, id := range feedIDs {
+		s.feeds[id] = struct{}{}
+	}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, s)
+			b.mu.Unlock()
+		})
+	}
+	return s.c, cancel
+}
+
+// Close stops listening for notifications.
+func (b *EventBroker) Close() error {
+	close(b.stop)
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Close()
+}
+
+// EventsHandler streams events about new entries of feeds subscribed by
+// current user, using server-sent events.
+func EventsHandler(
+	manager Manager,
+	broker *EventBroker,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			tmpl.RenderStd(w, http.StatusUnauthorized)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Printf("streaming not supported by %T", w)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		subs, err := manager.Subscriptions(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot list subscriptions: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+		feeds := make([]int64, 0, len(subs))
+		for _, s := range subs {
+			feeds = append(feeds, s.FeedID)
+		}
+
+		events, cancel := broker.Subscribe(feeds)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 10000\n\n")
+		flusher.Flush()
+
+		// comment line is periodically send to keep the connection
+		// open when there are no events
+		heartbeat := time.NewTicker(30 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case ev := <-events:
+				b, err := json.Marshal(ev)
+				if err != nil {
+					log.Printf("cannot serialize event: %s", err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: entries\ndata: %s\n\n", b); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
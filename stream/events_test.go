@@ -0,0 +1,39 @@
+package stream
+
+import "testing"
+
+func TestEventBrokerDispatch(t *testing.T) {
+	b := newEventBroker()
+
+	first, cancelFirst := b.Subscribe([]int64{1, 2})
+	defer cancelFirst()
+	second, cancelSecond := b.Subscribe([]int64{2})
+	defer cancelSecond()
+
+	b.dispatch(`{"feed_id": 1, "entries": 3}`)
+	b.dispatch(`invalid`)
+	b.dispatch(`{"feed_id": 2, "entries": 1}`)
+
+	if ev := <-first; ev.FeedID != 1 || ev.Entries != 3 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if ev := <-first; ev.FeedID != 2 || ev.Entries != 1 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if ev := <-second; ev.FeedID != 2 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	select {
+	case ev := <-second:
+		t.Fatalf("unexpected event: %+v", ev)
+	default:
+	}
+
+	cancelSecond()
+	b.dispatch(`{"feed_id": 2, "entries": 1}`)
+	select {
+	case ev := <-second:
+		t.Fatalf("event received after cancel: %+v", ev)
+	default:
+	}
+}
@@ -99,8 +99,12 @@ func (f *feedinfo) Entries() []*Entry {
 			link = "https:" + link
 		}
 		e := &Entry{
-			Title: it.Title,
-			URL:   link,
+			Title:   it.Title,
+			URL:     link,
+			Content: it.Content,
+		}
+		if e.Content == "" {
+			e.Content = it.Description
 		}
 		if it.PublishedParsed != nil {
 			e.Published = *it.PublishedParsed
@@ -0,0 +1,39 @@
+package stream
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::6810":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"0.0.0.0":          false,
+		"::":               false,
+		"100.64.0.1":       false,
+		"::ffff:127.0.0.1": false,
+	}
+	for addr, want := range cases {
+		if got := isPublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("%s: want %v, got %v", addr, want, got)
+		}
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	if err := dialPublicOnly("tcp", "127.0.0.1:80", nil); err == nil {
+		t.Fatal("loopback connection must be rejected")
+	}
+	if err := dialPublicOnly("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Fatalf("public connection rejected: %s", err)
+	}
+}
@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"context"
 	"encoding/json"
 	"html/template"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
 	"github.com/husio/feedstream/ui"
 	"github.com/husio/web"
 )
@@ -38,8 +40,11 @@ func EntriesHandler(
 			entries []*Entry
 			feed    *Feed
 		)
+		tag := normalizeTag(r.URL.Query().Get("tag"))
 		feedID, err := strconv.ParseInt(r.URL.Query().Get("feed"), 10, 64)
-		if feedID > 0 {
+		if tag != "" {
+			entries, err = manager.TaggedBookmarks(r.Context(), user.AccountID, tag, 0)
+		} else if feedID > 0 {
 			feed, err = manager.Feed(r.Context(), feedID)
 			if err != nil {
 				log.Printf("cannot fetch feed %d: %s", feedID, err)
@@ -54,17 +59,56 @@ func EntriesHandler(
 			return
 		}
 
+		tags, err := manager.Tags(r.Context(), user.AccountID)
+		if err != nil {
+			log.Printf("cannot list tags: %s", err)
+		}
+
 		content := struct {
-			Feed    *Feed
-			Entries []*Entry
+			AccountID   int64
+			Feed        *Feed
+			Tag         string
+			TagCloud    []*cloudTag
+			Entries     []*Entry
+			StreamQuery string
 		}{
-			Feed:    feed,
-			Entries: entries,
+			AccountID:   user.AccountID,
+			Feed:        feed,
+			Tag:         tag,
+			TagCloud:    tagCloud(tags),
+			Entries:     entries,
+			StreamQuery: streamQuery(feedID, tag),
 		}
 		tmpl.Render(w, "entrylist.tmpl", content, http.StatusOK)
 	}
 }
 
+type cloudTag struct {
+	Tag   string
+	Count int
+	// Weight is a number from 1 to 5, proportional to the number of
+	// bookmarks using the tag.
+	Weight int
+}
+
+func tagCloud(tags []*TagCount) []*cloudTag {
+	max := 1
+	for _, t := range tags {
+		if t.Count > max {
+			max = t.Count
+		}
+	}
+	cloud := make([]*cloudTag, 0, len(tags))
+	for _, t := range tags {
+		cloud = append(cloud, &cloudTag{
+			Tag:    t.Tag,
+			Count:  t.Count,
+			Weight: 1 + 4*t.Count/max,
+		})
+	}
+	return cloud
+}
+
 func SubscriptionHandler(
 	manager Manager,
 	bookmarklet BookmarkletRenderer,
@@ -98,12 +142,33 @@ func SubscriptionHandler(
 				log.Printf("cannot render bookmarklet attribute: %s", err)
 			}
 
+			feedToken, err := manager.FeedToken(r.Context(), user.AccountID)
+			if err != nil {
+				log.Printf("cannot get feed token: %s", err)
+			}
+
+			shares, err := manager.Shares(r.Context(), user.AccountID)
+			if err != nil {
+				log.Printf("cannot list shares: %s", err)
+			}
+
+			tags, err := manager.Tags(r.Context(), user.AccountID)
+			if err != nil {
+				log.Printf("cannot list tags: %s", err)
+			}
+
 			content := struct {
 				Subscriptions   []*Subscription
 				BookmarkletHref template.HTMLAttr
+				FeedToken       string
+				Shares          []*Share
+				Tags            []*TagCount
 			}{
 				Subscriptions:   subs,
 				BookmarkletHref: bookmarkletAttr,
+				FeedToken:       feedToken,
+				Shares:          shares,
+				Tags:            tags,
 			}
 			tmpl.Render(w, "subscribe.tmpl", content, http.StatusOK)
 			return
@@ -136,9 +201,12 @@ func UpdateOutdatedHandler(
 		}
 
 		for _, id := range ids {
+			// update is running in the background, so it cannot use
+			// request context, which is cancelled once response is
+			// written
 			go func(id int64) {
 				log.Printf("updating feed: %d", id)
-				if err := manager.Update(r.Context(), id); err != nil {
+				if err := manager.Update(context.Background(), id); err != nil {
 					log.Printf("cannot update feed %d: %s", id, err)
 				}
 			}(id)
@@ -172,7 +240,13 @@ func RemoveSubscriptionHandler(
 			return
 		}
 
-		if err := manager.Unsubscribe(r.Context(), subID, user.AccountID); err != nil {
+		switch err := manager.Unsubscribe(r.Context(), subID, user.AccountID); err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		default:
 			log.Printf("cannot unsubscribe %d: %s", subID, err)
 			tmpl.RenderStd(w, http.StatusInternalServerError)
 			return
@@ -188,6 +262,7 @@ func RemoveSubscriptionHandler(
 
 func BookmarkHandler(
 	manager Manager,
+	archiver Archiver,
 	authSrv auth.AuthService,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -216,8 +291,11 @@ func BookmarkHandler(
 
 		// TODO: use bookmarklet key as authentication method
 		var input struct {
-			Title string `json:"title"`
-			Url   string `json:"url"`
+			Title     string   `json:"title"`
+			Url       string   `json:"url"`
+			Tags      []string `json:"tags"`
+			Note      string   `json:"note"`
+			Selection string   `json:"selection"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 			web.JSONErr(w, "invalid input json: "+err.Error(), http.StatusBadRequest)
@@ -228,15 +306,85 @@ func BookmarkHandler(
 			return
 		}
 
-		if err := manager.Bookmark(r.Context(), user.AccountID, input.Url, input.Title); err != nil {
+		err = manager.Bookmark(r.Context(), user.AccountID, input.Url, input.Title,
+			WithTags(input.Tags...),
+			WithNote(input.Note),
+			WithSelection(input.Selection))
+		if err != nil {
 			log.Printf("cannot create bookmark: %s", err)
 			web.JSONErr(w, "cannot create bookmark", http.StatusInternalServerError)
 			return
 		}
+		archiveInBackground(archiver)
 		web.StdJSONResp(w, http.StatusCreated)
 	}
 }
 
+// EditBookmarkHandler displays and updates tags and note of a bookmark.
+func EditBookmarkHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		entryID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			tmpl.RenderStd(w, http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == "POST" {
+			tags := parseTags(r.FormValue("tags"))
+			note := strings.TrimSpace(r.FormValue("note"))
+			switch err := manager.EditBookmark(r.Context(), user.AccountID, entryID, tags, note); err {
+			case nil:
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+			case pg.ErrNotFound:
+				tmpl.RenderStd(w, http.StatusNotFound)
+			default:
+				log.Printf("cannot edit bookmark %d: %s", entryID, err)
+				tmpl.RenderStd(w, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		entry, err := manager.BookmarkEntry(r.Context(), user.AccountID, entryID)
+		switch err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot get bookmark %d: %s", entryID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		content := struct {
+			Entry *Entry
+			Tags  string
+		}{
+			Entry: entry,
+			Tags:  strings.Join(entry.Tags, ", "),
+		}
+		tmpl.Render(w, "bookmark.tmpl", content, http.StatusOK)
+	}
+}
+
 func BookmarkletHandler() http.HandlerFunc {
 	const content = `<!doctype html>
 <script>
@@ -0,0 +1,425 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/cache"
+	"github.com/husio/feedstream/randstr"
+	"github.com/husio/feedstream/ui"
+	"github.com/husio/web"
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxImportSize is the maximum size of uploaded export file.
+	maxImportSize = 32 << 20
+
+	// importProgressExp is how long import progress is kept after the
+	// last update.
+	importProgressExp = 24 * time.Hour
+)
+
+// importItem is a single bookmark read from an export file.
+type importItem struct {
+	URL     string
+	Title   string
+	Note    string
+	Tags    []string
+	Created time.Time
+}
+
+// ImportProgress describes the state of a background import.
+type ImportProgress struct {
+	Format   string
+	Total    int
+	Imported int
+	Failed   int
+	Done     bool
+	Started  time.Time
+	Finished time.Time
+}
+
+// ImportHandler displays import form and starts importing bookmarks from
+// uploaded export file. Import is running in the background, and the
+// client is redirected to the progress page.
+func ImportHandler(
+	manager Manager,
+	archiver Archiver,
+	authSrv auth.AuthService,
+	cacheSrv cache.CacheService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == "GET" {
+			tmpl.Render(w, "import.tmpl", importContent{}, http.StatusOK)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			renderImportErr(w, tmpl, "Export file is required.")
+			return
+		}
+		defer file.Close()
+
+		format := r.FormValue("format")
+		items, err := parseImport(format, file)
+		if err != nil {
+			renderImportErr(w, tmpl, fmt.Sprintf("Cannot read %s export: %s", format, err))
+			return
+		}
+
+		jobID := randstr.New(8)
+		key := importProgressKey(user.AccountID, jobID)
+		progress := ImportProgress{
+			Format:  format,
+			Total:   len(items),
+			Started: time.Now(),
+		}
+		if err := cacheSrv.Set(r.Context(), key, &progress, importProgressExp); err != nil {
+			log.Printf("cannot store import progress: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		// import is running in the background, so it cannot use request
+		// context, which is cancelled once response is written
+		go runImport(context.Background(), manager, archiver, cacheSrv, key, user.AccountID, &progress, items)
+
+		http.Redirect(w, r, "/import/"+jobID, http.StatusSeeOther)
+	}
+}
+
+// importContent is rendered by import template, that displays both the
+// form and the progress of started import.
+type importContent struct {
+	Error    string
+	Progress *ImportProgress
+}
+
+func renderImportErr(w http.ResponseWriter, tmpl ui.Renderer, msg string) {
+	tmpl.Render(w, "import.tmpl", importContent{Error: msg}, http.StatusBadRequest)
+}
+
+// ImportProgressHandler displays progress of a background import.
+func ImportProgressHandler(
+	authSrv auth.AuthService,
+	cacheSrv cache.CacheService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		var progress ImportProgress
+		key := importProgressKey(user.AccountID, web.PathArg(r, 0))
+		switch err := cacheSrv.Get(r.Context(), key, &progress); err {
+		case nil:
+			// all good
+		case cache.ErrMiss:
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot get import progress: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		tmpl.Render(w, "import.tmpl", importContent{Progress: &progress}, http.StatusOK)
+	}
+}
+
+func importProgressKey(accountID int64, jobID string) string {
+	return fmt.Sprintf("stream:import:%d:%s", accountID, jobID)
+}
+
+// runImport bookmarks all given items, reporting progress to the cache.
+// Failure of a single item does not stop the import. Once all items are
+// processed, snapshots of imported pages are taken.
+func runImport(
+	ctx context.Context,
+	manager Manager,
+	archiver Archiver,
+	cacheSrv cache.CacheService,
+	key string,
+	accountID int64,
+	progress *ImportProgress,
+	items []*importItem,
+) {
+	// reporting progress after every item would make cache the bottleneck
+	const reportEvery = 25
+
+	for i, it := range items {
+		// shared tag feeds are updated once all bookmarks are
+		// imported, because update cost grows with bookmarks count
+		err := manager.Bookmark(ctx, accountID, it.URL, it.Title,
+			WithTags(it.Tags...),
+			WithNote(it.Note),
+			WithCreated(it.Created),
+			withoutTagSync())
+		if err != nil {
+			log.Printf("cannot import %q bookmark: %s", it.URL, err)
+			progress.Failed++
+		} else {
+			progress.Imported++
+		}
+		if (i+1)%reportEvery == 0 {
+			if err := cacheSrv.Set(ctx, key, progress, importProgressExp); err != nil {
+				log.Printf("cannot store import progress: %s", err)
+			}
+		}
+	}
+
+	if progress.Imported > 0 {
+		if err := manager.SyncTagFeeds(ctx, accountID); err != nil {
+			log.Printf("cannot sync %d account tag feeds: %s", accountID, err)
+		}
+	}
+
+	progress.Done = true
+	progress.Finished = time.Now()
+	if err := cacheSrv.Set(ctx, key, progress, importProgressExp); err != nil {
+		log.Printf("cannot store import progress: %s", err)
+	}
+
+	if progress.Imported > 0 {
+		archiveInBackground(archiver)
+	}
+}
+
+// parseImport returns bookmarks read from export file of given format.
+func parseImport(format string, r io.Reader) ([]*importItem, error) {
+	var (
+		items []*importItem
+		err   error
+	)
+	switch format {
+	case "netscape":
+		items, err = parseNetscape(r)
+	case "pocket":
+		items, err = parsePocket(r)
+	case "pinboard":
+		items, err = parsePinboard(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// only web pages can be bookmarked
+	valid := items[:0]
+	for _, it := range items {
+		u, err := url.Parse(it.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		valid = append(valid, it)
+	}
+	return valid, nil
+}
+
+// parseNetscape reads Netscape bookmark file, as exported by all browsers.
+// Description of the bookmark is used as a note.
+func parseNetscape(r io.Reader) ([]*importItem, error) {
+	var (
+		items []*importItem
+		last  *importItem
+		inA   bool
+		inDD  bool
+	)
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return nil, z.Err()
+			}
+			for _, it := range items {
+				it.Title = strings.TrimSpace(it.Title)
+				it.Note = strings.TrimSpace(it.Note)
+			}
+			return items, nil
+		case html.StartTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "a":
+				last = &importItem{}
+				for _, attr := range tok.Attr {
+					switch attr.Key {
+					case "href":
+						last.URL = attr.Val
+					case "add_date", "time_added":
+						last.Created = parseUnixTime(attr.Val)
+					case "tags":
+						last.Tags = splitTags(attr.Val, ",")
+					}
+				}
+				items = append(items, last)
+				inA, inDD = true, false
+			case "dd":
+				inDD = true
+			default:
+				inDD = false
+			}
+		case html.EndTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "a":
+				inA = false
+			case "dl":
+				inDD = false
+			}
+		case html.TextToken:
+			if last == nil {
+				continue
+			}
+			text := string(z.Text())
+			if inA {
+				last.Title += text
+			} else if inDD {
+				last.Note += text
+			}
+		}
+	}
+}
+
+// parsePocket reads Pocket export. Older exports are HTML documents in a
+// format close to Netscape bookmark file, newer are CSV files.
+func parsePocket(r io.Reader) ([]*importItem, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("<")) {
+		return parseNetscape(bytes.NewReader(b))
+	}
+
+	rd := csv.NewReader(bytes.NewReader(b))
+	rd.FieldsPerRecord = -1
+	header, err := rd.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read header: %s", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, fmt.Errorf(`missing "url" column`)
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var items []*importItem
+	for {
+		row, err := rd.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &importItem{
+			URL:     field(row, "url"),
+			Title:   field(row, "title"),
+			Tags:    splitTags(field(row, "tags"), "|"),
+			Created: parseUnixTime(field(row, "time_added")),
+		})
+	}
+}
+
+// parsePinboard reads Pinboard JSON export.
+func parsePinboard(r io.Reader) ([]*importItem, error) {
+	var posts []struct {
+		Href        string `json:"href"`
+		Description string `json:"description"`
+		Extended    string `json:"extended"`
+		Time        string `json:"time"`
+		Tags        string `json:"tags"`
+	}
+	if err := json.NewDecoder(r).Decode(&posts); err != nil {
+		return nil, err
+	}
+	items := make([]*importItem, 0, len(posts))
+	for _, p := range posts {
+		created, _ := time.Parse(time.RFC3339, p.Time)
+		items = append(items, &importItem{
+			URL:     p.Href,
+			Title:   p.Description,
+			Note:    p.Extended,
+			Tags:    strings.Fields(p.Tags),
+			Created: created,
+		})
+	}
+	return items, nil
+}
+
+// parseUnixTime returns time represented by given unix timestamp. Invalid
+// timestamp results in zero time.
+func parseUnixTime(s string) time.Time {
+	sec, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	// some browsers use milliseconds or microseconds precision
+	switch {
+	case sec > 1e14:
+		return time.Unix(0, sec*int64(time.Microsecond))
+	case sec > 1e11:
+		return time.Unix(0, sec*int64(time.Millisecond))
+	default:
+		return time.Unix(sec, 0)
+	}
+}
+
+func splitTags(s, sep string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, sep) {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
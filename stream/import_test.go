@@ -0,0 +1,171 @@
+package stream
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husio/feedstream/cache"
+)
+
+const netscapeExport = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1500000000">Folder</H3>
+    <DL><p>
+        <DT><A HREF="http://example.com/1" ADD_DATE="1500000001" TAGS="go,Web">First &amp; best</A>
+        <DD>Worth reading
+        <DT><A HREF="https://example.com/2" ADD_DATE="1500000002000">Second</A>
+    </DL><p>
+    <DT><A HREF="place:sort=8&maxResults=10">Most visited</A>
+</DL><p>
+`
+
+func TestParseNetscape(t *testing.T) {
+	items, err := parseImport("netscape", strings.NewReader(netscapeExport))
+	if err != nil {
+		t.Fatalf("cannot parse: %s", err)
+	}
+	want := []*importItem{
+		{
+			URL:     "http://example.com/1",
+			Title:   "First & best",
+			Note:    "Worth reading",
+			Tags:    []string{"go", "Web"},
+			Created: time.Unix(1500000001, 0),
+		},
+		{
+			URL:     "https://example.com/2",
+			Title:   "Second",
+			Created: time.Unix(1500000002, 0),
+		},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("want %+v, got %+v", want, items)
+	}
+}
+
+func TestParsePocket(t *testing.T) {
+	csvExport := "title,url,time_added,tags,status\n" +
+		"First,http://example.com/1,1500000001,go|web,unread\n" +
+		"Second,http://example.com/2,1500000002,,archive\n"
+	items, err := parseImport("pocket", strings.NewReader(csvExport))
+	if err != nil {
+		t.Fatalf("cannot parse csv: %s", err)
+	}
+	if len(items) != 2 || !reflect.DeepEqual(items[0].Tags, []string{"go", "web"}) || !items[1].Created.Equal(time.Unix(1500000002, 0)) {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+
+	htmlExport := `<!DOCTYPE html><html><body>
+		<h1>Unread</h1>
+		<ul><li><a href="http://example.com/1" time_added="1500000001" tags="go">First</a></li></ul>
+	</body></html>`
+	items, err = parseImport("pocket", strings.NewReader(htmlExport))
+	if err != nil {
+		t.Fatalf("cannot parse html: %s", err)
+	}
+	if len(items) != 1 || items[0].Title != "First" || !items[0].Created.Equal(time.Unix(1500000001, 0)) {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+}
+
+func TestParsePinboard(t *testing.T) {
+	export := `[
+		{"href": "http://example.com/1", "description": "First", "extended": "note", "time": "2017-07-14T02:40:01Z", "tags": "go web"}
+	]`
+	items, err := parseImport("pinboard", strings.NewReader(export))
+	if err != nil {
+		t.Fatalf("cannot parse: %s", err)
+	}
+	want := []*importItem{
+		{
+			URL:     "http://example.com/1",
+			Title:   "First",
+			Note:    "note",
+			Tags:    []string{"go", "web"},
+			Created: time.Date(2017, 7, 14, 2, 40, 1, 0, time.UTC),
+		},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("want %+v, got %+v", want, items)
+	}
+
+	if _, err := parseImport("delicious", strings.NewReader(export)); err == nil {
+		t.Fatal("want unknown format error")
+	}
+}
+
+type importManager struct {
+	stubManager
+	bookmarked []bookmarkOptions
+	synced     []int64
+}
+
+func (m *importManager) Bookmark(ctx context.Context, accountID int64, url, title string, opts ...BookmarkOption) error {
+	var o bookmarkOptions
+	for _, fn := range opts {
+		fn(&o)
+	}
+	m.bookmarked = append(m.bookmarked, o)
+	return nil
+}
+
+func (m *importManager) SyncTagFeeds(ctx context.Context, accountID int64) error {
+	m.synced = append(m.synced, accountID)
+	return nil
+}
+
+type importArchiver struct {
+	archived chan struct{}
+}
+
+func (a *importArchiver) ArchivePending(ctx context.Context) error {
+	close(a.archived)
+	return nil
+}
+
+func TestRunImport(t *testing.T) {
+	ctx := context.Background()
+	cacheSrv := cache.NewCacheService(cache.NewLocalMemCache())
+	m := &importManager{}
+	archiver := &importArchiver{archived: make(chan struct{})}
+
+	created := time.Unix(1500000001, 0)
+	items := []*importItem{
+		{URL: "http://example.com/1", Tags: []string{"go"}, Created: created},
+		{URL: "http://example.com/2", Note: "note"},
+	}
+	key := importProgressKey(1, "job")
+	runImport(ctx, m, archiver, cacheSrv, key, 1, &ImportProgress{Total: len(items)}, items)
+
+	if len(m.bookmarked) != 2 || !m.bookmarked[0].created.Equal(created) || m.bookmarked[1].note != "note" {
+		t.Fatalf("unexpected bookmarks: %+v", m.bookmarked)
+	}
+	for _, o := range m.bookmarked {
+		if !o.skipTagSync {
+			t.Fatalf("tag feeds synced for every bookmark: %+v", o)
+		}
+	}
+	if want := []int64{1}; !reflect.DeepEqual(m.synced, want) {
+		t.Fatalf("want tag feeds synced once, got %v", m.synced)
+	}
+
+	var progress ImportProgress
+	if err := cacheSrv.Get(ctx, key, &progress); err != nil {
+		t.Fatalf("cannot get progress: %s", err)
+	}
+	if !progress.Done || progress.Imported != 2 || progress.Total != 2 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+
+	select {
+	case <-archiver.archived:
+	case <-time.After(time.Second):
+		t.Fatal("imported bookmarks were not archived")
+	}
+}
@@ -2,26 +2,64 @@ package stream
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
-	"github.com/garyburd/redigo/redis"
+	"github.com/husio/feedstream/lock"
 	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/randstr"
+	"github.com/lib/pq"
 )
 
 type Manager interface {
 	Entries(ctx context.Context, accountID int64, publishedLte time.Time) ([]*Entry, error)
+	EntriesPage(ctx context.Context, accountID int64, q EntryQuery) ([]*Entry, error)
+	Entry(ctx context.Context, accountID, entryID int64) (*Entry, error)
+	EntryContent(ctx context.Context, accountID, entryID int64) (*Entry, error)
+	AdjacentEntries(ctx context.Context, accountID int64, e *Entry, feedID int64, tag string) (newer, older *Entry, err error)
+	EntriesByID(ctx context.Context, accountID int64, q EntryIDQuery) ([]*Entry, error)
+	EntryCount(ctx context.Context, accountID int64) (int64, error)
+	UnreadEntryIDs(ctx context.Context, accountID int64) ([]int64, error)
+	StarredEntryIDs(ctx context.Context, accountID int64) ([]int64, error)
 	FeedEntries(ctx context.Context, accountID, feedID int64, publishedLte time.Time) ([]*Entry, error)
 	Feed(ctx context.Context, feedID int64) (*Feed, error)
+	SubscribedFeed(ctx context.Context, accountID, feedID int64) (*Feed, error)
 	Subscriptions(ctx context.Context, accountID int64) ([]*Subscription, error)
 	Subscribe(ctx context.Context, accountID int64, feedUrl string) (int64, error)
 	Unsubscribe(ctx context.Context, subscriptionID, accountID int64) error
 	Update(ctx context.Context, feedID int64) error
 	OutdatedFeeds(ctx context.Context, updatedLte time.Time) ([]int64, error)
-	Bookmark(ctx context.Context, accountID int64, url, title string) error
+	MarkRead(ctx context.Context, accountID int64, entryIDs []int64, read bool) error
+	MarkStarred(ctx context.Context, accountID int64, entryIDs []int64, starred bool) error
+	MarkAllRead(ctx context.Context, accountID, feedID int64, publishedLte time.Time) error
+	UnreadCounts(ctx context.Context, accountID int64) ([]*UnreadCount, error)
+	Bookmark(ctx context.Context, accountID int64, url, title string, opts ...BookmarkOption) error
+	Bookmarks(ctx context.Context, accountID int64) ([]*Entry, error)
+	TaggedBookmarks(ctx context.Context, accountID int64, tag string, limit int) ([]*Entry, error)
+	BookmarkEntry(ctx context.Context, accountID, entryID int64) (*Entry, error)
+	EditBookmark(ctx context.Context, accountID, entryID int64, tags []string, note string) error
+	SyncTagFeeds(ctx context.Context, accountID int64) error
+	Tags(ctx context.Context, accountID int64) ([]*TagCount, error)
+	EntryStates(ctx context.Context, accountID int64) ([]*EntryState, error)
+	FeedToken(ctx context.Context, accountID int64) (string, error)
+	ResetFeedToken(ctx context.Context, accountID int64) (string, error)
+	FeedTokenAccount(ctx context.Context, token string) (int64, error)
+	Share(ctx context.Context, accountID int64, title, tag string) (*Share, error)
+	Shares(ctx context.Context, accountID int64) ([]*Share, error)
+	ShareByToken(ctx context.Context, token string) (*Share, error)
+	Unshare(ctx context.Context, accountID, shareID int64) error
+	PendingArchives(ctx context.Context, limit int) ([]*Archive, error)
+	SaveArchive(ctx context.Context, a *Archive) error
+	Archive(ctx context.Context, accountID, entryID int64) (*Archive, error)
+	Archives(ctx context.Context, accountID int64) ([]*Archive, error)
 }
 
 type Entry struct {
@@ -36,6 +74,23 @@ type Entry struct {
 	URL            string
 	Published      time.Time
 	Created        time.Time
+
+	// Read and Starred are set only by queries that include entry state
+	// of the account.
+	Read    bool
+	Starred bool
+
+	// Tags, Note and Selection are set only for bookmarks.
+	Tags      pq.StringArray
+	Note      string
+	Selection string
+	// Archived is true if snapshot of the bookmarked page is available.
+	Archived bool
+
+	// Content is the article HTML, as provided by the feed or extracted
+	// from the page. It is not sanitized and set only for single entry
+	// queries and queries that request it.
+	Content string
 }
 
 func (e *Entry) URLHost() string {
@@ -64,6 +119,17 @@ func (e *Entry) ReadingTime() string {
 	}
 }
 
+// EntryState represents read and starred state of an entry, as marked by
+// single account.
+type EntryState struct {
+	EntryID int64 `db:"entry_id"`
+	Title   string
+	URL     string
+	Read    bool
+	Starred bool
+	Updated time.Time
+}
+
 type Subscription struct {
 	SubscriptionID int64  `db:"subscription_id"`
 	FeedID         int64  `db:"feed_id"`
@@ -85,33 +151,77 @@ func (s *Subscription) Host() string {
 }
 
 type Feed struct {
-	FeedID     int64 `db:"feed_id"`
-	Title      string
-	FaviconURL string `db:"favicon_url"`
-	URL        string
-	Updated    time.Time
-	OwnedBy    int64 `db:"owned_by"`
+	FeedID      int64 `db:"feed_id"`
+	Title       string
+	FaviconURL  string `db:"favicon_url"`
+	URL         string
+	Updated     time.Time
+	OwnedBy     int64 `db:"owned_by"`
+	Autorefresh bool
+}
+
+// Share makes bookmark feed of an account public. Shared feed is available
+// to anyone who knows its token and can be subscribed to by other accounts.
+type Share struct {
+	ShareID   int64 `db:"share_id"`
+	AccountID int64 `db:"account_id"`
+	FeedID    int64 `db:"feed_id"`
+	Token     string
+	Title     string
+	// Tag limits shared bookmarks to those with given tag. Empty tag
+	// shares all bookmarks.
+	Tag     string
+	Created time.Time
+}
+
+// Path returns absolute path of the public page of the share.
+func (s *Share) Path() string {
+	return "/s/" + s.Token
+}
+
+// Archive is a snapshot of a bookmarked page, kept so that the page can be
+// read after the original disappears. Content of the snapshot is kept in a
+// blob store.
+type Archive struct {
+	EntryID   int64  `db:"entry_id"`
+	AccountID int64  `db:"account_id"`
+	BlobKey   string `db:"blob_key"`
+	URL       string
+	Size      int64
+	// Error describes why snapshot could not be taken. Failed snapshots
+	// are not retried.
+	Error   string
+	Created time.Time
 }
 
 type manager struct {
 	db        pg.Database
-	rp        *redis.Pool
+	locker    lock.Locker
 	newspaper NewspaperService
+	// siteHost is the host of this site, used to recognize local shares.
+	siteHost string
 }
 
 var _ Manager = (*manager)(nil)
 
-func NewManager(db pg.Database, rp *redis.Pool, n NewspaperService) Manager {
+// NewManager returns manager using given database. Site is the public URL
+// of this site, under which shares are available.
+func NewManager(db pg.Database, locker lock.Locker, n NewspaperService, site string) Manager {
+	var siteHost string
+	if u, err := url.Parse(site); err == nil {
+		siteHost = u.Host
+	}
 	return &manager{
 		db:        db,
-		rp:        rp,
+		locker:    locker,
 		newspaper: n,
+		siteHost:  siteHost,
 	}
 }
 
 func (m *manager) Entries(ctx context.Context, accountID int64, publishedLte time.Time) ([]*Entry, error) {
 	var entries []*Entry
-	err := m.db.Select(&entries, `
+	err := m.db.SelectContext(ctx, &entries, `
 		SELECT
 			e.entry_id,
 			e.feed_id,
@@ -122,11 +232,15 @@ func (m *manager) Entries(ctx context.Context, accountID int64, publishedLte tim
 			e.word_count,
 			f.owned_by AS feed_owned_by,
 			f.title AS feed_title,
-			f.favicon_url AS feed_favicon_url
+			f.favicon_url AS feed_favicon_url,
+			COALESCE(b.tags, '{}') AS tags,
+			COALESCE(a.error = '', false) AS archived
 		FROM
 			entries e
 			INNER JOIN feeds f ON e.feed_id = f.feed_id
 			INNER JOIN subscriptions s ON s.feed_id = f.feed_id
+			LEFT JOIN bookmarks b ON b.entry_id = e.entry_id AND b.account_id = s.account_id
+			LEFT JOIN archives a ON a.entry_id = b.entry_id
 		WHERE
 			s.account_id = $1
 			AND e.published <= $2
@@ -137,9 +251,295 @@ func (m *manager) Entries(ctx context.Context, accountID int64, publishedLte tim
 	return entries, err
 }
 
+// EntryQuery describes single page of entries.
+type EntryQuery struct {
+	// FeedID limits results to entries of a single feed. Zero means all
+	// subscribed feeds.
+	FeedID int64
+	// Before is the position after which the page starts. Zero value
+	// means the first page.
+	Before Cursor
+	// PublishedAfter limits results to entries published after given
+	// time. Zero value means no limit.
+	PublishedAfter time.Time
+	Unread         bool
+	Starred        bool
+	Limit          int
+	// WithContent selects content of the entries as well.
+	WithContent bool
+}
+
+// EntriesPage returns entries of subscribed feeds, ordered by publication
+// time, newest first. Use cursor of the last returned entry to fetch next
+// page.
+func (m *manager) EntriesPage(ctx context.Context, accountID int64, q EntryQuery) ([]*Entry, error) {
+	limit := q.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	var entries []*Entry
+	err := m.db.SelectContext(ctx, &entries, `
+		SELECT
+			e.entry_id,
+			e.feed_id,
+			e.title,
+			e.url,
+			e.word_count,
+			e.published,
+			e.created,
+			f.owned_by AS feed_owned_by,
+			f.title AS feed_title,
+			f.favicon_url AS feed_favicon_url,
+			COALESCE(st.read, false) AS read,
+			COALESCE(st.starred, false) AS starred,
+			CASE WHEN $10 THEN e.content ELSE '' END AS content
+		FROM
+			entries e
+			INNER JOIN feeds f ON e.feed_id = f.feed_id
+			INNER JOIN subscriptions s ON s.feed_id = f.feed_id
+			LEFT JOIN entry_states st ON st.entry_id = e.entry_id AND st.account_id = s.account_id
+		WHERE
+			s.account_id = $1
+			AND ($2 OR (e.published, e.entry_id) < ($3, $4::bigint))
+			AND ($5 = 0 OR e.feed_id = $5)
+			AND e.published > $6
+			AND (NOT $7 OR NOT COALESCE(st.read, false))
+			AND (NOT $8 OR COALESCE(st.starred, false))
+		ORDER BY
+			e.published DESC, e.entry_id DESC
+		LIMIT $9
+	`, accountID, q.Before.IsZero(), q.Before.Published, q.Before.EntryID, q.FeedID, q.PublishedAfter, q.Unread, q.Starred, limit, q.WithContent)
+	return entries, err
+}
+
+// Entry returns single entry, if it belongs to any of subscribed feeds. It
+// returns pg.ErrNotFound if entry does not exist or is not visible to given
+// account.
+func (m *manager) Entry(ctx context.Context, accountID, entryID int64) (*Entry, error) {
+	var e Entry
+	err := m.db.GetContext(ctx, &e, `
+		SELECT
+			e.entry_id,
+			e.feed_id,
+			e.title,
+			e.url,
+			e.word_count,
+			e.published,
+			e.created,
+			f.owned_by AS feed_owned_by,
+			f.title AS feed_title,
+			f.favicon_url AS feed_favicon_url,
+			COALESCE(st.read, false) AS read,
+			COALESCE(st.starred, false) AS starred,
+			COALESCE(a.error = '', false) AS archived,
+			e.content
+		FROM
+			entries e
+			INNER JOIN feeds f ON e.feed_id = f.feed_id
+			INNER JOIN subscriptions s ON s.feed_id = f.feed_id
+			LEFT JOIN entry_states st ON st.entry_id = e.entry_id AND st.account_id = s.account_id
+			LEFT JOIN archives a ON a.entry_id = e.entry_id AND a.account_id = s.account_id
+		WHERE
+			s.account_id = $1
+			AND e.entry_id = $2
+		LIMIT 1
+	`, accountID, entryID)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// EntryContent returns single entry together with its content, if it
+// belongs to any of subscribed feeds. If content was not provided by the
+// feed, it is extracted from the article page and stored for later use.
+func (m *manager) EntryContent(ctx context.Context, accountID, entryID int64) (*Entry, error) {
+	e, err := m.Entry(ctx, accountID, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if e.Content != "" {
+		return e, nil
+	}
+
+	meta, err := m.newspaper.Article(ctx, e.URL)
+	if err != nil {
+		log.Printf("cannot fetch article %q: %s", e.URL, err)
+		return e, nil
+	}
+	e.Content = textToHTML(meta.Text)
+	if e.WordCount == 0 {
+		e.WordCount = len(strings.Fields(meta.Text))
+	}
+	_, err = m.db.ExecContext(ctx, `
+		UPDATE entries SET content = $2, word_count = $3 WHERE entry_id = $1
+	`, e.EntryID, e.Content, e.WordCount)
+	if err != nil {
+		return nil, fmt.Errorf("cannot store content: %s", err)
+	}
+	return e, nil
+}
+
+// AdjacentEntries returns entries directly newer and older than given one
+// within a stream. Stream contains entries of a single feed if feed ID is
+// not zero, bookmarks with given tag if tag is not empty, or entries of all
+// subscribed feeds otherwise. Nil is returned if there is no entry on
+// either side.
+func (m *manager) AdjacentEntries(ctx context.Context, accountID int64, e *Entry, feedID int64, tag string) (newer, older *Entry, err error) {
+	adjacent := func(cmp, order string) (*Entry, error) {
+		var adj Entry
+		err := m.db.GetContext(ctx, &adj, fmt.Sprintf(`
+			SELECT
+				e.entry_id,
+				e.feed_id,
+				e.title,
+				e.url,
+				e.published,
+				e.created
+			FROM
+				entries e
+				INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+				LEFT JOIN bookmarks b ON b.entry_id = e.entry_id AND b.account_id = s.account_id
+			WHERE
+				s.account_id = $1
+				AND ($2 = 0 OR e.feed_id = $2)
+				AND ($3::text = '' OR $3 = ANY(b.tags))
+				AND (e.published, e.entry_id) %s ($4, $5)
+			ORDER BY
+				e.published %s, e.entry_id %s
+			LIMIT 1
+		`, cmp, order, order), accountID, feedID, tag, e.Published, e.EntryID)
+		switch err {
+		case nil:
+			return &adj, nil
+		case pg.ErrNotFound:
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	if newer, err = adjacent(">", "ASC"); err != nil {
+		return nil, nil, fmt.Errorf("cannot get newer entry: %s", err)
+	}
+	if older, err = adjacent("<", "DESC"); err != nil {
+		return nil, nil, fmt.Errorf("cannot get older entry: %s", err)
+	}
+	return newer, older, nil
+}
+
+// EntryIDQuery describes entries selected by their IDs.
+type EntryIDQuery struct {
+	// SinceID and MaxID limit results to entries with ID greater than
+	// SinceID and lower than MaxID. Zero means no limit.
+	SinceID int64
+	MaxID   int64
+	// IDs limits results to given entries. Nil means no limit.
+	IDs []int64
+	// Ascending orders results by ID, lowest first. By default, highest
+	// ID is returned first.
+	Ascending bool
+	Limit     int
+	// WithContent selects content of the entries as well.
+	WithContent bool
+}
+
+// EntriesByID returns entries of subscribed feeds, ordered by their ID.
+func (m *manager) EntriesByID(ctx context.Context, accountID int64, q EntryIDQuery) ([]*Entry, error) {
+	limit := q.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	var ids interface{}
+	if q.IDs != nil {
+		ids = pq.Array(q.IDs)
+	}
+
+	var entries []*Entry
+	err := m.db.SelectContext(ctx, &entries, `
+		SELECT
+			e.entry_id,
+			e.feed_id,
+			e.title,
+			e.url,
+			e.word_count,
+			e.published,
+			e.created,
+			f.owned_by AS feed_owned_by,
+			f.title AS feed_title,
+			f.favicon_url AS feed_favicon_url,
+			COALESCE(st.read, false) AS read,
+			COALESCE(st.starred, false) AS starred,
+			CASE WHEN $7 THEN e.content ELSE '' END AS content
+		FROM
+			entries e
+			INNER JOIN feeds f ON e.feed_id = f.feed_id
+			INNER JOIN subscriptions s ON s.feed_id = f.feed_id
+			LEFT JOIN entry_states st ON st.entry_id = e.entry_id AND st.account_id = s.account_id
+		WHERE
+			s.account_id = $1
+			AND e.entry_id > $2
+			AND ($3 = 0 OR e.entry_id < $3)
+			AND ($4::integer[] IS NULL OR e.entry_id = ANY($4))
+		ORDER BY
+			CASE WHEN $5 THEN e.entry_id END ASC,
+			e.entry_id DESC
+		LIMIT $6
+	`, accountID, q.SinceID, q.MaxID, ids, q.Ascending, limit, q.WithContent)
+	return entries, err
+}
+
+// EntryCount returns the number of entries of all subscribed feeds.
+func (m *manager) EntryCount(ctx context.Context, accountID int64) (int64, error) {
+	var count int64
+	err := m.db.GetContext(ctx, &count, `
+		SELECT COUNT(*)
+		FROM
+			entries e
+			INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+		WHERE
+			s.account_id = $1
+	`, accountID)
+	return count, err
+}
+
+// UnreadEntryIDs returns IDs of all not read entries of subscribed feeds.
+func (m *manager) UnreadEntryIDs(ctx context.Context, accountID int64) ([]int64, error) {
+	var ids []int64
+	err := m.db.SelectContext(ctx, &ids, `
+		SELECT e.entry_id
+		FROM
+			entries e
+			INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+			LEFT JOIN entry_states st ON st.entry_id = e.entry_id AND st.account_id = s.account_id
+		WHERE
+			s.account_id = $1
+			AND NOT COALESCE(st.read, false)
+		ORDER BY e.entry_id ASC
+	`, accountID)
+	return ids, err
+}
+
+// StarredEntryIDs returns IDs of all starred entries of subscribed feeds.
+func (m *manager) StarredEntryIDs(ctx context.Context, accountID int64) ([]int64, error) {
+	var ids []int64
+	err := m.db.SelectContext(ctx, &ids, `
+		SELECT e.entry_id
+		FROM
+			entries e
+			INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+			INNER JOIN entry_states st ON st.entry_id = e.entry_id AND st.account_id = s.account_id
+		WHERE
+			s.account_id = $1
+			AND st.starred
+		ORDER BY e.entry_id ASC
+	`, accountID)
+	return ids, err
+}
+
 func (m *manager) FeedEntries(ctx context.Context, accountID, feedID int64, publishedLte time.Time) ([]*Entry, error) {
 	var entries []*Entry
-	err := m.db.Select(&entries, `
+	err := m.db.SelectContext(ctx, &entries, `
 		SELECT
 			e.entry_id,
 			e.feed_id,
@@ -150,11 +550,15 @@ func (m *manager) FeedEntries(ctx context.Context, accountID, feedID int64, publ
 			e.created,
 			f.owned_by AS feed_owned_by,
 			f.title AS feed_title,
-			f.favicon_url AS feed_favicon_url
+			f.favicon_url AS feed_favicon_url,
+			COALESCE(b.tags, '{}') AS tags,
+			COALESCE(a.error = '', false) AS archived
 		FROM
 			entries e
 			INNER JOIN feeds f ON e.feed_id = f.feed_id
 			INNER JOIN subscriptions s ON s.feed_id = f.feed_id
+			LEFT JOIN bookmarks b ON b.entry_id = e.entry_id AND b.account_id = s.account_id
+			LEFT JOIN archives a ON a.entry_id = b.entry_id
 		WHERE
 			s.account_id = $1
 			AND e.published <= $2
@@ -168,7 +572,7 @@ func (m *manager) FeedEntries(ctx context.Context, accountID, feedID int64, publ
 
 func (m *manager) Subscriptions(ctx context.Context, accountID int64) ([]*Subscription, error) {
 	var subs []*Subscription
-	err := m.db.Select(&subs, `
+	err := m.db.SelectContext(ctx, &subs, `
 		SELECT
 			s.subscription_id,
 			s.feed_id,
@@ -192,6 +596,18 @@ func (m *manager) Subscriptions(ctx context.Context, accountID int64) ([]*Subscr
 }
 
 func (m *manager) Subscribe(ctx context.Context, accountID int64, feedUrl string) (int64, error) {
+	// shared feeds are local, so there is nothing to fetch
+	if token, ok := shareToken(feedUrl, m.siteHost); ok {
+		switch share, err := m.ShareByToken(ctx, token); err {
+		case nil:
+			return m.subscribeShare(ctx, accountID, share)
+		case pg.ErrNotFound:
+			// not a share, handle as any other feed
+		default:
+			return 0, fmt.Errorf("cannot get share: %s", err)
+		}
+	}
+
 	// before adding to database, test if given url can be trusted and
 	// points to feed
 	if _, err := fetchFeed(ctx, feedUrl); err != nil {
@@ -207,56 +623,101 @@ func (m *manager) Subscribe(ctx context.Context, accountID int64, feedUrl string
 	} else {
 		title = feedUrl
 	}
-	err := m.db.Get(&feedID, `
+	err := m.db.GetContext(ctx, &feedID, `
 		SELECT subscribe($1, $2, $3, $4)
 	`, accountID, feedUrl, title, time.Now())
 	return feedID, err
 }
 
+func (m *manager) subscribeShare(ctx context.Context, accountID int64, share *Share) (int64, error) {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO subscriptions (feed_id, account_id, created)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, share.FeedID, accountID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return share.FeedID, nil
+}
+
+var sharePathRx = regexp.MustCompile(`^/s/([0-9a-f]+)(\.atom)?$`)
+
+// shareToken returns share token if given url points to a share page or
+// feed of this site. Share URL of any other host is a remote feed.
+func shareToken(rawurl, siteHost string) (string, bool) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", false
+	}
+	if u.Host != "" && !strings.EqualFold(u.Host, siteHost) {
+		return "", false
+	}
+	m := sharePathRx.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
 func (m *manager) Feed(ctx context.Context, feedID int64) (*Feed, error) {
 	var f Feed
-	err := m.db.Get(&f, `
+	err := m.db.GetContext(ctx, &f, `
 		SELECT * FROM feeds WHERE feed_id = $1
 		LIMIT 1
 	`, feedID)
 	return &f, err
 }
 
-func (m *manager) Update(ctx context.Context, feedID int64) error {
-	rc := m.rp.Get()
-	defer rc.Close()
-
-	// prevent from updates happening too often using lock with expiration
-	lockKey := fmt.Sprintf("manager.Update:%d", feedID)
-	switch _, err := rc.Do("SET", lockKey, "1", "EX", 30, "NX"); err {
-	case redis.ErrNil:
-		return nil // lock not released
-	case nil:
-		// all good
-	default:
-		return fmt.Errorf("cannot lock update: %s", err)
+// SubscribedFeed returns feed, if given account is subscribed to it. It
+// returns pg.ErrNotFound otherwise.
+func (m *manager) SubscribedFeed(ctx context.Context, accountID, feedID int64) (*Feed, error) {
+	var f Feed
+	err := m.db.GetContext(ctx, &f, `
+		SELECT f.*
+		FROM
+			feeds f
+			INNER JOIN subscriptions s ON s.feed_id = f.feed_id
+		WHERE
+			s.account_id = $1
+			AND f.feed_id = $2
+		LIMIT 1
+	`, accountID, feedID)
+	if err != nil {
+		return nil, err
 	}
+	return &f, nil
+}
 
-	tx, err := m.db.Beginx()
-	if err != nil {
-		return fmt.Errorf("cannot start transaction: %s", err)
+func (m *manager) Update(ctx context.Context, feedID int64) error {
+	// prevent the same feed from being updated by many workers at once
+	lockKey := fmt.Sprintf("manager.Update:%d", feedID)
+	err := lock.Run(ctx, m.locker, lockKey, 30*time.Second, func(ctx context.Context) error {
+		return m.update(ctx, feedID)
+	})
+	if err == lock.ErrLocked {
+		return nil // already being updated
 	}
-	defer tx.Rollback()
+	return err
+}
 
+func (m *manager) update(ctx context.Context, feedID int64) error {
 	var feed struct {
 		FeedID     int64  `db:"feed_id"`
 		FaviconURL string `db:"favicon_url"`
+		OwnedBy    int64  `db:"owned_by"`
 		Title      string
 		URL        string
 		Updated    time.Time
 	}
 
-	err = tx.Get(&feed, `
+	err := m.db.GetContext(ctx, &feed, `
 		SELECT
 			feed_id,
 			url,
 			title,
 			favicon_url,
+			owned_by,
 			updated
 		FROM feeds
 		WHERE feed_id = $1
@@ -265,6 +726,10 @@ func (m *manager) Update(ctx context.Context, feedID int64) error {
 	if err != nil {
 		return fmt.Errorf("cannot fetch feed: %s", err)
 	}
+	if feed.OwnedBy != 0 {
+		// owned feeds are local and have nothing to fetch
+		return nil
+	}
 
 	fi, err := fetchFeed(ctx, feed.URL)
 	if err != nil {
@@ -282,48 +747,76 @@ func (m *manager) Update(ctx context.Context, feedID int64) error {
 		}
 	}
 
-	now := time.Now()
-	_, err = tx.Exec(`
-		UPDATE feeds
-		SET title = $1, updated = $2, favicon_url = $3
-		WHERE feed_id = $4
-	`, feed.Title, now, feed.FaviconURL, feedID)
-	if err != nil {
-		return fmt.Errorf("cannot update feed: %s", err)
-	}
-
+	var entries []*Entry
 	for _, entry := range fi.Entries() {
 		if entry.Published.Before(feed.Updated) {
 			continue
 		}
 
-		var wordCnt int
 		meta, err := m.newspaper.Article(ctx, entry.URL)
 		if err != nil {
 			log.Printf("cannot fetch article %q: %s", entry.URL, err)
 		} else {
-			wordCnt = len(strings.Fields(meta.Text))
+			entry.WordCount = len(strings.Fields(meta.Text))
+			if entry.Content == "" {
+				entry.Content = textToHTML(meta.Text)
+			}
 		}
+		entries = append(entries, entry)
+	}
 
-		_, err = tx.Exec(`
-			INSERT INTO entries (feed_id, title, url, published, created, word_count)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			ON CONFLICT DO NOTHING
-		`, feed.FeedID, entry.Title, entry.URL, entry.Published, now, wordCnt)
+	// all data is fetched before starting the transaction, because it
+	// might be retried
+	opts := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	return pg.WithTx(ctx, m.db, opts, func(tx pg.Connection) error {
+		now := time.Now()
+		_, err := tx.ExecContext(ctx, `
+			UPDATE feeds
+			SET title = $1, updated = $2, favicon_url = $3
+			WHERE feed_id = $4
+		`, feed.Title, now, feed.FaviconURL, feedID)
 		if err != nil {
-			return fmt.Errorf("cannot insert entry: %s", err)
+			return fmt.Errorf("cannot update feed: %s", err)
 		}
-	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("cannot commit transaction: %s", err)
+		var inserted int64
+		for _, entry := range entries {
+			res, err := tx.ExecContext(ctx, `
+				INSERT INTO entries (feed_id, title, url, published, created, word_count, content)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT DO NOTHING
+			`, feed.FeedID, entry.Title, entry.URL, entry.Published, now, entry.WordCount, entry.Content)
+			if err != nil {
+				return fmt.Errorf("cannot insert entry: %s", err)
+			}
+			if n, err := res.RowsAffected(); err == nil {
+				inserted += n
+			}
+		}
+		return notifyEntries(ctx, tx, feed.FeedID, inserted)
+	})
+}
+
+// notifyEntries sends notification about new entries of given feed. Because
+// notification is send within transaction, it is delivered only after
+// commit.
+func notifyEntries(ctx context.Context, e pg.Execer, feedID, count int64) error {
+	if count == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(EntriesEvent{FeedID: feedID, Entries: count})
+	if err != nil {
+		return fmt.Errorf("cannot serialize notification: %s", err)
+	}
+	if _, err := e.ExecContext(ctx, `SELECT pg_notify($1, $2)`, entriesChannel, string(payload)); err != nil {
+		return fmt.Errorf("cannot notify: %s", err)
 	}
 	return nil
 }
 
 func (m *manager) OutdatedFeeds(ctx context.Context, updatedLte time.Time) ([]int64, error) {
 	var ids []int64
-	err := m.db.Select(&ids, `
+	err := m.db.SelectContext(ctx, &ids, `
 		SELECT feed_id
 		FROM feeds
 		WHERE updated <= $1 AND autorefresh = true
@@ -332,63 +825,783 @@ func (m *manager) OutdatedFeeds(ctx context.Context, updatedLte time.Time) ([]in
 	return ids, err
 }
 
+// Unsubscribe removes subscription of given account. It returns
+// pg.ErrNotFound if the account has no such subscription.
 func (m *manager) Unsubscribe(ctx context.Context, subID, accID int64) error {
-	_, err := m.db.Exec(`
+	res, err := m.db.ExecContext(ctx, `
 		DELETE FROM subscriptions
 		WHERE account_id = $1 AND subscription_id = $2
 	`, accID, subID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return pg.ErrNotFound
+	}
+	return nil
+}
+
+// MarkRead sets read state of given entries. Entries of feeds that account
+// is not subscribed to are ignored.
+func (m *manager) MarkRead(ctx context.Context, accountID int64, entryIDs []int64, read bool) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO entry_states (account_id, entry_id, read, starred, updated)
+			SELECT $1, e.entry_id, $3, false, $4
+			FROM
+				entries e
+				INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+			WHERE
+				s.account_id = $1
+				AND e.entry_id = ANY($2)
+		ON CONFLICT (account_id, entry_id) DO UPDATE SET
+			read = EXCLUDED.read,
+			updated = EXCLUDED.updated
+	`, accountID, pq.Array(entryIDs), read, time.Now())
+	return err
+}
+
+// MarkStarred sets starred state of given entries. Entries of feeds that
+// account is not subscribed to are ignored.
+func (m *manager) MarkStarred(ctx context.Context, accountID int64, entryIDs []int64, starred bool) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO entry_states (account_id, entry_id, read, starred, updated)
+			SELECT $1, e.entry_id, false, $3, $4
+			FROM
+				entries e
+				INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+			WHERE
+				s.account_id = $1
+				AND e.entry_id = ANY($2)
+		ON CONFLICT (account_id, entry_id) DO UPDATE SET
+			starred = EXCLUDED.starred,
+			updated = EXCLUDED.updated
+	`, accountID, pq.Array(entryIDs), starred, time.Now())
+	return err
+}
+
+// MarkAllRead marks as read all entries of given feed, published not later
+// than given time. If feed ID is zero, entries of all subscribed feeds are
+// marked.
+func (m *manager) MarkAllRead(ctx context.Context, accountID, feedID int64, publishedLte time.Time) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO entry_states (account_id, entry_id, read, starred, updated)
+			SELECT $1, e.entry_id, true, false, $4
+			FROM
+				entries e
+				INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+			WHERE
+				s.account_id = $1
+				AND ($2 = 0 OR e.feed_id = $2)
+				AND e.published <= $3
+		ON CONFLICT (account_id, entry_id) DO UPDATE SET
+			read = true,
+			updated = EXCLUDED.updated
+		WHERE NOT entry_states.read
+	`, accountID, feedID, publishedLte, time.Now())
 	return err
 }
 
-func (m *manager) Bookmark(ctx context.Context, accountID int64, url, title string) error {
+// UnreadCount represents number of not read entries of a single feed.
+type UnreadCount struct {
+	FeedID int64 `db:"feed_id"`
+	Count  int64
+	Newest time.Time
+}
+
+// UnreadCounts returns number of not read entries for every subscribed
+// feed that has any.
+func (m *manager) UnreadCounts(ctx context.Context, accountID int64) ([]*UnreadCount, error) {
+	var counts []*UnreadCount
+	err := m.db.SelectContext(ctx, &counts, `
+		SELECT
+			e.feed_id,
+			COUNT(*) AS count,
+			MAX(e.published) AS newest
+		FROM
+			entries e
+			INNER JOIN subscriptions s ON s.feed_id = e.feed_id
+			LEFT JOIN entry_states st ON st.entry_id = e.entry_id AND st.account_id = s.account_id
+		WHERE
+			s.account_id = $1
+			AND NOT COALESCE(st.read, false)
+		GROUP BY e.feed_id
+	`, accountID)
+	return counts, err
+}
+
+// BookmarkOption configures bookmark created by Manager.Bookmark.
+type BookmarkOption func(*bookmarkOptions)
+
+type bookmarkOptions struct {
+	tags        []string
+	note        string
+	selection   string
+	created     time.Time
+	skipTagSync bool
+}
+
+// WithTags adds given tags to the bookmark. Tags are merged with those
+// that bookmark already has.
+func WithTags(tags ...string) BookmarkOption {
+	return func(o *bookmarkOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// WithNote sets free text note of the bookmark.
+func WithNote(note string) BookmarkOption {
+	return func(o *bookmarkOptions) {
+		o.note = note
+	}
+}
+
+// WithSelection sets text that was selected on the page when it was
+// bookmarked.
+func WithSelection(text string) BookmarkOption {
+	return func(o *bookmarkOptions) {
+		o.selection = text
+	}
+}
+
+// WithCreated sets the time page was bookmarked at. By default current
+// time is used.
+func WithCreated(t time.Time) BookmarkOption {
+	return func(o *bookmarkOptions) {
+		o.created = t
+	}
+}
+
+// withoutTagSync does not update shared tag feeds. It is meant for
+// creating many bookmarks at once, in which case Manager.SyncTagFeeds must
+// be called once all bookmarks are created.
+func withoutTagSync() BookmarkOption {
+	return func(o *bookmarkOptions) {
+		o.skipTagSync = true
+	}
+}
+
+func (m *manager) Bookmark(ctx context.Context, accountID int64, url, title string, opts ...BookmarkOption) error {
 	if title == "" {
 		title = url
 	}
-	tx, err := m.db.Beginx()
-	if err != nil {
-		return fmt.Errorf("cannot start transaction: %s", err)
+	var o bookmarkOptions
+	for _, fn := range opts {
+		fn(&o)
 	}
-	defer tx.Rollback()
 
-	feedUrl := fmt.Sprintf("/?feed=%d", accountID)
-	now := time.Now()
+	return pg.WithTx(ctx, m.db, nil, func(tx pg.Connection) error {
+		now := time.Now()
+		created := now
+		if !o.created.IsZero() {
+			created = o.created
+		}
 
-	_, err = tx.Exec(`
-		INSERT INTO feeds (url, updated, owned_by, title, favicon_url)
-		VALUES ($1, $2, $3, 'Bookmarks', '/static/bookmark.png')
+		feedID, err := ensureBookmarkFeed(ctx, tx, accountID, now)
+		if err != nil {
+			return err
+		}
+		var entryID int64
+		err = tx.GetContext(ctx, &entryID, `
+			INSERT INTO entries (feed_id, title, url, created, published, word_count)
+			VALUES ($1, $2, $3, $4, $4, $5)
+			ON CONFLICT (feed_id, url) DO UPDATE SET
+				published = $4,
+				title = $2,
+				word_count = $5
+			RETURNING entry_id
+		`, feedID, title, url, created, 0) // TODO
+		if err != nil {
+			return fmt.Errorf("cannot insert bookmark: %s", err)
+		}
+		// bookmarking the same page again must not lose details that
+		// were provided before
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO bookmarks (entry_id, account_id, tags, note, selection, updated)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (entry_id) DO UPDATE SET
+				tags = ARRAY(SELECT DISTINCT unnest(bookmarks.tags || EXCLUDED.tags) ORDER BY 1),
+				note = CASE WHEN EXCLUDED.note = '' THEN bookmarks.note ELSE EXCLUDED.note END,
+				selection = CASE WHEN EXCLUDED.selection = '' THEN bookmarks.selection ELSE EXCLUDED.selection END,
+				updated = EXCLUDED.updated
+		`, entryID, accountID, pq.Array(normalizeTags(o.tags)), o.note, o.selection, now)
+		if err != nil {
+			return fmt.Errorf("cannot insert bookmark details: %s", err)
+		}
+		if !o.skipTagSync {
+			if err := syncTagFeeds(ctx, tx, accountID); err != nil {
+				return err
+			}
+		}
+		return notifyEntries(ctx, tx, feedID, 1)
+	})
+}
+
+// ensureBookmarkFeed creates bookmark feed of given account, together with
+// the account subscription to it, unless it already exists. ID of the
+// bookmark feed is returned.
+func ensureBookmarkFeed(ctx context.Context, tx pg.Connection, accountID int64, now time.Time) (int64, error) {
+	feedUrl := fmt.Sprintf("/?feed=%d", accountID)
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO feeds (url, updated, owned_by, title, favicon_url, autorefresh)
+		VALUES ($1, $2, $3, 'Bookmarks', '/static/bookmark.png', false)
 		ON CONFLICT DO NOTHING
 	`, feedUrl, now, accountID)
 	if err != nil {
-		return fmt.Errorf("cannot ensure bookmark feed exists: %s", err)
+		return 0, fmt.Errorf("cannot ensure bookmark feed exists: %s", err)
+	}
+	var feedID int64
+	err = tx.GetContext(ctx, &feedID, `
+		SELECT feed_id FROM feeds WHERE url = $1 LIMIT 1
+	`, feedUrl)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get bookmark feed: %s", err)
 	}
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO subscriptions (feed_id, account_id, created)
-		VALUES (
-			(SELECT feed_id FROM feeds WHERE owned_by = $1 LIMIT 1),
-			$1, $2
-		)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, feedID, accountID, now)
+	if err != nil {
+		return 0, fmt.Errorf("cannot ensure bookmark subscription exists: %s", err)
+	}
+	return feedID, nil
+}
+
+// ensureTagFeed creates feed that contains bookmarks of given account
+// tagged with given tag, unless it already exists. ID of the feed is
+// returned. Account is not subscribed to this feed, because it would
+// duplicate bookmarks.
+func ensureTagFeed(ctx context.Context, tx pg.Connection, accountID int64, tag, title string, now time.Time) (int64, error) {
+	feedUrl := fmt.Sprintf("/?feed=%d&tag=%s", accountID, url.QueryEscape(tag))
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO feeds (url, updated, owned_by, title, favicon_url, autorefresh)
+		VALUES ($1, $2, $3, $4, '/static/bookmark.png', false)
 		ON CONFLICT DO NOTHING
+	`, feedUrl, now, accountID, title)
+	if err != nil {
+		return 0, fmt.Errorf("cannot ensure tag feed exists: %s", err)
+	}
+	var feedID int64
+	err = tx.GetContext(ctx, &feedID, `
+		SELECT feed_id FROM feeds WHERE url = $1 LIMIT 1
+	`, feedUrl)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get tag feed: %s", err)
+	}
+	return feedID, nil
+}
+
+func (m *manager) SyncTagFeeds(ctx context.Context, accountID int64) error {
+	return pg.WithTx(ctx, m.db, nil, func(tx pg.Connection) error {
+		return syncTagFeeds(ctx, tx, accountID)
+	})
+}
 
-	`, accountID, now)
+// syncTagFeeds updates entries of all shared tag feeds of given account,
+// so that each contains a copy of every bookmark with that tag. Accounts
+// that do not share any tag are not updated.
+func syncTagFeeds(ctx context.Context, c pg.Connection, accountID int64) error {
+	var shared bool
+	err := c.GetContext(ctx, &shared, `
+		SELECT EXISTS (
+			SELECT 1 FROM shares WHERE account_id = $1 AND tag != ''
+		)
+	`, accountID)
 	if err != nil {
-		return fmt.Errorf("cannot ensure bookmark subscription exists: %s", err)
+		return fmt.Errorf("cannot check tag shares: %s", err)
+	}
+	if !shared {
+		return nil
 	}
-	_, err = tx.Exec(`
+
+	_, err = c.ExecContext(ctx, `
 		INSERT INTO entries (feed_id, title, url, created, published, word_count)
-		VALUES (
-			(SELECT feed_id FROM feeds WHERE owned_by = $1 LIMIT 1),
-			$2, $3, $4, $4, $5)
+			SELECT s.feed_id, e.title, e.url, e.created, e.published, e.word_count
+			FROM
+				shares s
+				INNER JOIN bookmarks b ON b.account_id = s.account_id AND s.tag = ANY(b.tags)
+				INNER JOIN entries e ON e.entry_id = b.entry_id
+			WHERE
+				s.account_id = $1
+				AND s.tag != ''
 		ON CONFLICT (feed_id, url) DO UPDATE SET
-			published = $4,
-			title = $2,
-			word_count = $5
-	`, accountID, title, url, time.Now(), 0) // TODO
+			title = EXCLUDED.title,
+			published = EXCLUDED.published
+	`, accountID)
 	if err != nil {
-		return fmt.Errorf("cannot insert bookmark: %s", err)
+		return fmt.Errorf("cannot copy tagged bookmarks: %s", err)
 	}
+	_, err = c.ExecContext(ctx, `
+		DELETE FROM entries e
+		USING shares s
+		WHERE
+			e.feed_id = s.feed_id
+			AND s.account_id = $1
+			AND s.tag != ''
+			AND NOT EXISTS (
+				SELECT 1
+				FROM
+					bookmarks b
+					INNER JOIN entries be ON be.entry_id = b.entry_id
+				WHERE
+					b.account_id = s.account_id
+					AND be.url = e.url
+					AND s.tag = ANY(b.tags)
+			)
+	`, accountID)
+	if err != nil {
+		return fmt.Errorf("cannot delete untagged bookmarks: %s", err)
+	}
+	return nil
+}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("cannot commit transaction: %s", err)
+func (m *manager) Bookmarks(ctx context.Context, accountID int64) ([]*Entry, error) {
+	return m.TaggedBookmarks(ctx, accountID, "", 0)
+}
+
+// TaggedBookmarks returns up to limit bookmarks of given account that are
+// tagged with given tag, most recent first. Empty tag matches all bookmarks
+// and zero limit returns all of them.
+func (m *manager) TaggedBookmarks(ctx context.Context, accountID int64, tag string, limit int) ([]*Entry, error) {
+	var entries []*Entry
+	err := m.db.SelectContext(ctx, &entries, `
+		SELECT
+			e.entry_id,
+			e.feed_id,
+			e.title,
+			e.url,
+			e.word_count,
+			e.published,
+			e.created,
+			f.owned_by AS feed_owned_by,
+			f.title AS feed_title,
+			f.favicon_url AS feed_favicon_url,
+			b.tags,
+			b.note,
+			b.selection,
+			COALESCE(a.error = '', false) AS archived
+		FROM
+			entries e
+			INNER JOIN feeds f ON e.feed_id = f.feed_id
+			INNER JOIN bookmarks b ON b.entry_id = e.entry_id
+			LEFT JOIN archives a ON a.entry_id = b.entry_id
+		WHERE
+			b.account_id = $1
+			AND ($2::text = '' OR $2 = ANY(b.tags))
+		ORDER BY
+			e.created DESC
+		LIMIT NULLIF($3, 0)
+	`, accountID, tag, limit)
+	return entries, err
+}
+
+// BookmarkEntry returns single bookmark of given account. It returns
+// pg.ErrNotFound if bookmark does not exist.
+func (m *manager) BookmarkEntry(ctx context.Context, accountID, entryID int64) (*Entry, error) {
+	var e Entry
+	err := m.db.GetContext(ctx, &e, `
+		SELECT
+			e.entry_id,
+			e.feed_id,
+			e.title,
+			e.url,
+			e.word_count,
+			e.published,
+			e.created,
+			f.owned_by AS feed_owned_by,
+			f.title AS feed_title,
+			f.favicon_url AS feed_favicon_url,
+			b.tags,
+			b.note,
+			b.selection,
+			COALESCE(a.error = '', false) AS archived
+		FROM
+			entries e
+			INNER JOIN feeds f ON e.feed_id = f.feed_id
+			INNER JOIN bookmarks b ON b.entry_id = e.entry_id
+			LEFT JOIN archives a ON a.entry_id = b.entry_id
+		WHERE
+			b.account_id = $1
+			AND b.entry_id = $2
+		LIMIT 1
+	`, accountID, entryID)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// EditBookmark replaces tags and note of a bookmark. It returns
+// pg.ErrNotFound if bookmark does not exist.
+func (m *manager) EditBookmark(ctx context.Context, accountID, entryID int64, tags []string, note string) error {
+	return pg.WithTx(ctx, m.db, nil, func(tx pg.Connection) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE bookmarks
+			SET tags = $3, note = $4, updated = $5
+			WHERE entry_id = $1 AND account_id = $2
+		`, entryID, accountID, pq.Array(normalizeTags(tags)), note, time.Now())
+		if err != nil {
+			return fmt.Errorf("cannot update bookmark: %s", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return pg.ErrNotFound
+		}
+		return syncTagFeeds(ctx, tx, accountID)
+	})
+}
+
+// TagCount represents tag together with the number of bookmarks it is
+// used by.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// Tags returns all tags used by bookmarks of given account, ordered by
+// name.
+func (m *manager) Tags(ctx context.Context, accountID int64) ([]*TagCount, error) {
+	var tags []*TagCount
+	err := m.db.SelectContext(ctx, &tags, `
+		SELECT t.tag, COUNT(*) AS count
+		FROM bookmarks b, unnest(b.tags) AS t(tag)
+		WHERE b.account_id = $1
+		GROUP BY t.tag
+		ORDER BY t.tag
+	`, accountID)
+	return tags, err
+}
+
+// normalizeTags returns sorted, lower case tags without duplicates. Inner
+// white space is replaced with dash.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	res := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = normalizeTag(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		res = append(res, t)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+}
+
+// parseTags returns tags from comma or white space separated list.
+func parseTags(s string) []string {
+	return normalizeTags(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}))
+}
+
+func (m *manager) EntryStates(ctx context.Context, accountID int64) ([]*EntryState, error) {
+	var states []*EntryState
+	err := m.db.SelectContext(ctx, &states, `
+		SELECT
+			st.entry_id,
+			e.title,
+			e.url,
+			st.read,
+			st.starred,
+			st.updated
+		FROM
+			entry_states st
+			INNER JOIN entries e ON e.entry_id = st.entry_id
+		WHERE
+			st.account_id = $1
+		ORDER BY
+			st.updated DESC
+	`, accountID)
+	return states, err
+}
+
+// FeedToken returns token that gives access to published feeds of given
+// account. Token is created if it does not exist yet.
+func (m *manager) FeedToken(ctx context.Context, accountID int64) (string, error) {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO feed_tokens (account_id, token, created)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (account_id) DO NOTHING
+	`, accountID, randstr.New(20), time.Now())
+	if err != nil {
+		return "", fmt.Errorf("cannot create token: %s", err)
+	}
+	var token string
+	err = m.db.GetContext(ctx, &token, `
+		SELECT token FROM feed_tokens WHERE account_id = $1 LIMIT 1
+	`, accountID)
+	return token, err
+}
+
+// ResetFeedToken replaces feed token of given account with a new one, so
+// that published feeds are no longer available using the old token.
+func (m *manager) ResetFeedToken(ctx context.Context, accountID int64) (string, error) {
+	token := randstr.New(20)
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO feed_tokens (account_id, token, created)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (account_id) DO UPDATE SET
+			token = EXCLUDED.token,
+			created = EXCLUDED.created
+	`, accountID, token, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// FeedTokenAccount returns ID of the account that given feed token belongs
+// to. It returns pg.ErrNotFound if token does not exist.
+func (m *manager) FeedTokenAccount(ctx context.Context, token string) (int64, error) {
+	var accountID int64
+	err := m.db.GetContext(ctx, &accountID, `
+		SELECT account_id FROM feed_tokens WHERE token = $1 LIMIT 1
+	`, token)
+	return accountID, err
+}
+
+// Share makes bookmarks of given account public under given title. If tag
+// is not empty, only bookmarks with that tag are shared. If bookmarks are
+// already shared, only the title is changed.
+func (m *manager) Share(ctx context.Context, accountID int64, title, tag string) (*Share, error) {
+	tag = normalizeTag(tag)
+	if title == "" {
+		if tag == "" {
+			title = "Bookmarks"
+		} else {
+			title = "Bookmarks tagged " + tag
+		}
+	}
+	var share Share
+	err := pg.WithTx(ctx, m.db, nil, func(tx pg.Connection) error {
+		now := time.Now()
+		var (
+			feedID int64
+			err    error
+		)
+		if tag == "" {
+			feedID, err = ensureBookmarkFeed(ctx, tx, accountID, now)
+		} else {
+			feedID, err = ensureTagFeed(ctx, tx, accountID, tag, title, now)
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.GetContext(ctx, &share, `
+			INSERT INTO shares (account_id, feed_id, token, title, tag, created)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (feed_id) DO UPDATE SET
+				title = EXCLUDED.title
+			RETURNING *
+		`, accountID, feedID, randstr.New(20), title, tag, now)
+		if err != nil {
+			return fmt.Errorf("cannot create share: %s", err)
+		}
+		// subscribers see shared feed under the share title
+		_, err = tx.ExecContext(ctx, `
+			UPDATE feeds SET title = $1 WHERE feed_id = $2
+		`, share.Title, share.FeedID)
+		if err != nil {
+			return fmt.Errorf("cannot update feed title: %s", err)
+		}
+		if tag == "" {
+			return nil
+		}
+		return syncTagFeeds(ctx, tx, accountID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (m *manager) Shares(ctx context.Context, accountID int64) ([]*Share, error) {
+	var shares []*Share
+	err := m.db.SelectContext(ctx, &shares, `
+		SELECT * FROM shares
+		WHERE account_id = $1
+		ORDER BY created ASC
+	`, accountID)
+	return shares, err
+}
+
+// ShareByToken returns share with given token. It returns pg.ErrNotFound
+// if share does not exist.
+func (m *manager) ShareByToken(ctx context.Context, token string) (*Share, error) {
+	var share Share
+	err := m.db.GetContext(ctx, &share, `
+		SELECT * FROM shares WHERE token = $1 LIMIT 1
+	`, token)
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// Unshare makes shared feed private again. Subscriptions of other accounts
+// to that feed are removed. Feed of a shared tag is removed altogether.
+func (m *manager) Unshare(ctx context.Context, accountID, shareID int64) error {
+	return pg.WithTx(ctx, m.db, nil, func(tx pg.Connection) error {
+		var share Share
+		err := tx.GetContext(ctx, &share, `
+			DELETE FROM shares
+			WHERE share_id = $1 AND account_id = $2
+			RETURNING *
+		`, shareID, accountID)
+		switch err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			return nil
+		default:
+			return fmt.Errorf("cannot delete share: %s", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM subscriptions
+			WHERE feed_id = $1 AND account_id != $2
+		`, share.FeedID, accountID)
+		if err != nil {
+			return fmt.Errorf("cannot delete subscriptions: %s", err)
+		}
+		if share.Tag == "" {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE feeds SET title = 'Bookmarks' WHERE feed_id = $1
+			`, share.FeedID)
+			if err != nil {
+				return fmt.Errorf("cannot update feed title: %s", err)
+			}
+			return nil
+		}
+		for _, query := range []string{
+			`DELETE FROM entries WHERE feed_id = $1`,
+			`DELETE FROM feeds WHERE feed_id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, share.FeedID); err != nil {
+				return fmt.Errorf("cannot delete tag feed: %s", err)
+			}
+		}
+		return nil
+	})
+}
+
+// PendingArchives returns bookmarks for which no snapshot was taken yet,
+// most recently updated first. Only entry, account and URL are set.
+func (m *manager) PendingArchives(ctx context.Context, limit int) ([]*Archive, error) {
+	var archives []*Archive
+	err := m.db.SelectContext(ctx, &archives, `
+		SELECT
+			b.entry_id,
+			b.account_id,
+			e.url
+		FROM
+			bookmarks b
+			INNER JOIN entries e ON e.entry_id = b.entry_id
+			LEFT JOIN archives a ON a.entry_id = b.entry_id
+		WHERE
+			a.entry_id IS NULL
+		ORDER BY
+			b.updated DESC
+		LIMIT $1
+	`, limit)
+	return archives, err
+}
+
+// SaveArchive stores snapshot information, replacing any previous snapshot
+// of the same entry.
+func (m *manager) SaveArchive(ctx context.Context, a *Archive) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO archives (entry_id, account_id, blob_key, size, error, created)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (entry_id) DO UPDATE SET
+			blob_key = EXCLUDED.blob_key,
+			size = EXCLUDED.size,
+			error = EXCLUDED.error,
+			created = EXCLUDED.created
+	`, a.EntryID, a.AccountID, a.BlobKey, a.Size, a.Error, a.Created)
+	if err != nil {
+		return fmt.Errorf("cannot save archive: %s", err)
+	}
+	return nil
+}
+
+// Archive returns snapshot of a bookmark of given account. It returns
+// pg.ErrNotFound if snapshot was not taken yet.
+func (m *manager) Archive(ctx context.Context, accountID, entryID int64) (*Archive, error) {
+	var a Archive
+	err := m.db.GetContext(ctx, &a, `
+		SELECT a.*, e.url
+		FROM archives a INNER JOIN entries e ON e.entry_id = a.entry_id
+		WHERE a.account_id = $1 AND a.entry_id = $2
+		LIMIT 1
+	`, accountID, entryID)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Archives returns all snapshots of bookmarks of given account, including
+// failed ones.
+func (m *manager) Archives(ctx context.Context, accountID int64) ([]*Archive, error) {
+	var archives []*Archive
+	err := m.db.SelectContext(ctx, &archives, `
+		SELECT a.*, e.url
+		FROM archives a INNER JOIN entries e ON e.entry_id = a.entry_id
+		WHERE a.account_id = $1
+		ORDER BY a.created ASC
+	`, accountID)
+	return archives, err
+}
+
+// DeleteAccountData removes all subscriptions, entry states, feed tokens,
+// shares, bookmarks, archives and owned feeds of given account.
+// Subscriptions of other accounts to owned feeds are removed as well.
+// Archived content must be removed from the blob store separately.
+func DeleteAccountData(ctx context.Context, e pg.Execer, accountID int64) error {
+	queries := []string{
+		`
+		DELETE FROM entry_states
+		WHERE account_id = $1
+			OR entry_id IN (
+				SELECT e.entry_id
+				FROM entries e INNER JOIN feeds f ON e.feed_id = f.feed_id
+				WHERE f.owned_by = $1
+			)
+		`,
+		`
+		DELETE FROM subscriptions
+		WHERE account_id = $1
+			OR feed_id IN (SELECT feed_id FROM feeds WHERE owned_by = $1)
+		`,
+		`
+		DELETE FROM shares WHERE account_id = $1
+		`,
+		`
+		DELETE FROM archives WHERE account_id = $1
+		`,
+		`
+		DELETE FROM bookmarks WHERE account_id = $1
+		`,
+		`
+		DELETE FROM entries
+		WHERE feed_id IN (SELECT feed_id FROM feeds WHERE owned_by = $1)
+		`,
+		`
+		DELETE FROM feeds WHERE owned_by = $1
+		`,
+		`
+		DELETE FROM feed_tokens WHERE account_id = $1
+		`,
+	}
+	for _, query := range queries {
+		if _, err := e.ExecContext(ctx, query, accountID); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,417 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/pg/pgtest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(pgtest.Main(m))
+}
+
+func TestManagerBookmark(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+	m := NewManager(db, nil, nil, "")
+
+	if err := m.Bookmark(ctx, 1, "http://example.com/1", ""); err != nil {
+		t.Fatalf("cannot bookmark: %s", err)
+	}
+	if err := m.Bookmark(ctx, 1, "http://example.com/1", "First"); err != nil {
+		t.Fatalf("cannot bookmark again: %s", err)
+	}
+	if err := m.Bookmark(ctx, 1, "http://example.com/2", "Second"); err != nil {
+		t.Fatalf("cannot bookmark: %s", err)
+	}
+
+	bookmarks, err := m.Bookmarks(ctx, 1)
+	if err != nil {
+		t.Fatalf("cannot list bookmarks: %s", err)
+	}
+	if len(bookmarks) != 2 {
+		t.Fatalf("want two bookmarks, got %d", len(bookmarks))
+	}
+	titles := map[string]string{}
+	for _, b := range bookmarks {
+		titles[b.URL] = b.Title
+	}
+	if titles["http://example.com/1"] != "First" {
+		t.Fatalf("bookmark title not updated: %v", titles)
+	}
+
+	subs, err := m.Subscriptions(ctx, 1)
+	if err != nil {
+		t.Fatalf("cannot list subscriptions: %s", err)
+	}
+	if len(subs) != 1 || subs[0].FeedOwnedBy != 1 {
+		t.Fatalf("want bookmarks feed subscription, got %+v", subs)
+	}
+
+	// other account bookmarks are not visible
+	if bookmarks, err := m.Bookmarks(ctx, 2); err != nil || len(bookmarks) != 0 {
+		t.Fatalf("want no bookmarks, got %d (%v)", len(bookmarks), err)
+	}
+}
+
+func TestDeleteAccountData(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+	m := NewManager(db, nil, nil, "")
+
+	if err := m.Bookmark(ctx, 1, "http://example.com/1", "First"); err != nil {
+		t.Fatalf("cannot bookmark: %s", err)
+	}
+	pgtest.LoadSQLString(t, db, `
+		SELECT subscribe(1, 'http://example.com/feed', 'example', now())
+		---
+		SELECT subscribe(2, 'http://example.com/feed', 'example', now())
+		---
+		SELECT subscribe(2, '/?feed=1', 'bookmarks', now())
+		---
+		INSERT INTO entry_states (account_id, entry_id, read, starred, updated)
+			SELECT 2, entry_id, true, false, now() FROM entries
+	`)
+
+	if err := DeleteAccountData(ctx, db, 1); err != nil {
+		t.Fatalf("cannot delete account data: %s", err)
+	}
+
+	if subs, err := m.Subscriptions(ctx, 1); err != nil || len(subs) != 0 {
+		t.Fatalf("want no subscriptions, got %d (%v)", len(subs), err)
+	}
+	subs, err := m.Subscriptions(ctx, 2)
+	if err != nil {
+		t.Fatalf("cannot list subscriptions: %s", err)
+	}
+	if len(subs) != 1 || subs[0].URL != "http://example.com/feed" {
+		t.Fatalf("want only example feed subscription, got %+v", subs)
+	}
+
+	var cnt int
+	if err := db.Get(&cnt, `SELECT COUNT(*) FROM feeds WHERE owned_by = 1`); err != nil {
+		t.Fatalf("cannot count feeds: %s", err)
+	}
+	if cnt != 0 {
+		t.Fatalf("want no owned feeds, got %d", cnt)
+	}
+	if err := db.Get(&cnt, `SELECT COUNT(*) FROM entry_states`); err != nil {
+		t.Fatalf("cannot count entry states: %s", err)
+	}
+	if cnt != 0 {
+		t.Fatalf("want no entry states, got %d", cnt)
+	}
+
+	entries, err := m.Entries(ctx, 1, time.Now())
+	if err != nil {
+		t.Fatalf("cannot list entries: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("want no entries, got %d", len(entries))
+	}
+}
+
+func TestManagerEntriesPage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+	m := NewManager(db, nil, nil, "")
+
+	pgtest.LoadSQLString(t, db, `
+		SELECT subscribe(1, 'http://example.com/feed', 'example', now())
+		---
+		INSERT INTO entries (feed_id, title, url, created, published)
+			SELECT f.feed_id, 'entry ' || n, 'http://example.com/' || n, now(), now() - n * interval '1 hour'
+			FROM feeds f, generate_series(1, 5) n
+		---
+		-- entries published in the future are on the first page as well
+		INSERT INTO entries (feed_id, title, url, created, published)
+			SELECT feed_id, 'entry 0', 'http://example.com/0', now(), now() + interval '1 hour'
+			FROM feeds
+	`)
+
+	var titles []string
+	q := EntryQuery{Limit: 4}
+	for {
+		entries, err := m.EntriesPage(ctx, 1, q)
+		if err != nil {
+			t.Fatalf("cannot list entries page: %s", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			titles = append(titles, e.Title)
+		}
+		last := entries[len(entries)-1]
+		q.Before = Cursor{Published: last.Published, EntryID: last.EntryID}
+	}
+	want := []string{"entry 0", "entry 1", "entry 2", "entry 3", "entry 4", "entry 5"}
+	if !reflect.DeepEqual(titles, want) {
+		t.Fatalf("want %v, got %v", want, titles)
+	}
+
+	// other account does not see entries of not subscribed feeds
+	if entries, err := m.EntriesPage(ctx, 2, EntryQuery{}); err != nil || len(entries) != 0 {
+		t.Fatalf("want no entries, got %d (%v)", len(entries), err)
+	}
+}
+
+func TestManagerBookmarkQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.NewMock()
+	db.ExpectBegin()
+	db.ExpectExec(`INSERT INTO feeds .* VALUES \(\$1, \$2, \$3, 'Bookmarks'`).
+		WithArgs("/?feed=42", pgtest.AnyArg(), 42)
+	db.ExpectGet(`SELECT feed_id FROM feeds WHERE url = \$1`).
+		WithArgs("/?feed=42").
+		WillReturnRows(pgtest.NewRows("feed_id").AddRow(7))
+	db.ExpectExec(`INSERT INTO subscriptions`).
+		WithArgs(7, 42, pgtest.AnyArg())
+	db.ExpectGet(`INSERT INTO entries .* RETURNING entry_id`).
+		WithArgs(7, "Example", "http://example.com", pgtest.AnyArg(), 0).
+		WillReturnRows(pgtest.NewRows("entry_id").AddRow(93))
+	db.ExpectExec(`INSERT INTO bookmarks`).
+		WithArgs(93, 42, pgtest.AnyArg(), "some note", "", pgtest.AnyArg())
+	db.ExpectGet(`SELECT EXISTS \(\s+SELECT 1 FROM shares`).
+		WithArgs(42).
+		WillReturnRows(pgtest.NewRows("exists").AddRow(true))
+	db.ExpectExec(`INSERT INTO entries .* FROM\s+shares`).
+		WithArgs(42)
+	db.ExpectExec(`DELETE FROM entries e\s+USING shares`).
+		WithArgs(42)
+	db.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs(entriesChannel, `{"feed_id":7,"entries":1}`)
+	db.ExpectCommit()
+
+	m := NewManager(db, nil, nil, "")
+	if err := m.Bookmark(ctx, 42, "http://example.com", "Example", WithTags("go"), WithNote("some note")); err != nil {
+		t.Fatalf("cannot bookmark: %s", err)
+	}
+	if err := db.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeedToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+	m := NewManager(db, nil, nil, "")
+
+	token, err := m.FeedToken(ctx, 1)
+	if err != nil {
+		t.Fatalf("cannot create feed token: %s", err)
+	}
+	if again, err := m.FeedToken(ctx, 1); err != nil || again != token {
+		t.Fatalf("want the same token, got %q (%v)", again, err)
+	}
+	if accountID, err := m.FeedTokenAccount(ctx, token); err != nil || accountID != 1 {
+		t.Fatalf("want account 1, got %d (%v)", accountID, err)
+	}
+
+	newToken, err := m.ResetFeedToken(ctx, 1)
+	if err != nil {
+		t.Fatalf("cannot reset feed token: %s", err)
+	}
+	if newToken == token {
+		t.Fatal("token not changed")
+	}
+	if _, err := m.FeedTokenAccount(ctx, token); err != pg.ErrNotFound {
+		t.Fatalf("want pg.ErrNotFound, got %v", err)
+	}
+}
+
+func TestManagerShare(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+	m := NewManager(db, nil, nil, "")
+
+	share, err := m.Share(ctx, 1, "Reading list", "")
+	if err != nil {
+		t.Fatalf("cannot share: %s", err)
+	}
+	if again, err := m.Share(ctx, 1, "Good reads", ""); err != nil || again.Token != share.Token {
+		t.Fatalf("want the same share, got %+v (%v)", again, err)
+	}
+
+	feedID, err := m.Subscribe(ctx, 2, "http://feedstream.example"+share.Path())
+	if err != nil {
+		t.Fatalf("cannot subscribe to share: %s", err)
+	}
+	if feedID != share.FeedID {
+		t.Fatalf("want %d feed subscribed, got %d", share.FeedID, feedID)
+	}
+	if err := m.Bookmark(ctx, 1, "http://example.com/1", "First"); err != nil {
+		t.Fatalf("cannot bookmark: %s", err)
+	}
+	entries, err := m.Entries(ctx, 2, time.Now())
+	if err != nil {
+		t.Fatalf("cannot list entries: %s", err)
+	}
+	if len(entries) != 1 || entries[0].FeedTitle != "Good reads" {
+		t.Fatalf("want shared bookmark, got %+v", entries)
+	}
+
+	if err := m.Unshare(ctx, 1, share.ShareID); err != nil {
+		t.Fatalf("cannot unshare: %s", err)
+	}
+	if _, err := m.ShareByToken(ctx, share.Token); err != pg.ErrNotFound {
+		t.Fatalf("want share removed, got %v", err)
+	}
+	if subs, err := m.Subscriptions(ctx, 2); err != nil || len(subs) != 0 {
+		t.Fatalf("want no subscriptions, got %d (%v)", len(subs), err)
+	}
+	if subs, err := m.Subscriptions(ctx, 1); err != nil || len(subs) != 1 {
+		t.Fatalf("want bookmarks subscription kept, got %d (%v)", len(subs), err)
+	}
+}
+
+func TestManagerSubscribeShareQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.NewMock()
+	db.ExpectGet(`SELECT \* FROM shares WHERE token = \$1`).
+		WithArgs("ab12").
+		WillReturnRows(pgtest.NewRows("share_id", "account_id", "feed_id", "token", "title", "created").
+			AddRow(3, 1, 7, "ab12", "Bookmarks", time.Now()))
+	db.ExpectExec(`INSERT INTO subscriptions`).
+		WithArgs(7, 42, pgtest.AnyArg())
+
+	m := NewManager(db, nil, nil, "http://feedstream.example")
+	// shared feed is not fetched, so this must work without network
+	feedID, err := m.Subscribe(ctx, 42, "http://feedstream.example/s/ab12.atom")
+	if err != nil {
+		t.Fatalf("cannot subscribe: %s", err)
+	}
+	if feedID != 7 {
+		t.Fatalf("want feed 7, got %d", feedID)
+	}
+	if err := db.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerUnsubscribeQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.NewMock()
+	db.ExpectExec(`DELETE FROM subscriptions`).
+		WithArgs(42, 3).
+		WillReturnResult(&pgtest.ExecResultMock{Affected: 1})
+	db.ExpectExec(`DELETE FROM subscriptions`).
+		WithArgs(42, 4).
+		WillReturnResult(&pgtest.ExecResultMock{Affected: 0})
+
+	m := NewManager(db, nil, nil, "")
+	if err := m.Unsubscribe(ctx, 3, 42); err != nil {
+		t.Fatalf("cannot unsubscribe: %s", err)
+	}
+	if err := m.Unsubscribe(ctx, 4, 42); err != pg.ErrNotFound {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	if err := db.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerBookmarkTags(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.TxDB(t, nil)
+	m := NewManager(db, nil, nil, "")
+
+	if err := m.Bookmark(ctx, 1, "http://example.com/1", "First", WithTags("Go", "databases"), WithNote("read later")); err != nil {
+		t.Fatalf("cannot bookmark: %s", err)
+	}
+	// bookmarking again merges tags and keeps the note
+	if err := m.Bookmark(ctx, 1, "http://example.com/1", "First", WithTags("go", "web")); err != nil {
+		t.Fatalf("cannot bookmark again: %s", err)
+	}
+	if err := m.Bookmark(ctx, 1, "http://example.com/2", "Second", WithTags("web")); err != nil {
+		t.Fatalf("cannot bookmark: %s", err)
+	}
+
+	tagged, err := m.TaggedBookmarks(ctx, 1, "go", 0)
+	if err != nil {
+		t.Fatalf("cannot list tagged bookmarks: %s", err)
+	}
+	if len(tagged) != 1 {
+		t.Fatalf("want one bookmark, got %d", len(tagged))
+	}
+	if limited, err := m.TaggedBookmarks(ctx, 1, "web", 1); err != nil || len(limited) != 1 || limited[0].URL != "http://example.com/2" {
+		t.Fatalf("want only the most recent bookmark, got %+v (%v)", limited, err)
+	}
+	if want := []string{"databases", "go", "web"}; !reflect.DeepEqual([]string(tagged[0].Tags), want) || tagged[0].Note != "read later" {
+		t.Fatalf("unexpected bookmark: %+v", tagged[0])
+	}
+
+	tags, err := m.Tags(ctx, 1)
+	if err != nil {
+		t.Fatalf("cannot list tags: %s", err)
+	}
+	if len(tags) != 3 || tags[2].Tag != "web" || tags[2].Count != 2 {
+		t.Fatalf("unexpected tags: %+v", tags)
+	}
+
+	share, err := m.Share(ctx, 1, "", "web")
+	if err != nil {
+		t.Fatalf("cannot share tag: %s", err)
+	}
+	if _, err := m.Subscribe(ctx, 2, share.Path()); err != nil {
+		t.Fatalf("cannot subscribe to tag share: %s", err)
+	}
+	if entries, err := m.Entries(ctx, 2, time.Now()); err != nil || len(entries) != 2 {
+		t.Fatalf("want two shared entries, got %d (%v)", len(entries), err)
+	}
+
+	if err := m.EditBookmark(ctx, 1, tagged[0].EntryID, []string{"go"}, ""); err != nil {
+		t.Fatalf("cannot edit bookmark: %s", err)
+	}
+	if entries, err := m.Entries(ctx, 2, time.Now()); err != nil || len(entries) != 1 {
+		t.Fatalf("want one shared entry, got %d (%v)", len(entries), err)
+	}
+	if err := m.EditBookmark(ctx, 2, tagged[0].EntryID, nil, ""); err != pg.ErrNotFound {
+		t.Fatalf("want other account bookmark not found, got %v", err)
+	}
+
+	// tag feed copies are not listed as bookmarks
+	if bookmarks, err := m.Bookmarks(ctx, 1); err != nil || len(bookmarks) != 2 {
+		t.Fatalf("want two bookmarks, got %d (%v)", len(bookmarks), err)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	cases := map[string][]string{
+		"":                      {},
+		"go":                    {"go"},
+		"Go, web  databases,go": {"databases", "go", "web"},
+		" ,, ":                  {},
+	}
+	for raw, want := range cases {
+		if got := parseTags(raw); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: want %q, got %q", raw, want, got)
+		}
+	}
+	if got := normalizeTags([]string{"Machine  Learning"}); !reflect.DeepEqual(got, []string{"machine-learning"}) {
+		t.Errorf("unexpected tags: %q", got)
+	}
+}
@@ -0,0 +1,52 @@
+package stream
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+type opml struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"head>title"`
+	Created string      `xml:"head>dateCreated"`
+	Outline []opmlEntry `xml:"body>outline"`
+}
+
+type opmlEntry struct {
+	Type    string `xml:"type,attr"`
+	Text    string `xml:"text,attr"`
+	Title   string `xml:"title,attr"`
+	XMLURL  string `xml:"xmlUrl,attr"`
+	HTMLURL string `xml:"htmlUrl,attr,omitempty"`
+}
+
+// writeOPML writes OPML document listing all given subscriptions. Feeds
+// owned by accounts are not listed, because they are not available outside
+// of the application.
+func writeOPML(w io.Writer, title string, subs []*Subscription) error {
+	doc := opml{
+		Version: "1.0",
+		Title:   title,
+		Created: time.Now().Format(time.RFC1123Z),
+	}
+	for _, s := range subs {
+		if s.FeedOwnedBy != 0 {
+			continue
+		}
+		doc.Outline = append(doc.Outline, opmlEntry{
+			Type:   "rss",
+			Text:   s.Title,
+			Title:  s.Title,
+			XMLURL: s.URL,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	return enc.Encode(&doc)
+}
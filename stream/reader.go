@@ -0,0 +1,207 @@
+package stream
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/ui"
+	"github.com/husio/web"
+	"golang.org/x/net/html"
+)
+
+// maxProxiedImageSize is the maximum size of an image served by the image
+// proxy.
+const maxProxiedImageSize = 5 << 20
+
+// ReaderHandler displays content of a single entry, together with links to
+// adjacent entries of the stream it was opened from. Stream is selected
+// with the same "feed" and "tag" query parameters as the entry list.
+// Displayed entry is marked as read.
+func ReaderHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		entryID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			tmpl.RenderStd(w, http.StatusBadRequest)
+			return
+		}
+		tag := normalizeTag(r.URL.Query().Get("tag"))
+		feedID, _ := strconv.ParseInt(r.URL.Query().Get("feed"), 10, 64)
+
+		entry, err := manager.EntryContent(r.Context(), user.AccountID, entryID)
+		switch err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot get entry %d: %s", entryID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		newer, older, err := manager.AdjacentEntries(r.Context(), user.AccountID, entry, feedID, tag)
+		if err != nil {
+			log.Printf("cannot get entries adjacent to %d: %s", entryID, err)
+		}
+
+		if !entry.Read {
+			if err := manager.MarkRead(r.Context(), user.AccountID, []int64{entryID}, true); err != nil {
+				log.Printf("cannot mark entry %d as read: %s", entryID, err)
+			}
+		}
+
+		content := struct {
+			AccountID   int64
+			Entry       *Entry
+			Newer       *Entry
+			Older       *Entry
+			StreamQuery string
+		}{
+			AccountID:   user.AccountID,
+			Entry:       entry,
+			Newer:       newer,
+			Older:       older,
+			StreamQuery: streamQuery(feedID, tag),
+		}
+		tmpl.Render(w, "reader.tmpl", content, http.StatusOK)
+	}
+}
+
+// streamQuery returns query string selecting the stream of entries, as
+// understood by the entry list.
+func streamQuery(feedID int64, tag string) string {
+	q := make(url.Values)
+	if tag != "" {
+		q.Set("tag", tag)
+	} else if feedID > 0 {
+		q.Set("feed", strconv.FormatInt(feedID, 10))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ProxiedImageURL returns URL of given image served by the image proxy.
+// Images are proxied so that the publisher cannot track reading and pages
+// served over HTTPS do not include insecure content.
+func ProxiedImageURL(imageURL string) string {
+	return "/images?url=" + url.QueryEscape(imageURL)
+}
+
+// textToHTML returns plain text article formatted as HTML paragraphs.
+func textToHTML(text string) string {
+	var b strings.Builder
+	for _, para := range strings.Split(text, "\n") {
+		if para = strings.TrimSpace(para); para != "" {
+			b.WriteString("<p>" + html.EscapeString(para) + "</p>\n")
+		}
+	}
+	return b.String()
+}
+
+// ImageProxyHandler serves images displayed by the reader. Only
+// authenticated users can use the proxy and only images from public
+// addresses are served.
+func ImageProxyHandler(
+	authSrv auth.AuthService,
+) http.HandlerFunc {
+	return imageProxyHandler(authSrv, NewPublicClient(15*time.Second))
+}
+
+func imageProxyHandler(
+	authSrv auth.AuthService,
+	client *http.Client,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch _, err := authSrv.CurrentUser(r.Context(), r); err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		u, err := url.Parse(r.URL.Query().Get("url"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		resp, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			log.Printf("cannot fetch image %q: %s", u, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		contentType := resp.Header.Get("Content-Type")
+		if !strings.HasPrefix(contentType, "image/") {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if resp.ContentLength > maxProxiedImageSize {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		// content length is not always provided, so the size must be
+		// checked before anything is written
+		b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProxiedImageSize+1))
+		if err != nil {
+			log.Printf("cannot read image %q: %s", u, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		if len(b) > maxProxiedImageSize {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// images such as SVG can contain scripts
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+		if _, err := w.Write(b); err != nil {
+			log.Printf("cannot write image %q: %s", u, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/web"
+)
+
+func TestTextToHTML(t *testing.T) {
+	got := textToHTML("First <paragraph>.\n\n  Second one.\n")
+	want := "<p>First &lt;paragraph&gt;.</p>\n<p>Second one.</p>\n"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+type readerManager struct {
+	stubManager
+	read     []int64
+	adjacent []interface{}
+}
+
+func (m *readerManager) EntryContent(ctx context.Context, accountID, entryID int64) (*Entry, error) {
+	if entryID != 5 {
+		return nil, pg.ErrNotFound
+	}
+	return &Entry{EntryID: 5, URL: "http://example.com/5", Content: "<p>Content</p>"}, nil
+}
+
+func (m *readerManager) AdjacentEntries(ctx context.Context, accountID int64, e *Entry, feedID int64, tag string) (*Entry, *Entry, error) {
+	m.adjacent = append(m.adjacent, feedID, tag)
+	return &Entry{EntryID: 6}, nil, nil
+}
+
+func (m *readerManager) MarkRead(ctx context.Context, accountID int64, entryIDs []int64, read bool) error {
+	m.read = append(m.read, entryIDs...)
+	return nil
+}
+
+func TestReaderHandler(t *testing.T) {
+	m := &readerManager{}
+	tmpl := &stubRenderer{}
+	rt := web.NewRouter()
+	rt.Add(`/entries/(entry-id)`, "GET", ReaderHandler(m, &stubAuth{user: &auth.User{AccountID: 1}}, tmpl))
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/entries/5?tag=Go", nil))
+	if w.Code != http.StatusOK || tmpl.name != "reader.tmpl" {
+		t.Fatalf("want reader page, got %d %q", w.Code, tmpl.name)
+	}
+	if want := []int64{5}; !reflect.DeepEqual(m.read, want) {
+		t.Fatalf("want %v marked as read, got %v", want, m.read)
+	}
+	if want := []interface{}{int64(0), "go"}; !reflect.DeepEqual(m.adjacent, want) {
+		t.Fatalf("want %v stream, got %v", want, m.adjacent)
+	}
+
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/entries/7", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("want 404, got %d", w.Code)
+	}
+}
+
+func TestStreamQuery(t *testing.T) {
+	cases := []struct {
+		feedID int64
+		tag    string
+		want   string
+	}{
+		{0, "", ""},
+		{3, "", "?feed=3"},
+		{0, "go lang", "?tag=go+lang"},
+		{3, "go", "?tag=go"},
+	}
+	for _, tc := range cases {
+		if got := streamQuery(tc.feedID, tc.tag); got != tc.want {
+			t.Errorf("%d %q: want %q, got %q", tc.feedID, tc.tag, tc.want, got)
+		}
+	}
+}
+
+func TestImageProxyHandler(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		switch r.URL.Path {
+		case "/small.gif":
+			w.Write([]byte("GIF89a"))
+		case "/big.gif":
+			// flushing before the whole body is written makes the
+			// response chunked, without content length
+			w.Write([]byte("GIF89a"))
+			w.(http.Flusher).Flush()
+			w.Write(bytes.Repeat([]byte{0}, maxProxiedImageSize))
+		}
+	}))
+	defer site.Close()
+
+	authSrv := &stubAuth{user: &auth.User{AccountID: 1}}
+	get := func(h http.HandlerFunc, imageURL string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/images?url="+url.QueryEscape(imageURL), nil))
+		return w
+	}
+
+	h := imageProxyHandler(authSrv, site.Client())
+	if w := get(h, site.URL+"/small.gif"); w.Code != http.StatusOK || w.Body.String() != "GIF89a" {
+		t.Fatalf("want image served, got %d %q", w.Code, w.Body)
+	}
+	if w := get(h, site.URL+"/big.gif"); w.Code != http.StatusNotFound || w.Body.Len() > 100 {
+		t.Fatalf("want too big image rejected, got %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	if w := get(ImageProxyHandler(authSrv), site.URL+"/small.gif"); w.Code != http.StatusBadGateway {
+		t.Fatalf("want local image rejected, got %d", w.Code)
+	}
+}
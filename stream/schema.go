@@ -1,69 +1,210 @@
 package stream
 
-const Schema = `
+import "github.com/husio/feedstream/pg"
 
-CREATE TABLE IF NOT EXISTS
-feeds (
-	feed_id SERIAL PRIMARY KEY,
-	url TEXT NOT NULL UNIQUE,
-	title TEXT NOT NULL,
-	favicon_url TEXT NOT NULL DEFAULT '',
-	updated TIMESTAMPTZ NOT NULL,
-	owned_by INTEGER NOT NULL -- references account, but if 0, not owned by anyone
-);
+func init() {
+	pg.RegisterMigrations("stream", migrations...)
+}
 
+var migrations = []pg.Migration{
+	{
+		Version: 1,
+		Name:    "create feeds, subscriptions and entries",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			feeds (
+				feed_id SERIAL PRIMARY KEY,
+				url TEXT NOT NULL UNIQUE,
+				title TEXT NOT NULL,
+				favicon_url TEXT NOT NULL DEFAULT '',
+				updated TIMESTAMPTZ NOT NULL,
+				owned_by INTEGER NOT NULL -- references account, but if 0, not owned by anyone
+			);
 
----
+			CREATE TABLE IF NOT EXISTS
+			subscriptions (
+				subscription_id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL, --  REFERENCES accounts(account_id)
+				feed_id INTEGER NOT NULL REFERENCES feeds(feed_id),
+				created TIMESTAMPTZ NOT NULL,
 
-CREATE TABLE IF NOT EXISTS
-subscriptions (
-	subscription_id SERIAL PRIMARY KEY,
-	account_id INTEGER NOT NULL, --  REFERENCES accounts(account_id)
-	feed_id INTEGER NOT NULL REFERENCES feeds(feed_id),
-	created TIMESTAMPTZ NOT NULL,
+				UNIQUE (account_id, feed_id)
+			);
 
-	UNIQUE (account_id, feed_id)
-);
+			CREATE TABLE IF NOT EXISTS
+			entries (
+				entry_id SERIAL PRIMARY KEY,
+				feed_id INTEGER REFERENCES feeds(feed_id), -- points to user bookmark feed when bookmark
+				title TEXT NOT NULL,
+				url TEXT NOT NULL,
+				created TIMESTAMPTZ NOT NULL,
+				published TIMESTAMPTZ NOT NULL, -- same as created for bookmarks
+				word_count INTEGER NOT NULL default 0,
 
----
+				UNIQUE(feed_id, url)
+			);
 
-CREATE TABLE IF NOT EXISTS
-entries (
-	entry_id SERIAL PRIMARY KEY,
-	feed_id INTEGER REFERENCES feeds(feed_id), -- points to user bookmark feed when bookmark
-	title TEXT NOT NULL,
-	url TEXT NOT NULL,
-	created TIMESTAMPTZ NOT NULL,
-	published TIMESTAMPTZ NOT NULL, -- same as created for bookmarks
-	word_count INTEGER NOT NULL default 0,
+			-- TODO create index for created/subscriptions and replace the one below
+			CREATE INDEX IF NOT EXISTS entries_created_idx ON entries (created);
 
-	UNIQUE(feed_id, url)
-);
+			CREATE OR REPLACE FUNCTION
+			subscribe(account_id integer, feed_url text, title text, now timestamptz) RETURNS INTEGER AS $$
+			DECLARE
+				fid INTEGER;
+			BEGIN
+				SELECT feed_id INTO fid FROM feeds WHERE url = feed_url LIMIT 1;
+				IF NOT FOUND THEN
+					-- create new feeds with update time == 0, so that all entries
+					-- fetched it first run will be accepted
+					INSERT INTO feeds (url, title, updated, owned_by)
+						VALUES (feed_url, title, cast('epoch' AS timestamptz), 0)
+						RETURNING feed_id
+						INTO fid;
+				END IF;
 
----
+				INSERT INTO subscriptions (account_id, feed_id, created)
+					VALUES (account_id, fid, now)
+					ON CONFLICT DO NOTHING;
 
-CREATE OR REPLACE FUNCTION
-subscribe(account_id integer, feed_url text, title text, now timestamptz) RETURNS INTEGER AS $$
-DECLARE
-	fid INTEGER;
-BEGIN
-	SELECT feed_id INTO fid FROM feeds WHERE url = feed_url LIMIT 1;
-	IF NOT FOUND THEN
-		-- create new feeds with update time == 0, so that all entries
-		-- fetched it first run will be accepted
-		INSERT INTO feeds (url, title, updated, owned_by)
-			VALUES (feed_url, title, cast('epoch' AS timestamptz), 0)
-			RETURNING feed_id
-			INTO fid;
-	END IF;
+				RETURN fid;
+			END;
+			$$ LANGUAGE plpgsql;
+		`,
+		Down: `
+			DROP FUNCTION subscribe(integer, text, text, timestamptz);
+			DROP TABLE entries;
+			DROP TABLE subscriptions;
+			DROP TABLE feeds;
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "create entry states",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			entry_states (
+				account_id INTEGER NOT NULL, --  REFERENCES accounts(account_id)
+				entry_id INTEGER NOT NULL REFERENCES entries(entry_id) ON DELETE CASCADE,
+				read BOOLEAN NOT NULL DEFAULT false,
+				starred BOOLEAN NOT NULL DEFAULT false,
+				updated TIMESTAMPTZ NOT NULL,
 
-	INSERT INTO subscriptions (account_id, feed_id, created)
-		VALUES (account_id, fid, now)
-		ON CONFLICT DO NOTHING;
+				PRIMARY KEY (account_id, entry_id)
+			);
+		`,
+		Down: `
+			DROP TABLE entry_states;
+		`,
+	},
+	{
+		Version: 3,
+		Name:    "add feeds autorefresh",
+		Up: `
+			ALTER TABLE feeds ADD COLUMN IF NOT EXISTS autorefresh BOOLEAN NOT NULL DEFAULT true;
+			-- owned feeds are not fetched
+			UPDATE feeds SET autorefresh = false WHERE owned_by != 0;
+		`,
+		Down: `
+			ALTER TABLE feeds DROP COLUMN autorefresh;
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "create feed tokens",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			feed_tokens (
+				account_id INTEGER PRIMARY KEY, --  REFERENCES accounts(account_id)
+				token TEXT NOT NULL UNIQUE,
+				created TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE feed_tokens;
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "create shares",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			shares (
+				share_id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL, --  REFERENCES accounts(account_id)
+				feed_id INTEGER NOT NULL UNIQUE REFERENCES feeds(feed_id) ON DELETE CASCADE,
+				token TEXT NOT NULL UNIQUE,
+				title TEXT NOT NULL,
+				created TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE shares;
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "create bookmarks",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			bookmarks (
+				entry_id INTEGER PRIMARY KEY REFERENCES entries(entry_id) ON DELETE CASCADE,
+				account_id INTEGER NOT NULL, --  REFERENCES accounts(account_id)
+				tags TEXT[] NOT NULL DEFAULT '{}',
+				note TEXT NOT NULL DEFAULT '',
+				selection TEXT NOT NULL DEFAULT '',
+				updated TIMESTAMPTZ NOT NULL
+			);
 
-	RETURN fid;
-END;
-$$ LANGUAGE plpgsql;
+			CREATE INDEX IF NOT EXISTS bookmarks_tags_idx ON bookmarks USING GIN (tags);
 
-
-`
+			-- so far all entries of owned feeds were bookmarks
+			INSERT INTO bookmarks (entry_id, account_id, updated)
+				SELECT e.entry_id, f.owned_by, e.created
+				FROM entries e INNER JOIN feeds f ON e.feed_id = f.feed_id
+				WHERE f.owned_by != 0
+			ON CONFLICT DO NOTHING;
+		`,
+		Down: `
+			DROP TABLE bookmarks;
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "create archives",
+		Up: `
+			CREATE TABLE IF NOT EXISTS
+			archives (
+				entry_id INTEGER PRIMARY KEY REFERENCES entries(entry_id) ON DELETE CASCADE,
+				account_id INTEGER NOT NULL, --  REFERENCES accounts(account_id)
+				blob_key TEXT NOT NULL DEFAULT '',
+				size INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				created TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE archives;
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "add entry content",
+		Up: `
+			ALTER TABLE entries ADD COLUMN IF NOT EXISTS content TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+			ALTER TABLE entries DROP COLUMN content;
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "add shares tag",
+		Up: `
+			-- empty tag shares all bookmarks
+			ALTER TABLE shares ADD COLUMN IF NOT EXISTS tag TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+			ALTER TABLE shares DROP COLUMN tag;
+		`,
+	},
+}
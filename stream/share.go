@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/ui"
+	"github.com/husio/web"
+)
+
+// ShareHandler serves public page of shared bookmarks, or its Atom feed if
+// requested path ends with ".atom". Both are available without
+// authentication.
+func ShareHandler(
+	manager Manager,
+	tmpl ui.Renderer,
+	site string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, format := web.PathArg(r, 0), web.PathArg(r, 1)
+
+		share, err := manager.ShareByToken(r.Context(), token)
+		switch err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			tmpl.RenderStd(w, http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot get share: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		entries, err := manager.TaggedBookmarks(r.Context(), share.AccountID, share.Tag, syndicatedEntries)
+		if err != nil {
+			log.Printf("cannot list shared bookmarks: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		feedURL := site + share.Path() + ".atom"
+		if format == ".atom" {
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+			if err := writeAtom(w, feedURL, site, share.Title, entries); err != nil {
+				log.Printf("cannot write share feed: %s", err)
+			}
+			return
+		}
+
+		content := struct {
+			Share   *Share
+			Entries []*Entry
+			PageURL string
+			FeedURL string
+		}{
+			Share:   share,
+			Entries: entries,
+			PageURL: site + share.Path(),
+			FeedURL: feedURL,
+		}
+		tmpl.Render(w, "share.tmpl", content, http.StatusOK)
+	}
+}
+
+// CreateShareHandler makes bookmarks of the current account public. If tag
+// is given, only bookmarks with that tag are shared.
+func CreateShareHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		title := strings.TrimSpace(r.FormValue("title"))
+		tag := r.FormValue("tag")
+		if _, err := manager.Share(r.Context(), user.AccountID, title, tag); err != nil {
+			log.Printf("cannot share bookmarks: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/subscriptions", http.StatusSeeOther)
+	}
+}
+
+// RemoveShareHandler makes shared bookmarks private again.
+func RemoveShareHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		shareID, err := strconv.ParseInt(web.PathArg(r, 0), 10, 64)
+		if err != nil {
+			tmpl.RenderStd(w, http.StatusBadRequest)
+			return
+		}
+		if err := manager.Unshare(r.Context(), user.AccountID, shareID); err != nil {
+			log.Printf("cannot remove share %d: %s", shareID, err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/subscriptions", http.StatusSeeOther)
+	}
+}
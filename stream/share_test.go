@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/web"
+)
+
+type shareManager struct {
+	stubManager
+	bookmarks []*Entry
+}
+
+func (m *shareManager) ShareByToken(ctx context.Context, token string) (*Share, error) {
+	if token != "ab12" {
+		return nil, pg.ErrNotFound
+	}
+	return &Share{ShareID: 3, AccountID: 1, FeedID: 7, Token: token, Title: "Reading list"}, nil
+}
+
+func (m *shareManager) TaggedBookmarks(ctx context.Context, accountID int64, tag string, limit int) ([]*Entry, error) {
+	return m.bookmarks, nil
+}
+
+type stubRenderer struct {
+	name string
+	code int
+}
+
+func (r *stubRenderer) Render(w http.ResponseWriter, name string, content interface{}, code int) {
+	r.name, r.code = name, code
+	w.WriteHeader(code)
+}
+
+func (r *stubRenderer) RenderStd(w http.ResponseWriter, code int) {
+	r.name, r.code = "", code
+	w.WriteHeader(code)
+}
+
+func TestShareHandler(t *testing.T) {
+	m := &shareManager{
+		bookmarks: []*Entry{
+			{EntryID: 5, Title: "Shared", URL: "http://example.com/5"},
+		},
+	}
+	tmpl := &stubRenderer{}
+
+	rt := web.NewRouter()
+	rt.Add(`/s/(token:[0-9a-f]+)(format:\.atom|)`, "GET", ShareHandler(m, tmpl, "http://feedstream.example"))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/s/ab12"); w.Code != http.StatusOK || tmpl.name != "share.tmpl" {
+		t.Fatalf("want share page, got %d %q", w.Code, tmpl.name)
+	}
+
+	w := get("/s/ab12.atom")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	var feed struct {
+		ID      string `xml:"id"`
+		Title   string `xml:"title"`
+		Entries []struct {
+			ID string `xml:"id"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("cannot decode atom: %s", err)
+	}
+	if feed.ID != "http://feedstream.example/s/ab12.atom" || feed.Title != "Reading list" || len(feed.Entries) != 1 {
+		t.Fatalf("unexpected feed: %+v", feed)
+	}
+
+	if w := get("/s/ffff"); w.Code != http.StatusNotFound {
+		t.Fatalf("want 404 for unknown share, got %d", w.Code)
+	}
+}
+
+func TestShareToken(t *testing.T) {
+	cases := map[string]string{
+		"http://feedstream.example/s/ab12":       "ab12",
+		"https://feedstream.example/s/ab12.atom": "ab12",
+		"https://FeedStream.example/s/ab12":      "ab12",
+		"/s/ab12":                                "ab12",
+		"https://other.example/s/ab12":           "",
+		"http://feedstream.example:8000/s/ab12":  "",
+		"http://feedstream.example/s/ab12/feed":  "",
+		"http://feedstream.example/feed.atom":    "",
+	}
+	for raw, want := range cases {
+		got, ok := shareToken(raw, "feedstream.example")
+		if got != want || ok != (want != "") {
+			t.Errorf("%q: want %q, got %q (%v)", raw, want, got, ok)
+		}
+	}
+}
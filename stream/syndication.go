@@ -0,0 +1,245 @@
+package stream
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/husio/feedstream/auth"
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/feedstream/ui"
+	"github.com/husio/web"
+)
+
+// syndicatedEntries is the maximum number of entries included in published
+// feed.
+const syndicatedEntries = 100
+
+// SyndicationHandler publishes bookmarks, bookmarks with a single tag or all
+// subscribed entries of an account as Atom or RSS document. Account is
+// identified by feed token, so that feeds can be consumed by clients that
+// cannot authenticate.
+func SyndicationHandler(
+	manager Manager,
+	site string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, name, format := web.PathArg(r, 0), web.PathArg(r, 1), web.PathArg(r, 2)
+
+		accountID, err := manager.FeedTokenAccount(r.Context(), token)
+		switch err {
+		case nil:
+			// all good
+		case pg.ErrNotFound:
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		default:
+			log.Printf("cannot get feed token account: %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		var (
+			title   string
+			entries []*Entry
+		)
+		switch {
+		case name == "bookmarks":
+			title = "Bookmarks"
+			entries, err = manager.TaggedBookmarks(r.Context(), accountID, "", syndicatedEntries)
+		case strings.HasPrefix(name, "tag/"):
+			tag := normalizeTag(name[len("tag/"):])
+			if tag == "" {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			title = "Bookmarks tagged " + tag
+			entries, err = manager.TaggedBookmarks(r.Context(), accountID, tag, syndicatedEntries)
+		case name == "all":
+			title = "All subscriptions"
+			entries, err = manager.EntriesPage(r.Context(), accountID, EntryQuery{Limit: syndicatedEntries})
+		default:
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("cannot list %s entries: %s", name, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		self := fmt.Sprintf("%s/u/%s/%s.%s", site, token, name, format)
+		switch format {
+		case "atom":
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+			err = writeAtom(w, self, site, title, entries)
+		case "rss":
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+			err = writeRSS(w, self, site, title, entries)
+		default:
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("cannot write %s feed: %s", format, err)
+		}
+	}
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Author  atomAuthor   `xml:"author"`
+	Links   []atomLink   `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+}
+
+func writeAtom(w io.Writer, self, site, title string, entries []*Entry) error {
+	feed := atomFeed{
+		ID:      self,
+		Title:   title,
+		Updated: lastPublished(entries).Format(time.RFC3339),
+		Author:  atomAuthor{Name: "feedstream"},
+		Links: []atomLink{
+			{Rel: "self", Href: self},
+			{Rel: "alternate", Href: site + "/"},
+		},
+	}
+	for _, e := range entries {
+		feed.Entries = append(feed.Entries, &atomEntry{
+			ID:        entryURN(e),
+			Title:     e.Title,
+			Link:      atomLink{Rel: "alternate", Href: e.URL},
+			Published: e.Published.Format(time.RFC3339),
+			Updated:   e.Published.Format(time.RFC3339),
+		})
+	}
+	return writeXML(w, feed)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string  `xml:"title"`
+	Link    string  `xml:"link"`
+	GUID    rssGUID `xml:"guid"`
+	PubDate string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func writeRSS(w io.Writer, self, site, title string, entries []*Entry) error {
+	doc := rssDocument{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          site + "/",
+			Description:   title + " published by feedstream",
+			LastBuildDate: lastPublished(entries).Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range entries {
+		doc.Channel.Items = append(doc.Channel.Items, &rssItem{
+			Title:   e.Title,
+			Link:    e.URL,
+			GUID:    rssGUID{Value: entryURN(e)},
+			PubDate: e.Published.Format(time.RFC1123Z),
+		})
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	return enc.Encode(v)
+}
+
+// entryURN returns globally unique, stable identifier of an entry.
+func entryURN(e *Entry) string {
+	return fmt.Sprintf("urn:feedstream:entry:%d", e.EntryID)
+}
+
+func lastPublished(entries []*Entry) time.Time {
+	var last time.Time
+	for _, e := range entries {
+		if e.Published.After(last) {
+			last = e.Published
+		}
+	}
+	if last.IsZero() {
+		return time.Unix(0, 0).UTC()
+	}
+	return last
+}
+
+// ResetFeedTokenHandler replaces feed token of the current account, so that
+// previously shared feed URLs stop working.
+func ResetFeedTokenHandler(
+	manager Manager,
+	authSrv auth.AuthService,
+	tmpl ui.Renderer,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authSrv.CurrentUser(r.Context(), r)
+		switch err {
+		case nil:
+			// all good
+		case auth.ErrNotAuthenticated:
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		default:
+			log.Printf("cannot get current user: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := manager.ResetFeedToken(r.Context(), user.AccountID); err != nil {
+			log.Printf("cannot reset feed token: %s", err)
+			tmpl.RenderStd(w, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/subscriptions", http.StatusSeeOther)
+	}
+}
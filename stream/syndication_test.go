@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/husio/feedstream/pg"
+	"github.com/husio/web"
+)
+
+type syndicationManager struct {
+	stubManager
+	bookmarks []*Entry
+	tags      []string
+}
+
+func (m *syndicationManager) FeedTokenAccount(ctx context.Context, token string) (int64, error) {
+	if token != "t0k3n" {
+		return 0, pg.ErrNotFound
+	}
+	return 1, nil
+}
+
+func (m *syndicationManager) TaggedBookmarks(ctx context.Context, accountID int64, tag string, limit int) ([]*Entry, error) {
+	if limit != syndicatedEntries {
+		return nil, fmt.Errorf("unexpected limit: %d", limit)
+	}
+	m.tags = append(m.tags, tag)
+	return m.bookmarks, nil
+}
+
+func TestSyndicationHandler(t *testing.T) {
+	published := time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+	m := &syndicationManager{
+		bookmarks: []*Entry{
+			{EntryID: 3, Title: "Bookmarked <page>", URL: "http://example.com/3", Published: published},
+		},
+	}
+	m.entries = []*Entry{
+		{EntryID: 4, Title: "First", URL: "http://example.com/4", Published: published},
+		{EntryID: 2, Title: "Second", URL: "http://example.com/2", Published: published.Add(-time.Hour)},
+	}
+
+	rt := web.NewRouter()
+	rt.Add(`/u/(token)/(name:bookmarks|all|tag/[^/]+)\.(format:atom|rss)`, "GET", SyndicationHandler(m, "http://feedstream.example"))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/u/t0k3n/bookmarks.atom")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	var feed struct {
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			ID    string `xml:"id"`
+			Title string `xml:"title"`
+			Link  struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("cannot decode atom: %s", err)
+	}
+	if feed.ID != "http://feedstream.example/u/t0k3n/bookmarks.atom" || feed.Updated != "2017-05-01T10:00:00Z" {
+		t.Fatalf("unexpected feed: %+v", feed)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != "Bookmarked <page>" || feed.Entries[0].Link.Href != "http://example.com/3" {
+		t.Fatalf("unexpected entries: %+v", feed.Entries)
+	}
+
+	w = get("/u/t0k3n/all.rss")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	var rss struct {
+		Version string `xml:"version,attr"`
+		Items   []struct {
+			Link string `xml:"link"`
+			GUID string `xml:"guid"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("cannot decode rss: %s", err)
+	}
+	if rss.Version != "2.0" || len(rss.Items) != 2 || rss.Items[0].GUID != "urn:feedstream:entry:4" {
+		t.Fatalf("unexpected rss: %+v", rss)
+	}
+	if q := m.queries[0]; q.Limit != syndicatedEntries {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	w = get("/u/t0k3n/tag/Go.lang.atom")
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+	var tagged struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &tagged); err != nil {
+		t.Fatalf("cannot decode atom: %s", err)
+	}
+	if tagged.ID != "http://feedstream.example/u/t0k3n/tag/Go.lang.atom" || tagged.Title != "Bookmarks tagged go.lang" {
+		t.Fatalf("unexpected feed: %+v", tagged)
+	}
+	if want := []string{"", "go.lang"}; !reflect.DeepEqual(m.tags, want) {
+		t.Fatalf("want %q tags queried, got %q", want, m.tags)
+	}
+
+	if w := get("/u/invalid/bookmarks.atom"); w.Code != http.StatusNotFound {
+		t.Fatalf("want 404 for invalid token, got %d", w.Code)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/husio/feedstream/sanitize"
 )
 
 type Renderer interface {
@@ -21,9 +23,35 @@ type renderService struct {
 
 var _ Renderer = (*renderService)(nil)
 
-func NewHTMLRenderer(glob string, debug bool) (Renderer, error) {
+// RendererOption changes the default configuration of HTML renderer.
+type RendererOption func(*rendererOptions)
+
+type rendererOptions struct {
+	sanitize []sanitize.Option
+}
+
+// WithSanitizeOptions configures "sanitize" template function.
+func WithSanitizeOptions(opts ...sanitize.Option) RendererOption {
+	return func(o *rendererOptions) {
+		o.sanitize = append(o.sanitize, opts...)
+	}
+}
+
+// NewHTMLRenderer returns renderer using templates matching given glob.
+//
+// Templates must not render HTML content from outside of the application
+// directly. Such content must be passed through "sanitize" function, that
+// takes the content and the URL of the page it comes from:
+//
+//	{{sanitize .Content .URL}}
+func NewHTMLRenderer(glob string, debug bool, opts ...RendererOption) (Renderer, error) {
+	var o rendererOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	if !debug {
-		r, err := renderer(glob, false)
+		r, err := renderer(glob, false, &o)
 		if err != nil {
 			return nil, err
 		}
@@ -32,7 +60,7 @@ func NewHTMLRenderer(glob string, debug bool) (Renderer, error) {
 
 	srv := &renderService{
 		render: func(w io.Writer, n string, c interface{}) error {
-			render, err := renderer(glob, true)
+			render, err := renderer(glob, true, &o)
 			if err != nil {
 				return err
 			}
@@ -43,12 +71,15 @@ func NewHTMLRenderer(glob string, debug bool) (Renderer, error) {
 	return srv, nil
 }
 
-func renderer(glob string, debug bool) (func(io.Writer, string, interface{}) error, error) {
+func renderer(glob string, debug bool, o *rendererOptions) (func(io.Writer, string, interface{}) error, error) {
 	tmpl, err := template.New("").Funcs(map[string]interface{}{
 		"debug": func() bool {
 			return debug
 		},
 		"timesince": fnTimesince,
+		"sanitize": func(content, baseURL string) template.HTML {
+			return template.HTML(sanitize.HTML(content, baseURL, o.sanitize...))
+		},
 	}).ParseGlob(glob)
 	if err != nil {
 		return nil, err